@@ -0,0 +1,40 @@
+package dynamodbstorage
+
+import "time"
+
+// LockBackoff decides how long Lock waits before checking a lock held by
+// another instance again, e.g. to poll with jitter.
+type LockBackoff interface {
+	// NextInterval returns the wait after the given number of previous
+	// attempts to acquire the lock, starting at 0
+	NextInterval(attempt int) time.Duration
+}
+
+// FixedBackoff waits the same Interval after every attempt. It is used by
+// default, with LockPollingInterval.
+type FixedBackoff struct {
+	Interval time.Duration
+}
+
+func (b FixedBackoff) NextInterval(attempt int) time.Duration {
+	return b.Interval
+}
+
+// ExponentialBackoff waits Start after the first attempt and doubles the wait
+// after each one, up to Max. It is used with LockPollingBackoff, starting at
+// 100ms up to LockPollingInterval.
+type ExponentialBackoff struct {
+	Start time.Duration
+	Max   time.Duration
+}
+
+func (b ExponentialBackoff) NextInterval(attempt int) time.Duration {
+	interval := b.Start
+	for i := 0; i < attempt && interval < b.Max; i++ {
+		interval *= 2
+	}
+	if interval > b.Max {
+		interval = b.Max
+	}
+	return interval
+}
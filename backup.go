@@ -0,0 +1,160 @@
+package dynamodbstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// backupEntry is one line of the JSON lines stream written by Export.
+// Value is base64 encoded by encoding/json.
+type backupEntry struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+
+	// Meta is the metadata stored with StoreWithMeta, if any
+	Meta map[string]string `json:"meta,omitempty"`
+
+	// ExpiresAt is when the item expires, as Unix time, if it was stored with a TTL
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+
+	// Version is the version written by StoreIfVersion, if any
+	Version int64 `json:"version,omitempty"`
+}
+
+// Export writes every key and value in the table to w as JSON lines of the
+// form {"key": "...", "value": "<base64>"}, e.g. to back them up outside of
+// DynamoDB. The metadata, expiry, and version of an item, if it has them, are
+// written along with it as "meta", "expires_at", and "version". Locks are left
+// out. The output can be restored with Import.
+func (s *Storage) Export(ctx context.Context, w io.Writer) error {
+	if err := s.initConfig(); err != nil {
+		return err
+	}
+
+	input := &dynamodb.ScanInput{
+		TableName:      aws.String(s.Table),
+		ConsistentRead: aws.Bool(true),
+	}
+
+	// skip other data sharing the table, and other tenants' items
+	var filters []string
+	if s.SortKeyAttribute != "" {
+		filters = append(filters, "#S = :s")
+		input.ExpressionAttributeNames = map[string]*string{
+			"#S": aws.String(s.SortKeyAttribute),
+		}
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":s": {
+				S: aws.String(s.SortKeyValue),
+			},
+		}
+	}
+	if s.keyPrefix != "" {
+		filters = append(filters, "begins_with(#D, :p)")
+		if input.ExpressionAttributeNames == nil {
+			input.ExpressionAttributeNames = make(map[string]*string)
+			input.ExpressionAttributeValues = make(map[string]*dynamodb.AttributeValue)
+		}
+		input.ExpressionAttributeNames["#D"] = aws.String(s.PrimaryKeyAttribute)
+		input.ExpressionAttributeValues[":p"] = &dynamodb.AttributeValue{
+			S: aws.String(s.keyPrefix),
+		}
+	}
+	if len(filters) > 0 {
+		input.FilterExpression = aws.String(strings.Join(filters, " AND "))
+	}
+
+	enc := json.NewEncoder(w)
+	var exportErr error
+	err := s.scanPages(ctx, input,
+		func(page *dynamodb.ScanOutput) bool {
+			for _, i := range page.Items {
+				if key, ok := s.listedKey(i); !ok || strings.HasPrefix(key, "LOCK-") {
+					continue
+				}
+				item, err := s.itemFromAttributes(i)
+				if err != nil {
+					exportErr = err
+					return false
+				}
+
+				var value []byte
+				if _, ok := i[partsAttribute]; ok {
+					stored, err := s.getItem(ctx, item.PrimaryKey)
+					if err != nil {
+						exportErr = fmt.Errorf("loading %s: %w", item.PrimaryKey, err)
+						return false
+					}
+					value = []byte(stored.Contents)
+				} else {
+					value, err = decodeContents(i, item.Contents)
+					if err != nil {
+						exportErr = fmt.Errorf("decoding %s: %w", item.PrimaryKey, err)
+						return false
+					}
+				}
+				entry := backupEntry{Key: item.PrimaryKey, Value: value, Meta: item.Meta}
+				if entry.ExpiresAt, err = numberAttribute(i, s.ExpiresAtAttribute); err != nil {
+					exportErr = fmt.Errorf("reading expiry of %s: %w", item.PrimaryKey, err)
+					return false
+				}
+				if entry.Version, err = numberAttribute(i, versionAttribute); err != nil {
+					exportErr = fmt.Errorf("reading version of %s: %w", item.PrimaryKey, err)
+					return false
+				}
+				if err := enc.Encode(entry); err != nil {
+					exportErr = err
+					return false
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return err
+	}
+
+	return exportErr
+}
+
+// Import stores every key and value read from r, in the format written by
+// Export, along with their metadata, expiry, and version. Existing values with
+// the same keys are overwritten.
+func (s *Storage) Import(ctx context.Context, r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for line := 1; ; line++ {
+		var entry backupEntry
+		err := dec.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading entry %d: %w", line, err)
+		}
+
+		var expiresAt time.Time
+		if entry.ExpiresAt != 0 {
+			expiresAt = time.Unix(entry.ExpiresAt, 0)
+		}
+		if err := s.store(ctx, entry.Key, entry.Value, expiresAt, entry.Meta, entry.Version); err != nil {
+			return fmt.Errorf("importing %s: %w", entry.Key, err)
+		}
+	}
+}
+
+// numberAttribute returns the value of the named number attribute, or 0 if it is missing
+func numberAttribute(attributes map[string]*dynamodb.AttributeValue, name string) (int64, error) {
+	value, ok := attributes[name]
+	if !ok || value == nil || value.N == nil {
+		return 0, nil
+	}
+	return strconv.ParseInt(aws.StringValue(value.N), 10, 64)
+}
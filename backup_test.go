@@ -0,0 +1,150 @@
+package dynamodbstorage
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestDynamoDBStorage_ExportImport(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	source := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	ctx := context.Background()
+
+	values := map[string]string{
+		"certificates/example.com/example.com.crt": "certificate",
+		"certificates/example.com/example.com.key": "private key",
+		"binary": "\x00\xff\x1f\x8b",
+	}
+	for key, value := range values {
+		if err := source.Store(ctx, key, []byte(value)); err != nil {
+			t.Errorf("error storing %s: %s", key, err.Error())
+			return
+		}
+	}
+	meta := map[string]string{"issuer": "example"}
+	if err := source.StoreWithMeta(ctx, "with-meta", []byte("meta"), meta); err != nil {
+		t.Errorf("error storing with meta: %s", err.Error())
+		return
+	}
+	if err := source.StoreWithTTL(ctx, "with-ttl", []byte("ttl"), time.Hour); err != nil {
+		t.Errorf("error storing with ttl: %s", err.Error())
+		return
+	}
+	for version := int64(0); version < 2; version++ {
+		if _, err := source.StoreIfVersion(ctx, "versioned", []byte("versioned"), version); err != nil {
+			t.Errorf("error storing version %d: %s", version+1, err.Error())
+			return
+		}
+	}
+	values["with-meta"] = "meta"
+	values["with-ttl"] = "ttl"
+	values["versioned"] = "versioned"
+
+	if err := source.Lock(ctx, "key"); err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+		return
+	}
+	defer source.Unlock(ctx, "key")
+
+	var backup bytes.Buffer
+	if err := source.Export(ctx, &backup); err != nil {
+		t.Errorf("error exporting: %s", err.Error())
+		return
+	}
+	if strings.Contains(backup.String(), "LOCK-") {
+		t.Errorf("locks should not be exported, got: %s", backup.String())
+	}
+	if lines := strings.Count(backup.String(), "\n"); lines != len(values) {
+		t.Errorf("expected %d exported values, got %d", len(values), lines)
+	}
+
+	target := Storage{
+		Table:         "CertMagicImportTest",
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	if err := target.initConfig(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := deleteTable(target.AwsSession, target.Table); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := target.EnsureTable(ctx); err != nil {
+		t.Errorf("failed to create table: %s", err.Error())
+		return
+	}
+	defer deleteTable(target.AwsSession, target.Table)
+
+	if err := target.Import(ctx, &backup); err != nil {
+		t.Errorf("error importing: %s", err.Error())
+		return
+	}
+
+	for key, value := range values {
+		loaded, err := target.Load(ctx, key)
+		if err != nil {
+			t.Errorf("error loading imported %s: %s", key, err.Error())
+			continue
+		}
+		if string(loaded) != value {
+			t.Errorf("imported value of %s does not match, expected: %q, got: %q", key, value, loaded)
+		}
+	}
+
+	if _, loadedMeta, err := target.LoadWithMeta(ctx, "with-meta"); err != nil {
+		t.Errorf("error loading imported meta: %s", err.Error())
+	} else if !reflect.DeepEqual(loadedMeta, meta) {
+		t.Errorf("imported meta does not match, expected: %v, got: %v", meta, loadedMeta)
+	}
+
+	expiresAt := func(s *Storage) string {
+		out, err := s.client().GetItemWithContext(ctx, &dynamodb.GetItemInput{
+			Key:       s.itemKey("with-ttl"),
+			TableName: aws.String(s.Table),
+		})
+		if err != nil {
+			t.Errorf("error getting item: %s", err.Error())
+			return ""
+		}
+		return aws.StringValue(out.Item[s.ExpiresAtAttribute].N)
+	}
+	if source, target := expiresAt(&source), expiresAt(&target); source == "" || source != target {
+		t.Errorf("imported expiry does not match, expected: %q, got: %q", source, target)
+	}
+
+	if version, err := target.StoreIfVersion(ctx, "versioned", []byte("versioned"), 2); err != nil {
+		t.Errorf("expected the imported version to be 2, got error: %s", err.Error())
+	} else if version != 3 {
+		t.Errorf("expected the next version to be 3, got: %d", version)
+	}
+}
+
+func TestDynamoDBStorage_ImportInvalid(t *testing.T) {
+	storage := Storage{Table: TestTableName, ReadOnly: true}
+
+	err := storage.Import(context.Background(), strings.NewReader(`{"key": "a", "value": "not base64!"}`))
+	if err == nil || !strings.Contains(err.Error(), "entry 1") {
+		t.Errorf("importing an invalid entry should error, got: %v", err)
+	}
+}
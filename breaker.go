@@ -0,0 +1,113 @@
+package dynamodbstorage
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"go.uber.org/zap"
+)
+
+const (
+	circuitBreakerWindow   = time.Minute
+	circuitBreakerCooldown = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned without calling DynamoDB while the circuit breaker
+// is open, after CircuitBreakerThreshold consecutive failures.
+var ErrCircuitOpen = errors.New("circuit breaker is open: DynamoDB requests are failing")
+
+// circuitBreaker stops sending requests to DynamoDB for a cooldown period after
+// threshold consecutive requests failed within window. After the cooldown a
+// single request is let through to test whether DynamoDB has recovered: if it
+// succeeds the breaker closes again, and if it fails the cooldown starts over.
+type circuitBreaker struct {
+	threshold int
+	window    time.Duration
+	cooldown  time.Duration
+	logger    *zap.Logger
+
+	mu           sync.Mutex
+	failures     int
+	firstFailure time.Time
+	openedAt     time.Time
+	open         bool
+	probing      bool
+}
+
+func newCircuitBreaker(threshold int, window, cooldown time.Duration, logger *zap.Logger) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		window:    window,
+		cooldown:  cooldown,
+		logger:    logger,
+	}
+}
+
+// allow returns ErrCircuitOpen if a request must not be sent
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if !b.open {
+		return nil
+	}
+	if b.probing || time.Since(b.openedAt) < b.cooldown {
+		return ErrCircuitOpen
+	}
+	b.probing = true
+	return nil
+}
+
+// record updates the breaker with the result of a request that was sent
+func (b *circuitBreaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case isCanceled(err):
+		// says nothing about DynamoDB, so a canceled probe just lets another request try
+		b.probing = false
+	case isFailure(err):
+		now := time.Now()
+		if b.failures == 0 || now.Sub(b.firstFailure) > b.window {
+			b.failures, b.firstFailure = 0, now
+		}
+		b.failures++
+		if b.probing || (!b.open && b.failures >= b.threshold) {
+			if !b.open {
+				b.logger.Warn("opening circuit breaker after consecutive DynamoDB failures",
+					zap.Int("failures", b.failures), zap.Error(err))
+			}
+			b.open, b.openedAt, b.probing = true, now, false
+		}
+	default:
+		if b.open && b.probing {
+			b.logger.Info("closing circuit breaker, DynamoDB requests are succeeding again")
+		}
+		if b.probing {
+			b.open, b.probing = false, false
+		}
+		if !b.open {
+			b.failures = 0
+		}
+	}
+}
+
+// allowRequest is a Validate handler that fails the request with
+// ErrCircuitOpen before it's sent while the breaker is open
+func (b *circuitBreaker) allowRequest(r *request.Request) {
+	if err := b.allow(); err != nil {
+		r.Error = err
+	}
+}
+
+// recordResult is a Complete handler that records the outcome of each request
+// once its retries are done. Requests failed by the breaker itself are skipped.
+func (b *circuitBreaker) recordResult(r *request.Request) {
+	if errors.Is(r.Error, ErrCircuitOpen) {
+		return
+	}
+	b.record(r.Error)
+}
@@ -0,0 +1,123 @@
+package dynamodbstorage
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+func TestDynamoDBStorage_CircuitBreaker(t *testing.T) {
+	var calls int
+	failing := true
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			calls++
+			if failing {
+				r.HTTPResponse.StatusCode = http.StatusInternalServerError
+				r.Error = awserr.NewRequestFailure(
+					awserr.New("InternalServerError", "internal server error", nil), http.StatusInternalServerError, "id")
+				return
+			}
+			r.Data.(*dynamodb.GetItemOutput).Item = mockItem("key", "value")
+		}),
+		CircuitBreakerThreshold: 3,
+		CircuitBreakerCooldown:  caddy.Duration(50 * time.Millisecond),
+	}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if _, err := storage.Load(ctx, "key"); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("expected the request to fail, got: %v", err)
+		}
+	}
+
+	// open: fails fast without calling DynamoDB
+	if _, err := storage.Load(ctx, "key"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen once the breaker opens, got: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected no request while the breaker is open, got %d requests", calls)
+	}
+
+	// half-open: a single request is let through, and opens the breaker again when it fails
+	time.Sleep(60 * time.Millisecond)
+	if _, err := storage.Load(ctx, "key"); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected the test request to be sent and fail, got: %v", err)
+	}
+	if _, err := storage.Load(ctx, "key"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected ErrCircuitOpen after the test request failed, got: %v", err)
+	}
+	if calls != 4 {
+		t.Errorf("expected a single test request after the cooldown, got %d requests", calls)
+	}
+
+	// recovery: a successful test request closes the breaker
+	failing = false
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if value, err := storage.Load(ctx, "key"); err != nil || string(value) != "value" {
+			t.Errorf("expected the breaker to close after recovering, got: %s, %v", value, err)
+		}
+	}
+	if calls != 7 {
+		t.Errorf("expected every request to be sent once closed, got %d requests", calls)
+	}
+}
+
+func TestDynamoDBStorage_CircuitBreakerIgnoresRejections(t *testing.T) {
+	var calls int
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			calls++
+			r.HTTPResponse.StatusCode = http.StatusBadRequest
+			r.Error = awserr.NewRequestFailure(
+				awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "conditional request failed", nil),
+				http.StatusBadRequest, "id")
+		}),
+		CircuitBreakerThreshold: 1,
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := storage.DeleteIf(context.Background(), "key", []byte("value")); errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("a failed condition should not open the breaker")
+		}
+	}
+	if calls != 3 {
+		t.Errorf("expected every request to be sent, got %d requests", calls)
+	}
+}
+
+func TestCircuitBreaker_Window(t *testing.T) {
+	breaker := newCircuitBreaker(2, 20*time.Millisecond, time.Minute, zap.NewNop())
+	failure := awserr.New(request.ErrCodeRequestError, "send request failed", nil)
+
+	// failures further apart than the window aren't counted together
+	breaker.record(failure)
+	time.Sleep(30 * time.Millisecond)
+	breaker.record(failure)
+	if err := breaker.allow(); err != nil {
+		t.Errorf("expected the breaker to stay closed, got: %v", err)
+	}
+
+	// and a success in between resets the count
+	breaker.record(nil)
+	breaker.record(failure)
+	if err := breaker.allow(); err != nil {
+		t.Errorf("expected the breaker to stay closed after a success, got: %v", err)
+	}
+
+	breaker.record(failure)
+	if err := breaker.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("expected the breaker to open after consecutive failures, got: %v", err)
+	}
+}
@@ -0,0 +1,99 @@
+package dynamodbstorage
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// itemCache is a size-bounded, least recently used cache of items
+// loaded from DynamoDB. Entries expire after a fixed TTL so that
+// changes made by other instances are picked up eventually.
+// It is safe for concurrent use.
+type itemCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	maxItems int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key     string
+	item    Item
+	expires time.Time
+}
+
+func newItemCache(ttl time.Duration, maxItems int) *itemCache {
+	return &itemCache{
+		ttl:      ttl,
+		maxItems: maxItems,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached item for key if present and not yet expired.
+func (c *itemCache) get(key string) (Item, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return Item{}, false
+	}
+
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.removeElement(elem)
+		return Item{}, false
+	}
+
+	c.order.MoveToFront(elem)
+	return entry.item, true
+}
+
+// put adds or replaces the item for key, evicting the least
+// recently used entry if the cache is full.
+func (c *itemCache) put(key string, item Item) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.item = item
+		entry.expires = expires
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, item: item, expires: expires})
+	for c.order.Len() > c.maxItems {
+		c.removeElement(c.order.Back())
+	}
+}
+
+// remove drops key from the cache if present.
+func (c *itemCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.removeElement(elem)
+	}
+}
+
+// flush drops every entry from the cache.
+func (c *itemCache) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+func (c *itemCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,111 @@
+package dynamodbstorage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestItemCache_Evicts(t *testing.T) {
+	cache := newItemCache(time.Minute, 2)
+
+	cache.put("a", Item{Contents: "a"})
+	cache.put("b", Item{Contents: "b"})
+
+	// touch "a" so that "b" is the least recently used
+	if _, ok := cache.get("a"); !ok {
+		t.Errorf("expected a to be cached")
+		return
+	}
+
+	cache.put("c", Item{Contents: "c"})
+
+	if _, ok := cache.get("b"); ok {
+		t.Errorf("expected b to be evicted as least recently used")
+	}
+	for _, key := range []string{"a", "c"} {
+		item, ok := cache.get(key)
+		if !ok {
+			t.Errorf("expected %s to be cached", key)
+			continue
+		}
+		if item.Contents != key {
+			t.Errorf("cached contents do not match, expected: %s, got: %s", key, item.Contents)
+		}
+	}
+
+	cache.remove("a")
+	if _, ok := cache.get("a"); ok {
+		t.Errorf("expected a to be removed")
+	}
+}
+
+func TestItemCache_Expires(t *testing.T) {
+	cache := newItemCache(10*time.Millisecond, 10)
+
+	cache.put("a", Item{Contents: "a"})
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := cache.get("a"); ok {
+		t.Errorf("expected a to expire after the TTL")
+	}
+	if cache.order.Len() != 0 {
+		t.Errorf("expired entry should be dropped, cache still holds %v entries", cache.order.Len())
+	}
+}
+
+func TestItemCache_Concurrent(t *testing.T) {
+	cache := newItemCache(time.Minute, 10)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("key%d", (i+j)%20)
+				cache.put(key, Item{Contents: key})
+				cache.get(key)
+				cache.remove(key)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if cache.order.Len() > 10 {
+		t.Errorf("cache grew past its limit, holds %v entries", cache.order.Len())
+	}
+}
+
+func benchmarkLoad(b *testing.B, cacheTTL caddy.Duration) {
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			if out, ok := r.Data.(*dynamodb.GetItemOutput); ok {
+				out.Item = mockItem("key", "value")
+			}
+		}),
+		CacheTTL: cacheTTL,
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := storage.Load(context.Background(), "key"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLoad(b *testing.B) {
+	benchmarkLoad(b, 0)
+}
+
+func BenchmarkLoadCached(b *testing.B) {
+	benchmarkLoad(b, caddy.Duration(time.Minute))
+}
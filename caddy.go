@@ -1,23 +1,75 @@
 package dynamodbstorage
 
 import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
 	"github.com/caddyserver/certmagic"
 )
 
+// validateTimeout limits how long Validate waits for DynamoDB
+const validateTimeout = 10 * time.Second
+
 func init() {
-	caddy.RegisterModule(Storage{})
+	caddy.RegisterModule(new(Storage))
 }
 
 // CaddyModule returns the Caddy module information.
-func (Storage) CaddyModule() caddy.ModuleInfo {
+func (*Storage) CaddyModule() caddy.ModuleInfo {
 	return caddy.ModuleInfo{
 		ID:  "caddy.storage.dynamodb",
 		New: func() caddy.Module { return new(Storage) },
 	}
 }
 
+// Provision sets up the storage when Caddy loads the config, so that
+// configuration errors are reported right away rather than on first use.
+// It also creates the table if AutoCreateTable is set.
+func (s *Storage) Provision(ctx caddy.Context) error {
+	s.logger = ctx.Logger()
+	if err := s.initConfig(); err != nil {
+		return err
+	}
+
+	if s.AutoCreateTable {
+		return s.EnsureTable(ctx)
+	}
+	return nil
+}
+
+// Validate checks that the configured table can be reached, and that the
+// AWS principal has the permissions the storage needs if CheckPermissionsOnValidate is set.
+// The table is reached with a GetItem request, which the storage needs anyway, so that
+// Validate doesn't need permissions of its own.
+func (s *Storage) Validate() error {
+	if err := s.initConfig(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+
+	_, err := s.client().GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key:                      s.itemKey(permissionProbeKey),
+		ProjectionExpression:     aws.String("#K"),
+		ExpressionAttributeNames: map[string]*string{"#K": aws.String(s.PrimaryKeyAttribute)},
+		TableName:                aws.String(s.Table),
+	})
+	if err != nil {
+		return fmt.Errorf("checking table %s: %w", s.Table, err)
+	}
+	if s.CheckPermissionsOnValidate {
+		return s.CheckPermissions(ctx)
+	}
+	return nil
+}
+
 // CertMagicStorage converts s to a certmagic.Storage instance.
 func (s *Storage) CertMagicStorage() (certmagic.Storage, error) {
 	return s, nil
@@ -26,11 +78,25 @@ func (s *Storage) CertMagicStorage() (certmagic.Storage, error) {
 // UnmarshalCaddyfile sets up the storage module from Caddyfile tokens. Syntax:
 //
 // dynamodb <table_name> {
-//     aws_endpoint <endpoint>
-//     aws_region   <region>
+//     aws_endpoint          <endpoint>
+//     aws_region            <region>
+//     aws_profile           <profile>
+//     lock_timeout          <duration>
+//     lock_polling_interval <duration>
+//     lock_polling_backoff
+//     instance_id           <id>
+//     auto_create_table
+//     check_permissions
+//     list_consistent_read  <true|false>
+//     dax_endpoint          <endpoint>
 // }
 //
-// Only the table name is required.
+// Only the table name is required. The lock polling interval
+// must be shorter than the lock timeout. list_consistent_read
+// sets ListConsistentRead, which only applies to listings, as
+// loads are always strongly consistent.
+// In a Caddyfile, this goes in the storage global option, e.g.
+// "storage dynamodb CertMagic".
 func (s *Storage) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 	for d.Next() {
 		if !d.NextArg() {
@@ -50,10 +116,79 @@ func (s *Storage) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 					return d.ArgErr()
 				}
 				s.AwsRegion = d.Val()
+			case "aws_profile":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				s.AwsProfile = d.Val()
+			case "lock_timeout":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				timeout, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid lock_timeout '%s': %v", d.Val(), err)
+				}
+				s.LockTimeout = caddy.Duration(timeout)
+			case "lock_polling_interval":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				interval, err := caddy.ParseDuration(d.Val())
+				if err != nil {
+					return d.Errf("invalid lock_polling_interval '%s': %v", d.Val(), err)
+				}
+				s.LockPollingInterval = caddy.Duration(interval)
+			case "lock_polling_backoff":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				s.LockPollingBackoff = true
+			case "instance_id":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				s.InstanceID = d.Val()
+			case "auto_create_table":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				s.AutoCreateTable = true
+			case "check_permissions":
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+				s.CheckPermissionsOnValidate = true
+			case "list_consistent_read":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				consistent, err := strconv.ParseBool(d.Val())
+				if err != nil {
+					return d.Errf("invalid list_consistent_read '%s': must be true or false", d.Val())
+				}
+				s.ListConsistentRead = consistent
+			case "dax_endpoint":
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				s.DaxEndpoint = d.Val()
 			default:
 				return d.Errf("unrecognized parameter '%s'", d.Val())
 			}
 		}
+
+		timeout, interval := s.LockTimeout, s.LockPollingInterval
+		if timeout == 0 {
+			timeout = lockTimeoutMinutes
+		}
+		if interval == 0 {
+			interval = lockPollingInterval
+		}
+		if interval >= timeout {
+			return d.Errf("lock_polling_interval (%s) must be less than lock_timeout (%s)",
+				time.Duration(interval), time.Duration(timeout))
+		}
 	}
 	return nil
 }
@@ -61,5 +196,8 @@ func (s *Storage) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
 // Interface guards
 var (
 	_ caddy.StorageConverter = (*Storage)(nil)
+	_ caddy.Provisioner      = (*Storage)(nil)
+	_ caddy.Validator        = (*Storage)(nil)
+	_ caddy.CleanerUpper     = (*Storage)(nil)
 	_ caddyfile.Unmarshaler  = (*Storage)(nil)
 )
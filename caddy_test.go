@@ -0,0 +1,329 @@
+package dynamodbstorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestDynamoDBStorage_UnmarshalCaddyfile(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		wantErr  bool
+		expected *Storage
+	}{
+		{
+			name:     "table name only",
+			input:    `dynamodb CertMagic`,
+			expected: &Storage{Table: "CertMagic"},
+		},
+		{
+			name:    "table name is required",
+			input:   `dynamodb`,
+			wantErr: true,
+		},
+		{
+			name: "aws settings",
+			input: `dynamodb CertMagic {
+				aws_endpoint localhost:8000
+				aws_region us-east-1
+			}`,
+			expected: &Storage{Table: "CertMagic", AwsEndpoint: "localhost:8000", AwsRegion: "us-east-1"},
+		},
+		{
+			name: "lock timing",
+			input: `dynamodb CertMagic {
+				lock_timeout 2m
+				lock_polling_interval 2s
+			}`,
+			expected: &Storage{
+				Table:               "CertMagic",
+				LockTimeout:         caddy.Duration(2 * time.Minute),
+				LockPollingInterval: caddy.Duration(2 * time.Second),
+			},
+		},
+		{
+			name: "lock timeout only, longer than default polling interval",
+			input: `dynamodb CertMagic {
+				lock_timeout 10s
+			}`,
+			expected: &Storage{Table: "CertMagic", LockTimeout: caddy.Duration(10 * time.Second)},
+		},
+		{
+			name: "polling interval longer than timeout",
+			input: `dynamodb CertMagic {
+				lock_timeout 1s
+				lock_polling_interval 2s
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "polling interval equal to timeout",
+			input: `dynamodb CertMagic {
+				lock_timeout 2s
+				lock_polling_interval 2s
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "lock timeout shorter than default polling interval",
+			input: `dynamodb CertMagic {
+				lock_timeout 1s
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "lock polling backoff",
+			input: `dynamodb CertMagic {
+				lock_polling_backoff
+			}`,
+			expected: &Storage{Table: "CertMagic", LockPollingBackoff: true},
+		},
+		{
+			name: "lock polling backoff takes no arguments",
+			input: `dynamodb CertMagic {
+				lock_polling_backoff yes
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "aws profile",
+			input: `dynamodb CertMagic {
+				aws_profile dev
+			}`,
+			expected: &Storage{Table: "CertMagic", AwsProfile: "dev"},
+		},
+		{
+			name: "instance id",
+			input: `dynamodb CertMagic {
+				instance_id node-1
+			}`,
+			expected: &Storage{Table: "CertMagic", InstanceID: "node-1"},
+		},
+		{
+			name: "dax endpoint",
+			input: `dynamodb CertMagic {
+				dax_endpoint dax://my-cluster.abc123.dax-clusters.us-east-1.amazonaws.com
+			}`,
+			expected: &Storage{
+				Table:       "CertMagic",
+				DaxEndpoint: "dax://my-cluster.abc123.dax-clusters.us-east-1.amazonaws.com",
+			},
+		},
+		{
+			name: "dax endpoint requires a value",
+			input: `dynamodb CertMagic {
+				dax_endpoint
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "auto create table",
+			input: `dynamodb CertMagic {
+				auto_create_table
+			}`,
+			expected: &Storage{Table: "CertMagic", AutoCreateTable: true},
+		},
+		{
+			name: "check permissions",
+			input: `dynamodb CertMagic {
+				check_permissions
+			}`,
+			expected: &Storage{Table: "CertMagic", CheckPermissionsOnValidate: true},
+		},
+		{
+			name: "list consistent read",
+			input: `dynamodb CertMagic {
+				list_consistent_read true
+			}`,
+			expected: &Storage{Table: "CertMagic", ListConsistentRead: true},
+		},
+		{
+			name: "eventually consistent list",
+			input: `dynamodb CertMagic {
+				list_consistent_read false
+			}`,
+			expected: &Storage{Table: "CertMagic"},
+		},
+		{
+			name: "list consistent read must be a boolean",
+			input: `dynamodb CertMagic {
+				list_consistent_read sometimes
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "list consistent read requires a value",
+			input: `dynamodb CertMagic {
+				list_consistent_read
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "invalid duration",
+			input: `dynamodb CertMagic {
+				lock_timeout soon
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "missing duration",
+			input: `dynamodb CertMagic {
+				lock_polling_interval
+			}`,
+			wantErr: true,
+		},
+		{
+			name: "unrecognized parameter",
+			input: `dynamodb CertMagic {
+				lock_refresh_interval 1m
+			}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := Storage{}
+			err := s.UnmarshalCaddyfile(caddyfile.NewTestDispenser(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("UnmarshalCaddyfile() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if !reflect.DeepEqual(tt.expected, &s) {
+				t.Errorf("Expected does not match actual: %+v != %+v", tt.expected, &s)
+			}
+		})
+	}
+}
+
+func TestDynamoDBStorage_UnmarshalCaddyfileGlobalOption(t *testing.T) {
+	input := `storage dynamodb CertMagic {
+		aws_region    us-east-1
+		lock_timeout  2m
+		instance_id   node-1
+	}`
+
+	// the same steps as Caddy's parsing of the storage global option
+	d := caddyfile.NewTestDispenser(input)
+	if !d.Next() || !d.Next() {
+		t.Fatal("expected the option and module names")
+	}
+	module, err := caddyfile.UnmarshalModule(d, "caddy.storage."+d.Val())
+	if err != nil {
+		t.Errorf("failed to unmarshal storage global option: %s", err.Error())
+		return
+	}
+
+	storage, ok := module.(*Storage)
+	if !ok {
+		t.Errorf("expected a *Storage, got: %T", module)
+		return
+	}
+	expected := &Storage{
+		Table:       "CertMagic",
+		AwsRegion:   "us-east-1",
+		LockTimeout: caddy.Duration(2 * time.Minute),
+		InstanceID:  "node-1",
+	}
+	if !reflect.DeepEqual(storage, expected) {
+		t.Errorf("storage config does not match, expected: %+v, got: %+v", expected, storage)
+	}
+}
+
+func TestDynamoDBStorage_Provision(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	s := Storage{Table: "CertMagic", AwsSession: newMockSession(func(r *request.Request) {})}
+	if err := s.Provision(ctx); err != nil {
+		t.Errorf("Provision() error = %v", err)
+		return
+	}
+	if s.logger == nil || s.locks == nil {
+		t.Errorf("Provision should set up the storage, got: %+v", &s)
+	}
+
+	s = Storage{}
+	if err := s.Provision(ctx); !errors.Is(err, ErrTableRequired) {
+		t.Errorf("Provision without a table should return ErrTableRequired, got: %v", err)
+	}
+
+	s = Storage{Table: "CertMagic", LockTimeout: caddy.Duration(time.Second)}
+	if err := s.Provision(ctx); err == nil {
+		t.Errorf("Provision with a polling interval longer than the lock timeout should error")
+	}
+}
+
+func TestDynamoDBStorage_ProvisionAutoCreateTable(t *testing.T) {
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+
+	newStorage := func() *Storage {
+		return &Storage{
+			Table:           "CertMagicAutoCreateTest",
+			AwsEndpoint:     os.Getenv("AWS_ENDPOINT"),
+			AwsRegion:       os.Getenv("AWS_DEFAULT_REGION"),
+			AwsDisableSSL:   DisableSSL,
+			AutoCreateTable: true,
+		}
+	}
+
+	s := newStorage()
+	if err := s.initConfig(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := deleteTable(s.AwsSession, s.Table); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// once to create the table, and again when it already exists
+	for i := 0; i < 2; i++ {
+		s := newStorage()
+		if err := s.Provision(ctx); err != nil {
+			t.Errorf("Provision() error = %v", err)
+			return
+		}
+		if err := s.Validate(); err != nil {
+			t.Errorf("table should exist after Provision, got: %v", err)
+			return
+		}
+	}
+}
+
+func TestDynamoDBStorage_Validate(t *testing.T) {
+	var getErr error
+	s := Storage{
+		Table: "CertMagic",
+		AwsSession: newMockSession(func(r *request.Request) {
+			// only needs the permissions the storage needs anyway
+			if r.Operation.Name != "GetItem" {
+				t.Errorf("unexpected request: %s", r.Operation.Name)
+			}
+			r.Error = getErr
+		}),
+	}
+
+	if err := s.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+
+	getErr = awserr.New(dynamodb.ErrCodeResourceNotFoundException, "table not found", nil)
+	if err := s.Validate(); !isResourceNotFound(err) {
+		t.Errorf("Validate should fail for a missing table, got: %v", err)
+	}
+}
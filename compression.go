@@ -0,0 +1,106 @@
+package dynamodbstorage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	compressionAttribute = "Compression"
+
+	// shorter values, like locks, hardly shrink and may even grow when compressed
+	minCompressedSize = 1024
+
+	// larger values aren't compressed, so that decompressing an item can be
+	// bounded, instead of a small item expanding to exhaust memory
+	maxCompressedSize = 16 * 1024 * 1024
+)
+
+// zstdEncoder and zstdDecoder are shared, as they are safe for concurrent use
+// of EncodeAll and DecodeAll and expensive to create
+var (
+	zstdEncoder = sync.OnceValues(func() (*zstd.Encoder, error) {
+		return zstd.NewWriter(nil)
+	})
+	zstdDecoder = sync.OnceValues(func() (*zstd.Decoder, error) {
+		return zstd.NewReader(nil, zstd.WithDecoderConcurrency(0),
+			zstd.WithDecoderMaxMemory(maxCompressedSize), zstd.WithDecoderMaxWindow(maxCompressedSize))
+	})
+)
+
+// compression magic bytes, which start every gzip member and zstd frame
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// sniffCompression returns the codec value looks compressed with, going by
+// its magic bytes, or "" if it doesn't start with any
+func sniffCompression(value []byte) string {
+	switch {
+	case bytes.HasPrefix(value, gzipMagic):
+		return "gzip"
+	case bytes.HasPrefix(value, zstdMagic):
+		return "zstd"
+	default:
+		return ""
+	}
+}
+
+// compress returns value compressed with codec, gzip or zstd
+func compress(codec string, value []byte) ([]byte, error) {
+	switch codec {
+	case "gzip":
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(value); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "zstd":
+		enc, err := zstdEncoder()
+		if err != nil {
+			return nil, err
+		}
+		return enc.EncodeAll(value, nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", codec)
+	}
+}
+
+// decompress returns value decompressed with codec, gzip or zstd, or an error
+// if it decompresses to more than maxCompressedSize
+func decompress(codec string, value []byte) ([]byte, error) {
+	switch codec {
+	case "gzip":
+		r, err := gzip.NewReader(bytes.NewReader(value))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		decompressed, err := io.ReadAll(io.LimitReader(r, maxCompressedSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(decompressed) > maxCompressedSize {
+			return nil, fmt.Errorf("decompressed value exceeds %d bytes", maxCompressedSize)
+		}
+		return decompressed, nil
+	case "zstd":
+		dec, err := zstdDecoder()
+		if err != nil {
+			return nil, err
+		}
+		return dec.DecodeAll(value, nil)
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", codec)
+	}
+}
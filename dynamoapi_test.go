@@ -0,0 +1,190 @@
+package dynamodbstorage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-dax-go/dax"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// mockDynamoAPI keeps items in memory by primary key, ignoring condition
+// expressions. Calling a method it doesn't implement panics.
+type mockDynamoAPI struct {
+	DynamoAPI
+
+	mu    sync.Mutex
+	items map[string]map[string]*dynamodb.AttributeValue
+	err   error
+	calls []string
+}
+
+func newMockDynamoAPI() *mockDynamoAPI {
+	return &mockDynamoAPI{items: map[string]map[string]*dynamodb.AttributeValue{}}
+}
+
+func (m *mockDynamoAPI) GetItemWithContext(_ aws.Context, input *dynamodb.GetItemInput, _ ...request.Option) (*dynamodb.GetItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, "GetItem")
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &dynamodb.GetItemOutput{Item: m.items[aws.StringValue(input.Key[primaryKeyAttribute].S)]}, nil
+}
+
+func (m *mockDynamoAPI) PutItemWithContext(_ aws.Context, input *dynamodb.PutItemInput, _ ...request.Option) (*dynamodb.PutItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, "PutItem")
+	if m.err != nil {
+		return nil, m.err
+	}
+	m.items[aws.StringValue(input.Item[primaryKeyAttribute].S)] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *mockDynamoAPI) DeleteItemWithContext(_ aws.Context, input *dynamodb.DeleteItemInput, _ ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, "DeleteItem")
+	if m.err != nil {
+		return nil, m.err
+	}
+	key := aws.StringValue(input.Key[primaryKeyAttribute].S)
+	old := m.items[key]
+	delete(m.items, key)
+	return &dynamodb.DeleteItemOutput{Attributes: old}, nil
+}
+
+func (m *mockDynamoAPI) ScanPagesWithContext(_ aws.Context, _ *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, _ ...request.Option) error {
+	m.mu.Lock()
+	m.calls = append(m.calls, "Scan")
+	if m.err != nil {
+		m.mu.Unlock()
+		return m.err
+	}
+	page := &dynamodb.ScanOutput{}
+	for _, item := range m.items {
+		page.Items = append(page.Items, item)
+	}
+	// fn may make requests of its own
+	m.mu.Unlock()
+
+	fn(page, true)
+	return nil
+}
+
+func TestDynamoDBStorage_DynamoAPI(t *testing.T) {
+	mock := newMockDynamoAPI()
+	storage := Storage{
+		Table:     TestTableName,
+		AwsRegion: "us-east-1",
+		DynamoAPI: mock,
+	}
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "key", []byte("value")); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+		return
+	}
+	if _, ok := mock.items["key"]; !ok {
+		t.Errorf("expected the item to be put through the mock, got: %v", mock.items)
+	}
+
+	contents, err := storage.Load(ctx, "key")
+	if err != nil || string(contents) != "value" {
+		t.Errorf("expected to load the stored value, got: %q, %v", contents, err)
+	}
+
+	if err := storage.Delete(ctx, "key"); err != nil {
+		t.Errorf("failed to delete: %s", err.Error())
+	}
+	if _, err := storage.Load(ctx, "key"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist after deleting, got: %v", err)
+	}
+
+	mock.err = awserr.New(dynamodb.ErrCodeInternalServerError, "internal error", nil)
+	if err := storage.Store(ctx, "key", []byte("value")); !errors.Is(err, mock.err) {
+		t.Errorf("expected the error returned by the mock, got: %v", err)
+	}
+}
+
+func TestDynamoDBStorage_DaxEndpoint(t *testing.T) {
+	var requests []string
+	storage := Storage{
+		Table:       TestTableName,
+		DaxEndpoint: "dax://127.0.0.1:8111",
+		AwsSession: newMockSession(func(r *request.Request) {
+			requests = append(requests, r.Operation.Name)
+		}),
+	}
+	if err := storage.initConfig(); err != nil {
+		t.Errorf("initConfig() error = %v", err)
+		return
+	}
+	if _, ok := storage.ReadAPI.(*dax.Dax); !ok {
+		t.Errorf("expected reads to go through a DAX client, got: %T", storage.ReadAPI)
+	}
+
+	// writes still go to DynamoDB
+	if err := storage.Store(context.Background(), "key", []byte("value")); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+	}
+	if !reflect.DeepEqual(requests, []string{"PutItem"}) {
+		t.Errorf("expected the write to go to DynamoDB, got: %v", requests)
+	}
+
+	if err := storage.Cleanup(); err != nil {
+		t.Errorf("Cleanup() error = %v", err)
+	}
+	if storage.ReadAPI != nil {
+		t.Errorf("expected Cleanup to close the DAX client")
+	}
+}
+
+func TestDynamoDBStorage_ReadAPI(t *testing.T) {
+	writes := newMockDynamoAPI()
+	// like DAX in front of the same table
+	reads := &mockDynamoAPI{items: writes.items}
+	storage := Storage{
+		Table:     TestTableName,
+		AwsRegion: "us-east-1",
+		DynamoAPI: writes,
+		ReadAPI:   reads,
+	}
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "key", []byte("value")); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+		return
+	}
+	contents, err := storage.Load(ctx, "key")
+	if err != nil || string(contents) != "value" {
+		t.Errorf("expected to load the stored value, got: %q, %v", contents, err)
+	}
+	keys, err := storage.List(ctx, "key", true)
+	if err != nil || !reflect.DeepEqual(keys, []string{"key"}) {
+		t.Errorf("expected to list the stored key, got: %v, %v", keys, err)
+	}
+	if err := storage.Delete(ctx, "key"); err != nil {
+		t.Errorf("failed to delete: %s", err.Error())
+	}
+
+	if !reflect.DeepEqual(reads.calls, []string{"GetItem", "Scan"}) {
+		t.Errorf("expected reads to use ReadAPI, got: %v", reads.calls)
+	}
+	for _, call := range writes.calls {
+		if call == "GetItem" || call == "Scan" {
+			t.Errorf("expected no reads through DynamoAPI, got: %v", writes.calls)
+			break
+		}
+	}
+}
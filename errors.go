@@ -0,0 +1,87 @@
+package dynamodbstorage
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// Codes of a StorageError, for handling errors without matching their messages
+const (
+	CodeTableRequired     = "table_required"
+	CodeEmptyKey          = "empty_key"
+	CodeReadOnly          = "read_only"
+	CodeNotFound          = "not_found"
+	CodeChecksumMismatch  = "checksum_mismatch"
+	CodeLockLost          = "lock_lost"
+	CodeLockNotHeld       = "lock_not_held"
+	CodeVersionConflict   = "version_conflict"
+	CodeCircuitOpen       = "circuit_open"
+	CodeThrottled         = "throttled"
+	CodeMissingPermission = "missing_permission"
+)
+
+// StorageError describes an error returned by Storage in fields that can be
+// handled programmatically or marshaled to JSON for API consumers. Use
+// AsStorageError to get one from any error returned by Storage.
+type StorageError struct {
+	// Code identifies the kind of error, one of the Code constants
+	Code string `json:"code"`
+
+	// Operation is the Storage method that failed, if known
+	Operation string `json:"operation,omitempty"`
+
+	// Key is the key the operation was called with, if known
+	Key string `json:"key,omitempty"`
+
+	// Message is the error message
+	Message string `json:"message"`
+
+	// Err is the underlying error, e.g. ErrLockLost
+	Err error `json:"-"`
+}
+
+func (e *StorageError) Error() string {
+	return e.Message
+}
+
+func (e *StorageError) Unwrap() error {
+	return e.Err
+}
+
+// storageErrorCodes are the codes of the sentinel errors, in the order they are checked
+var storageErrorCodes = []struct {
+	err  error
+	code string
+}{
+	{ErrTableRequired, CodeTableRequired},
+	{ErrEmptyKey, CodeEmptyKey},
+	{ErrReadOnly, CodeReadOnly},
+	{ErrChecksumMismatch, CodeChecksumMismatch},
+	{ErrLockLost, CodeLockLost},
+	{ErrLockNotHeld, CodeLockNotHeld},
+	{ErrCircuitOpen, CodeCircuitOpen},
+	{ErrThrottled, CodeThrottled},
+	{ErrMissingPermission, CodeMissingPermission},
+	{fs.ErrNotExist, CodeNotFound},
+}
+
+// AsStorageError returns the StorageError describing err, and false if err
+// isn't one of the errors defined by this package or fs.ErrNotExist.
+func AsStorageError(err error) (*StorageError, bool) {
+	var storageErr *StorageError
+	if errors.As(err, &storageErr) {
+		return storageErr, true
+	}
+
+	var conflict *VersionConflictError
+	if errors.As(err, &conflict) {
+		return conflict.StorageError(), true
+	}
+
+	for _, sentinel := range storageErrorCodes {
+		if errors.Is(err, sentinel.err) {
+			return &StorageError{Code: sentinel.code, Message: err.Error(), Err: err}, true
+		}
+	}
+	return nil, false
+}
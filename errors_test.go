@@ -0,0 +1,93 @@
+package dynamodbstorage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestAsStorageError(t *testing.T) {
+	tests := map[string]struct {
+		err       error
+		code      string
+		operation string
+		key       string
+	}{
+		"empty key":          {err: ErrEmptyKey, code: CodeEmptyKey},
+		"read-only":          {err: ErrReadOnly, code: CodeReadOnly},
+		"table required":     {err: ErrTableRequired, code: CodeTableRequired},
+		"throttled":          {err: fmt.Errorf("%w: %w", ErrThrottled, errors.New("ProvisionedThroughputExceededException")), code: CodeThrottled},
+		"missing permission": {err: fmt.Errorf("%w: dynamodb:PutItem on table a", ErrMissingPermission), code: CodeMissingPermission},
+		"not found":          {err: fmt.Errorf("loading a: %w", fs.ErrNotExist), code: CodeNotFound},
+		"version conflict":   {err: &VersionConflictError{Key: "a", ExpectedVersion: 2}, code: CodeVersionConflict, operation: "StoreIfVersion", key: "a"},
+		"lock lost": {
+			err:       &StorageError{Code: CodeLockLost, Operation: "Unlock", Key: "a", Message: "unlocking a", Err: ErrLockLost},
+			code:      CodeLockLost,
+			operation: "Unlock",
+			key:       "a",
+		},
+		"wrapped": {
+			err:       fmt.Errorf("renewing: %w", &VersionConflictError{Key: "b"}),
+			code:      CodeVersionConflict,
+			operation: "StoreIfVersion",
+			key:       "b",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			storageErr, ok := AsStorageError(tt.err)
+			if !ok {
+				t.Errorf("expected a StorageError for %v", tt.err)
+				return
+			}
+			if storageErr.Code != tt.code || storageErr.Operation != tt.operation || storageErr.Key != tt.key {
+				t.Errorf("fields do not match, expected: %s %s %s, got: %s %s %s", tt.code, tt.operation, tt.key,
+					storageErr.Code, storageErr.Operation, storageErr.Key)
+			}
+			if !strings.Contains(tt.err.Error(), storageErr.Error()) {
+				t.Errorf("message does not match the error, got: %s", storageErr.Error())
+			}
+
+			if storageErr.Unwrap() == nil {
+				t.Errorf("expected the underlying error to be kept")
+			}
+
+			data, err := json.Marshal(storageErr)
+			if err != nil {
+				t.Errorf("failed to marshal: %s", err.Error())
+				return
+			}
+			var fields map[string]string
+			if err := json.Unmarshal(data, &fields); err != nil {
+				t.Errorf("failed to unmarshal: %s", err.Error())
+				return
+			}
+			if fields["code"] != tt.code || fields["operation"] != tt.operation || fields["key"] != tt.key {
+				t.Errorf("JSON fields do not match, got: %s", data)
+			}
+		})
+	}
+
+	if _, ok := AsStorageError(errors.New("other")); ok {
+		t.Errorf("expected no StorageError for an unrelated error")
+	}
+	if _, ok := AsStorageError(nil); ok {
+		t.Errorf("expected no StorageError for nil")
+	}
+}
+
+func TestAsStorageError_Sentinels(t *testing.T) {
+	err := &StorageError{Code: CodeChecksumMismatch, Operation: "Load", Key: "a", Message: "loading a", Err: ErrChecksumMismatch}
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("a StorageError should match the sentinel it wraps")
+	}
+
+	storageErr, ok := AsStorageError(fmt.Errorf("wrapped: %w", err))
+	if !ok || storageErr != err {
+		t.Errorf("expected the wrapped StorageError itself, got: %v", storageErr)
+	}
+}
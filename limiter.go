@@ -0,0 +1,160 @@
+package dynamodbstorage
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"golang.org/x/sync/semaphore"
+)
+
+// limitedAPI makes each request through the wrapped DynamoAPI wait for one of
+// the slots of sem, so that no more than MaxConcurrentOps requests are in
+// flight at once. Paginated requests hold a slot for each page, not while the
+// page is processed, so that callbacks can make requests of their own. The
+// table waiters aren't limited, as they spend most of their time sleeping.
+type limitedAPI struct {
+	DynamoAPI
+	sem *semaphore.Weighted
+}
+
+func (a *limitedAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	if err := a.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	defer a.sem.Release(1)
+	return a.DynamoAPI.GetItemWithContext(ctx, input, opts...)
+}
+
+func (a *limitedAPI) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if err := a.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	defer a.sem.Release(1)
+	return a.DynamoAPI.PutItemWithContext(ctx, input, opts...)
+}
+
+func (a *limitedAPI) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	if err := a.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	defer a.sem.Release(1)
+	return a.DynamoAPI.DeleteItemWithContext(ctx, input, opts...)
+}
+
+func (a *limitedAPI) BatchGetItemWithContext(ctx aws.Context, input *dynamodb.BatchGetItemInput, opts ...request.Option) (*dynamodb.BatchGetItemOutput, error) {
+	if err := a.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	defer a.sem.Release(1)
+	return a.DynamoAPI.BatchGetItemWithContext(ctx, input, opts...)
+}
+
+func (a *limitedAPI) TransactWriteItemsWithContext(ctx aws.Context, input *dynamodb.TransactWriteItemsInput, opts ...request.Option) (*dynamodb.TransactWriteItemsOutput, error) {
+	if err := a.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	defer a.sem.Release(1)
+	return a.DynamoAPI.TransactWriteItemsWithContext(ctx, input, opts...)
+}
+
+func (a *limitedAPI) ScanWithContext(ctx aws.Context, input *dynamodb.ScanInput, opts ...request.Option) (*dynamodb.ScanOutput, error) {
+	if err := a.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	defer a.sem.Release(1)
+	return a.DynamoAPI.ScanWithContext(ctx, input, opts...)
+}
+
+func (a *limitedAPI) ScanPagesWithContext(ctx aws.Context, input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, opts ...request.Option) error {
+	pages := a.pages(ctx)
+	if err := pages.acquire(); err != nil {
+		return err
+	}
+	err := a.DynamoAPI.ScanPagesWithContext(ctx, input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		return pages.next(func() bool { return fn(page, lastPage) }, lastPage)
+	}, opts...)
+	return pages.done(err)
+}
+
+func (a *limitedAPI) QueryPagesWithContext(ctx aws.Context, input *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool, opts ...request.Option) error {
+	pages := a.pages(ctx)
+	if err := pages.acquire(); err != nil {
+		return err
+	}
+	err := a.DynamoAPI.QueryPagesWithContext(ctx, input, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		return pages.next(func() bool { return fn(page, lastPage) }, lastPage)
+	}, opts...)
+	return pages.done(err)
+}
+
+func (a *limitedAPI) DescribeTableWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.Option) (*dynamodb.DescribeTableOutput, error) {
+	if err := a.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	defer a.sem.Release(1)
+	return a.DynamoAPI.DescribeTableWithContext(ctx, input, opts...)
+}
+
+func (a *limitedAPI) CreateTableWithContext(ctx aws.Context, input *dynamodb.CreateTableInput, opts ...request.Option) (*dynamodb.CreateTableOutput, error) {
+	if err := a.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	defer a.sem.Release(1)
+	return a.DynamoAPI.CreateTableWithContext(ctx, input, opts...)
+}
+
+func (a *limitedAPI) DeleteTableWithContext(ctx aws.Context, input *dynamodb.DeleteTableInput, opts ...request.Option) (*dynamodb.DeleteTableOutput, error) {
+	if err := a.sem.Acquire(ctx, 1); err != nil {
+		return nil, err
+	}
+	defer a.sem.Release(1)
+	return a.DynamoAPI.DeleteTableWithContext(ctx, input, opts...)
+}
+
+// limitedPages holds a slot of sem while each page of a paginated request is fetched
+type limitedPages struct {
+	ctx  aws.Context
+	sem  *semaphore.Weighted
+	held bool
+	err  error
+}
+
+func (a *limitedAPI) pages(ctx aws.Context) *limitedPages {
+	return &limitedPages{ctx: ctx, sem: a.sem}
+}
+
+// acquire waits for a slot before fetching the next page
+func (p *limitedPages) acquire() error {
+	if err := p.sem.Acquire(p.ctx, 1); err != nil {
+		return err
+	}
+	p.held = true
+	return nil
+}
+
+// next releases the slot while fn processes the page just fetched, and then
+// acquires it again if there's another page to fetch
+func (p *limitedPages) next(fn func() bool, lastPage bool) bool {
+	p.sem.Release(1)
+	p.held = false
+	if !fn() || lastPage {
+		return false
+	}
+	if err := p.acquire(); err != nil {
+		p.err = err
+		return false
+	}
+	return true
+}
+
+// done releases the slot if it's still held and returns the error of the
+// request, or the error waiting for a slot if that stopped it
+func (p *limitedPages) done(err error) error {
+	if p.held {
+		p.sem.Release(1)
+	}
+	if err != nil {
+		return err
+	}
+	return p.err
+}
@@ -0,0 +1,109 @@
+package dynamodbstorage
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// concurrencyMock records the most requests it had in flight at once
+type concurrencyMock struct {
+	*mockDynamoAPI
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (m *concurrencyMock) track() func() {
+	m.mu.Lock()
+	m.inFlight++
+	if m.inFlight > m.maxInFlight {
+		m.maxInFlight = m.inFlight
+	}
+	m.mu.Unlock()
+
+	time.Sleep(10 * time.Millisecond)
+	return func() {
+		m.mu.Lock()
+		m.inFlight--
+		m.mu.Unlock()
+	}
+}
+
+func (m *concurrencyMock) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	defer m.track()()
+	return m.mockDynamoAPI.GetItemWithContext(ctx, input, opts...)
+}
+
+func (m *concurrencyMock) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	defer m.track()()
+	return m.mockDynamoAPI.PutItemWithContext(ctx, input, opts...)
+}
+
+func TestDynamoDBStorage_MaxConcurrentOps(t *testing.T) {
+	mock := &concurrencyMock{mockDynamoAPI: newMockDynamoAPI()}
+	storage := Storage{
+		Table:            TestTableName,
+		AwsRegion:        "us-east-1",
+		DynamoAPI:        mock,
+		MaxConcurrentOps: 3,
+	}
+	if err := storage.initConfig(); err != nil {
+		t.Errorf("initConfig() error = %v", err)
+		return
+	}
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			key := fmt.Sprintf("key%d", i)
+			if err := storage.Store(ctx, key, []byte("value")); err != nil {
+				t.Errorf("failed to store: %s", err.Error())
+				return
+			}
+			if _, err := storage.Load(ctx, key); err != nil {
+				t.Errorf("failed to load: %s", err.Error())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if mock.maxInFlight > 3 {
+		t.Errorf("expected at most 3 requests in flight, got: %d", mock.maxInFlight)
+	}
+	if mock.maxInFlight < 2 {
+		t.Errorf("expected requests to run concurrently up to the limit, got: %d", mock.maxInFlight)
+	}
+
+	// the slot isn't held while a page is processed
+	storage.MaxConcurrentOps = 1
+	storage.limiter = nil
+	if err := storage.initConfig(); err != nil {
+		t.Errorf("initConfig() error = %v", err)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err := storage.scanPages(ctx, &dynamodb.ScanInput{}, func(*dynamodb.ScanOutput) bool {
+		_, err := storage.Load(ctx, "key0")
+		return err == nil
+	})
+	if err != nil {
+		t.Errorf("failed to scan with requests made while processing a page: %s", err.Error())
+	}
+
+	storage = Storage{Table: TestTableName, AwsRegion: "us-east-1", MaxConcurrentOps: -1}
+	if err := storage.initConfig(); err == nil {
+		t.Errorf("expected a config error for a negative MaxConcurrentOps")
+	}
+}
@@ -0,0 +1,60 @@
+package dynamodbstorage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lock metrics, registered with the default Prometheus registry that Caddy
+// serves on its metrics endpoint, and labeled with the table they're for
+var (
+	lockWaitSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "caddy",
+		Subsystem: "storage_dynamodb",
+		Name:      "lock_wait_seconds",
+		Help:      "How long Lock waited before acquiring a lock.",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 120, 300},
+	}, []string{"table"})
+
+	lockStealsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "storage_dynamodb",
+		Name:      "lock_steals_total",
+		Help:      "Number of expired locks held by others that Lock took over.",
+	}, []string{"table"})
+
+	lockRefreshFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "storage_dynamodb",
+		Name:      "lock_refresh_failures_total",
+		Help:      "Number of locks that RefreshLock failed to extend.",
+	}, []string{"table"})
+
+	registerMetricsOnce sync.Once
+)
+
+// registerMetrics registers the lock metrics once. Registration errors are
+// ignored, so that a clashing metric name can't keep the storage from working.
+func registerMetrics() {
+	registerMetricsOnce.Do(func() {
+		for _, collector := range []prometheus.Collector{lockWaitSeconds, lockStealsTotal, lockRefreshFailuresTotal} {
+			_ = prometheus.Register(collector)
+		}
+	})
+}
+
+// observeLockAcquired records that a lock on the table was acquired after
+// waiting since start, taking it over from another instance if stolen is set
+func (s *Storage) observeLockAcquired(start time.Time, stolen bool) {
+	lockWaitSeconds.WithLabelValues(s.Table).Observe(time.Since(start).Seconds())
+	if stolen {
+		lockStealsTotal.WithLabelValues(s.Table).Inc()
+	}
+}
+
+// observeLockRefreshFailed records that a lock on the table couldn't be refreshed
+func (s *Storage) observeLockRefreshFailed() {
+	lockRefreshFailuresTotal.WithLabelValues(s.Table).Inc()
+}
@@ -0,0 +1,115 @@
+package dynamodbstorage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// lockWaits returns the number and total seconds of the lock waits observed for table
+func lockWaits(t *testing.T, table string) (uint64, float64) {
+	var m dto.Metric
+	if err := lockWaitSeconds.WithLabelValues(table).(prometheus.Metric).Write(&m); err != nil {
+		t.Fatalf("failed to read histogram: %s", err.Error())
+	}
+	return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+}
+
+// lockRefreshFailures returns the number of lock refresh failures counted for table
+func lockRefreshFailures(t *testing.T, table string) float64 {
+	var m dto.Metric
+	if err := lockRefreshFailuresTotal.WithLabelValues(table).Write(&m); err != nil {
+		t.Fatalf("failed to read counter: %s", err.Error())
+	}
+	return m.GetCounter().GetValue()
+}
+
+// lockSteals returns the number of lock steals counted for table
+func lockSteals(t *testing.T, table string) float64 {
+	var m dto.Metric
+	if err := lockStealsTotal.WithLabelValues(table).Write(&m); err != nil {
+		t.Fatalf("failed to read counter: %s", err.Error())
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestDynamoDBStorage_LockMetrics(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	newStorage := func(lockTimeout time.Duration) *Storage {
+		return &Storage{
+			Table:               TestTableName,
+			AwsEndpoint:         os.Getenv("AWS_ENDPOINT"),
+			AwsRegion:           os.Getenv("AWS_DEFAULT_REGION"),
+			AwsDisableSSL:       DisableSSL,
+			LockTimeout:         caddy.Duration(lockTimeout),
+			LockPollingInterval: caddy.Duration(50 * time.Millisecond),
+		}
+	}
+	holder, waiter := newStorage(time.Minute), newStorage(time.Minute)
+
+	if err := holder.Lock(context.Background(), "key"); err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+		return
+	}
+	count, sum := lockWaits(t, TestTableName)
+
+	go func() {
+		time.Sleep(300 * time.Millisecond)
+		if err := holder.Unlock(context.Background(), "key"); err != nil {
+			t.Errorf("error unlocking: %s", err.Error())
+		}
+	}()
+	if err := waiter.Lock(context.Background(), "key"); err != nil {
+		t.Errorf("error acquiring contended lock: %s", err.Error())
+		return
+	}
+
+	newCount, newSum := lockWaits(t, TestTableName)
+	if newCount != count+1 {
+		t.Errorf("expected one lock wait to be observed, got: %d", newCount-count)
+	}
+	if waited := newSum - sum; waited < 0.3 {
+		t.Errorf("expected the wait for the contended lock to be observed, got: %fs", waited)
+	}
+	if err := waiter.Unlock(context.Background(), "key"); err != nil {
+		t.Errorf("error unlocking: %s", err.Error())
+	}
+
+	// taking over an expired lock counts as a steal
+	steals := lockSteals(t, TestTableName)
+	expiring := newStorage(100 * time.Millisecond)
+	if err := expiring.Lock(context.Background(), "key"); err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+		return
+	}
+	if err := waiter.Lock(context.Background(), "key"); err != nil {
+		t.Errorf("error taking over expired lock: %s", err.Error())
+		return
+	}
+	if got := lockSteals(t, TestTableName) - steals; got != 1 {
+		t.Errorf("expected one lock steal to be counted, got: %v", got)
+	}
+
+	// the instance whose lock was taken over fails to refresh it
+	failures := lockRefreshFailures(t, TestTableName)
+	if err := expiring.RefreshLock(context.Background(), "key"); !errors.Is(err, ErrLockLost) {
+		t.Errorf("expected ErrLockLost refreshing a stolen lock, got: %v", err)
+	}
+	if got := lockRefreshFailures(t, TestTableName) - failures; got != 1 {
+		t.Errorf("expected one lock refresh failure to be counted, got: %v", got)
+	}
+	if err := waiter.Unlock(context.Background(), "key"); err != nil {
+		t.Errorf("error unlocking: %s", err.Error())
+	}
+}
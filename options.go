@@ -0,0 +1,76 @@
+package dynamodbstorage
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/caddyserver/caddy/v2"
+)
+
+// Option configures a Storage created with New
+type Option func(s *Storage)
+
+// New creates a Storage configured by opts and validates the configuration
+// right away, returning an error if it's incomplete or invalid instead of
+// failing on first use. The AWS session is created unless WithSession is given.
+// Fields without an option can be set on the returned Storage before it's used.
+func New(opts ...Option) (*Storage, error) {
+	s := &Storage{}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if err := s.initConfig(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// WithTable sets the name of the DynamoDB table. It is required.
+func WithTable(table string) Option {
+	return func(s *Storage) {
+		s.Table = table
+	}
+}
+
+// WithRegion sets the AWS region of the table
+func WithRegion(region string) Option {
+	return func(s *Storage) {
+		s.AwsRegion = region
+	}
+}
+
+// WithEndpoint sets a custom DynamoDB endpoint, e.g. for DynamoDB Local
+func WithEndpoint(endpoint string) Option {
+	return func(s *Storage) {
+		s.AwsEndpoint = endpoint
+	}
+}
+
+// WithProfile sets the profile in the shared AWS config files to use
+func WithProfile(profile string) Option {
+	return func(s *Storage) {
+		s.AwsProfile = profile
+	}
+}
+
+// WithSession sets the AWS session to use instead of creating one
+func WithSession(sess *session.Session) Option {
+	return func(s *Storage) {
+		s.AwsSession = sess
+	}
+}
+
+// WithLockTimeout sets how long a lock lasts before other instances may take it over
+func WithLockTimeout(timeout time.Duration) Option {
+	return func(s *Storage) {
+		s.LockTimeout = caddy.Duration(timeout)
+	}
+}
+
+// WithLockPollingInterval sets how often to check whether a lock was released
+func WithLockPollingInterval(interval time.Duration) Option {
+	return func(s *Storage) {
+		s.LockPollingInterval = caddy.Duration(interval)
+	}
+}
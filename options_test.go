@@ -0,0 +1,98 @@
+package dynamodbstorage
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestNew(t *testing.T) {
+	s, err := New(
+		WithTable(TestTableName),
+		WithRegion("us-west-2"),
+		WithEndpoint("http://localhost:8000"),
+		WithLockTimeout(time.Minute),
+		WithLockPollingInterval(time.Second),
+	)
+	if err != nil {
+		t.Errorf("failed to create storage: %s", err.Error())
+		return
+	}
+
+	if s.Table != TestTableName || s.AwsRegion != "us-west-2" || s.AwsEndpoint != "http://localhost:8000" {
+		t.Errorf("options were not applied, got: %+v", s)
+	}
+	if s.LockTimeout != caddy.Duration(time.Minute) || s.LockPollingInterval != caddy.Duration(time.Second) {
+		t.Errorf("lock options were not applied, got: %v, %v", s.LockTimeout, s.LockPollingInterval)
+	}
+
+	// validated up front
+	if s.AwsSession == nil {
+		t.Errorf("expected the session to be created")
+	}
+	if s.PrimaryKeyAttribute != primaryKeyAttribute {
+		t.Errorf("expected defaults to be set, got primary key attribute: %s", s.PrimaryKeyAttribute)
+	}
+}
+
+func TestWithProfile(t *testing.T) {
+	var s Storage
+	WithProfile("certs")(&s)
+	if s.AwsProfile != "certs" {
+		t.Errorf("profile was not applied, got: %s", s.AwsProfile)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	sess := newMockSession(func(r *request.Request) {})
+
+	tests := map[string]struct {
+		opts    []Option
+		wantErr error
+	}{
+		"no table": {
+			opts:    []Option{WithSession(sess)},
+			wantErr: ErrTableRequired,
+		},
+		"empty table": {
+			opts:    []Option{WithTable(""), WithSession(sess)},
+			wantErr: ErrTableRequired,
+		},
+		"polling interval not less than timeout": {
+			opts: []Option{WithTable(TestTableName), WithSession(sess),
+				WithLockTimeout(time.Second), WithLockPollingInterval(time.Second)},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			s, err := New(tt.opts...)
+			if err == nil {
+				t.Errorf("expected an error")
+				return
+			}
+			if s != nil {
+				t.Errorf("expected no storage along with the error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got: %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestNew_Session(t *testing.T) {
+	sess := newMockSession(func(r *request.Request) {})
+	s, err := New(WithTable(TestTableName), WithSession(sess), WithRegion(os.Getenv("AWS_DEFAULT_REGION")))
+	if err != nil {
+		t.Errorf("failed to create storage: %s", err.Error())
+		return
+	}
+	if s.AwsSession != sess {
+		t.Errorf("expected the given session to be used")
+	}
+}
@@ -0,0 +1,89 @@
+package dynamodbstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	// permissionProbeKey is the key CheckPermissions writes to and deletes,
+	// always with a condition that fails, so no item is ever stored at it
+	permissionProbeKey = "CERTMAGIC-PERMISSION-PROBE"
+
+	// errCodeAccessDenied is the error code of a request the principal lacks
+	// the IAM permission for
+	errCodeAccessDenied = "AccessDeniedException"
+)
+
+// CheckPermissions checks that the AWS principal is allowed to perform the
+// DynamoDB actions the storage needs on the table: GetItem, Scan, and unless
+// ReadOnly is set, PutItem and DeleteItem. It does so with harmless requests
+// that don't change the table, as the writes are made with a condition that
+// always fails. The returned error lists every action that failed, wrapping
+// ErrMissingPermission for those that were denied.
+func (s *Storage) CheckPermissions(ctx context.Context) error {
+	if err := s.initConfig(); err != nil {
+		return err
+	}
+
+	svc := s.client()
+	neverTrue := aws.String("attribute_exists(#K) AND attribute_not_exists(#K)")
+	names := map[string]*string{"#K": aws.String(s.PrimaryKeyAttribute)}
+
+	var errs []error
+	check := func(action string, err error) {
+		switch {
+		case err == nil || isConditionalCheckFailed(err):
+		case isAccessDenied(err):
+			errs = append(errs, fmt.Errorf("%w: dynamodb:%s on table %s: %w", ErrMissingPermission, action, s.Table, err))
+		default:
+			errs = append(errs, fmt.Errorf("checking dynamodb:%s on table %s: %w", action, s.Table, contextError(ctx, err)))
+		}
+	}
+
+	_, err := s.readClient().GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key:                      s.itemKey(permissionProbeKey),
+		ProjectionExpression:     aws.String("#K"),
+		ExpressionAttributeNames: names,
+		TableName:                aws.String(s.Table),
+	}, s.readTimeout())
+	check("GetItem", err)
+
+	_, err = s.readClient().ScanWithContext(ctx, &dynamodb.ScanInput{
+		Limit:     aws.Int64(1),
+		TableName: aws.String(s.Table),
+	}, s.readTimeout())
+	check("Scan", err)
+
+	if !s.ReadOnly {
+		_, err = svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			Item:                     s.itemKey(permissionProbeKey),
+			ConditionExpression:      neverTrue,
+			ExpressionAttributeNames: names,
+			TableName:                aws.String(s.Table),
+		}, s.writeTimeout())
+		check("PutItem", err)
+
+		_, err = svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+			Key:                      s.itemKey(permissionProbeKey),
+			ConditionExpression:      neverTrue,
+			ExpressionAttributeNames: names,
+			TableName:                aws.String(s.Table),
+		}, s.writeTimeout())
+		check("DeleteItem", err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// isAccessDenied returns true if err is AWS rejecting a request because
+// the principal lacks the IAM permission for it
+func isAccessDenied(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == errCodeAccessDenied
+}
@@ -0,0 +1,107 @@
+package dynamodbstorage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// permissionsMock denies the actions in denied like IAM would, and fails the
+// condition of every write like DynamoDB would for the probe key
+type permissionsMock struct {
+	DynamoAPI
+
+	denied map[string]bool
+	calls  []string
+}
+
+func (m *permissionsMock) call(action string) error {
+	m.calls = append(m.calls, action)
+	if m.denied[action] {
+		return awserr.New(errCodeAccessDenied, "not authorized to perform: dynamodb:"+action, nil)
+	}
+	return nil
+}
+
+func (m *permissionsMock) GetItemWithContext(aws.Context, *dynamodb.GetItemInput, ...request.Option) (*dynamodb.GetItemOutput, error) {
+	if err := m.call("GetItem"); err != nil {
+		return nil, err
+	}
+	return &dynamodb.GetItemOutput{}, nil
+}
+
+func (m *permissionsMock) ScanWithContext(aws.Context, *dynamodb.ScanInput, ...request.Option) (*dynamodb.ScanOutput, error) {
+	if err := m.call("Scan"); err != nil {
+		return nil, err
+	}
+	return &dynamodb.ScanOutput{}, nil
+}
+
+func (m *permissionsMock) PutItemWithContext(_ aws.Context, input *dynamodb.PutItemInput, _ ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if err := m.call("PutItem"); err != nil {
+		return nil, err
+	}
+	if input.ConditionExpression == nil {
+		return nil, errors.New("expected a condition on the probe write")
+	}
+	return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "condition failed", nil)
+}
+
+func (m *permissionsMock) DeleteItemWithContext(_ aws.Context, input *dynamodb.DeleteItemInput, _ ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	if err := m.call("DeleteItem"); err != nil {
+		return nil, err
+	}
+	if input.ConditionExpression == nil {
+		return nil, errors.New("expected a condition on the probe delete")
+	}
+	return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "condition failed", nil)
+}
+
+func TestDynamoDBStorage_CheckPermissions(t *testing.T) {
+	ctx := context.Background()
+
+	mock := &permissionsMock{}
+	storage := Storage{Table: TestTableName, AwsRegion: "us-east-1", DynamoAPI: mock}
+	if err := storage.CheckPermissions(ctx); err != nil {
+		t.Errorf("expected no error with all permissions, got: %v", err)
+	}
+	if !reflect.DeepEqual(mock.calls, []string{"GetItem", "Scan", "PutItem", "DeleteItem"}) {
+		t.Errorf("expected every action to be checked, got: %v", mock.calls)
+	}
+
+	mock = &permissionsMock{denied: map[string]bool{"PutItem": true, "Scan": true}}
+	storage = Storage{Table: TestTableName, AwsRegion: "us-east-1", DynamoAPI: mock}
+	err := storage.CheckPermissions(ctx)
+	if !errors.Is(err, ErrMissingPermission) {
+		t.Errorf("expected ErrMissingPermission, got: %v", err)
+	}
+	if err != nil {
+		for _, action := range []string{"dynamodb:PutItem", "dynamodb:Scan"} {
+			if !strings.Contains(err.Error(), action) {
+				t.Errorf("expected the error to list %s, got: %v", action, err)
+			}
+		}
+		for _, action := range []string{"dynamodb:GetItem", "dynamodb:DeleteItem"} {
+			if strings.Contains(err.Error(), action) {
+				t.Errorf("expected the error not to list %s, got: %v", action, err)
+			}
+		}
+	}
+
+	// read-only storage doesn't need to write
+	mock = &permissionsMock{denied: map[string]bool{"PutItem": true, "DeleteItem": true}}
+	storage = Storage{Table: TestTableName, AwsRegion: "us-east-1", DynamoAPI: mock, ReadOnly: true}
+	if err := storage.CheckPermissions(ctx); err != nil {
+		t.Errorf("expected no error without write permissions in read-only mode, got: %v", err)
+	}
+	if !reflect.DeepEqual(mock.calls, []string{"GetItem", "Scan"}) {
+		t.Errorf("expected only reads to be checked in read-only mode, got: %v", mock.calls)
+	}
+}
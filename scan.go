@@ -0,0 +1,135 @@
+package dynamodbstorage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// scanPages calls fn with each page of the scan described by input until fn
+// returns false. With ScanParallelism above 1 the table is scanned in that many
+// segments at once. fn is still only called with one page at a time, but the
+// pages of different segments come in no particular order. If a segment fails,
+// the others are canceled and its error is returned.
+func (s *Storage) scanPages(ctx context.Context, input *dynamodb.ScanInput, fn func(page *dynamodb.ScanOutput) bool) error {
+	svc := s.readClient()
+	if s.ScanParallelism <= 1 {
+		return svc.ScanPagesWithContext(ctx, input,
+			func(page *dynamodb.ScanOutput, lastPage bool) bool {
+				return fn(page) && !lastPage
+			}, s.readTimeout())
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		stopped bool
+		scanErr error
+	)
+	for segment := 0; segment < s.ScanParallelism; segment++ {
+		segmentInput := *input
+		segmentInput.Segment = aws.Int64(int64(segment))
+		segmentInput.TotalSegments = aws.Int64(int64(s.ScanParallelism))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := svc.ScanPagesWithContext(ctx, &segmentInput,
+				func(page *dynamodb.ScanOutput, lastPage bool) bool {
+					mu.Lock()
+					defer mu.Unlock()
+					if stopped {
+						return false
+					}
+					if !fn(page) {
+						stopped = true
+						return false
+					}
+					return !lastPage
+				}, s.readTimeout())
+			if err == nil {
+				return
+			}
+
+			mu.Lock()
+			// the first error is the cause, later ones may be from canceling the other segments
+			if scanErr == nil && !stopped {
+				scanErr = err
+			}
+			stopped = true
+			mu.Unlock()
+			cancel()
+		}()
+	}
+	wg.Wait()
+
+	return scanErr
+}
+
+// listBuffer is how many pages of keys a listing scan may get ahead of the
+// caller of ListFunc, which bounds the memory used however large the table is
+const listBuffer = 4
+
+// keyScan is a scan running in the background, that sends the keys of each
+// page on pages, which is closed when the scan ends
+type keyScan struct {
+	pages chan []string
+
+	// free takes back slices of keys received from pages once they're done
+	// with, for the scan to reuse
+	free chan []string
+
+	// err is the error of the scan, only to be read once pages is closed
+	err error
+}
+
+// scanKeys starts the scan described by input, sending the keys of scanned
+// items that listedKey returns. Cancel ctx to stop the scan early, and then
+// drain pages to wait for it to stop.
+func (s *Storage) scanKeys(ctx context.Context, input *dynamodb.ScanInput) *keyScan {
+	scan := &keyScan{
+		pages: make(chan []string, listBuffer),
+		// enough for every slice there can be: in pages, being filled, and being read
+		free: make(chan []string, listBuffer+2),
+	}
+
+	go func() {
+		defer close(scan.pages)
+		var canceled bool
+		err := s.scanPages(ctx, input, func(page *dynamodb.ScanOutput) bool {
+			var keys []string
+			select {
+			case keys = <-scan.free:
+				keys = keys[:0]
+			default:
+				keys = make([]string, 0, len(page.Items))
+			}
+
+			for _, i := range page.Items {
+				if key, ok := s.listedKey(i); ok {
+					keys = append(keys, key)
+				}
+			}
+
+			select {
+			case scan.pages <- keys:
+				return true
+			case <-ctx.Done():
+				canceled = true
+				return false
+			}
+		})
+		if err == nil && canceled {
+			// the scan stopped early without an error of its own
+			err = ctx.Err()
+		}
+		scan.err = err
+	}()
+
+	return scan
+}
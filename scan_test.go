@@ -0,0 +1,146 @@
+package dynamodbstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// pagedScanMock returns a table of generated items in pages like DynamoDB's
+type pagedScanMock struct {
+	DynamoAPI
+
+	pages []*dynamodb.ScanOutput
+}
+
+func newPagedScanMock(items, pageSize int) *pagedScanMock {
+	m := &pagedScanMock{}
+	for start := 0; start < items; start += pageSize {
+		page := &dynamodb.ScanOutput{}
+		for i := start; i < min(start+pageSize, items); i++ {
+			page.Items = append(page.Items, mockItem(fmt.Sprintf("certificates/example%06d.com", i), "value"))
+		}
+		m.pages = append(m.pages, page)
+	}
+	return m
+}
+
+func (m *pagedScanMock) ScanPagesWithContext(ctx aws.Context, _ *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, _ ...request.Option) error {
+	for i, page := range m.pages {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !fn(page, i == len(m.pages)-1) {
+			return nil
+		}
+	}
+	return nil
+}
+
+func TestDynamoDBStorage_ListFuncPages(t *testing.T) {
+	storage := Storage{
+		Table:     TestTableName,
+		AwsRegion: "us-east-1",
+		DynamoAPI: newPagedScanMock(10000, 100),
+	}
+
+	stop := errors.New("stop")
+	var listed int
+	err := storage.ListFunc(context.Background(), "certificates", func(key string) error {
+		listed++
+		if listed == 250 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) {
+		t.Errorf("expected the error returned by fn, got: %v", err)
+	}
+	if listed != 250 {
+		t.Errorf("expected no keys after fn failed, got: %d", listed)
+	}
+
+	keys, err := storage.List(context.Background(), "certificates", true)
+	if err != nil || len(keys) != 10000 {
+		t.Errorf("expected all keys to be listed, got: %d, %v", len(keys), err)
+	}
+	for i, key := range keys {
+		if expected := fmt.Sprintf("certificates/example%06d.com", i); key != expected {
+			t.Errorf("expected keys in scan order, got %s at %d", key, i)
+			break
+		}
+	}
+}
+
+// uncancelableScanMock keeps scanning after its context is canceled, like a
+// scan whose next page has already been received
+type uncancelableScanMock struct {
+	*pagedScanMock
+}
+
+func (m uncancelableScanMock) ScanPagesWithContext(_ aws.Context, input *dynamodb.ScanInput, fn func(*dynamodb.ScanOutput, bool) bool, opts ...request.Option) error {
+	return m.pagedScanMock.ScanPagesWithContext(context.Background(), input, fn, opts...)
+}
+
+func TestDynamoDBStorage_ListFuncCanceled(t *testing.T) {
+	storage := Storage{
+		Table:     TestTableName,
+		AwsRegion: "us-east-1",
+		DynamoAPI: uncancelableScanMock{newPagedScanMock(10000, 100)},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var listed int
+	err := storage.ListFunc(ctx, "certificates", func(key string) error {
+		listed++
+		if listed == 1 {
+			cancel()
+		}
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a canceled listing to fail, got: %v after %d keys", err, listed)
+	}
+}
+
+// benchmarkList lists a table of 100k keys, in pages of 1000 like DynamoDB's
+// 1MB pages of small items
+func benchmarkList(b *testing.B, list func(storage *Storage) error) {
+	storage := &Storage{
+		Table:     TestTableName,
+		AwsRegion: "us-east-1",
+		DynamoAPI: newPagedScanMock(100000, 1000),
+	}
+	if err := storage.initConfig(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := list(storage); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkList(b *testing.B) {
+	benchmarkList(b, func(storage *Storage) error {
+		_, err := storage.List(context.Background(), "certificates", true)
+		return err
+	})
+}
+
+func BenchmarkListFunc(b *testing.B) {
+	benchmarkList(b, func(storage *Storage) error {
+		return storage.ListFunc(context.Background(), "certificates", func(key string) error {
+			return nil
+		})
+	})
+}
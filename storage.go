@@ -1,37 +1,145 @@
 package dynamodbstorage
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
-	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/aws/aws-dax-go/dax"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 
 	caddy "github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/certmagic"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"golang.org/x/sync/semaphore"
 )
 
 const (
-	contentsAttribute    = "Contents"
-	primaryKeyAttribute  = "PrimaryKey"
-	lastUpdatedAttribute = "LastUpdated"
-	lockTimeoutMinutes   = caddy.Duration(5 * time.Minute)
-	lockPollingInterval  = caddy.Duration(5 * time.Second)
+	contentsAttribute         = "Contents"
+	primaryKeyAttribute       = "PrimaryKey"
+	lastUpdatedAttribute      = "LastUpdated"
+	lastUpdatedEpochAttribute = "LastUpdatedEpoch"
+	contentHashAttribute      = "ContentHash"
+	contentLengthAttribute    = "ContentLength"
+	encodingAttribute         = "Encoding"
+	keyAttribute              = "Key"
+	metaAttribute             = "Meta"
+	partsAttribute            = "Parts"
+	partOfAttribute           = "PartOf"
+	partsIDAttribute          = "PartsID"
+	versionAttribute          = "Version"
+	lockIDAttribute           = "LockID"
+	ownerAttribute            = "Owner"
+	expiresAtAttribute        = "ExpiresAt"
+	lockTimeoutMinutes        = caddy.Duration(5 * time.Minute)
+	lockPollingInterval       = caddy.Duration(5 * time.Second)
+	cacheMaxItems             = 1000
+	maxItemSize               = 400 * 1024
+	sizeWarnThreshold         = 300 * 1024
+	partitionKeyTemplate      = "{tenant}#{key}"
+	chunkSize                 = 256 * 1024
+	maxChunkSize              = 290 * 1024
+	maxTransactionItems       = 100
+	maxBatchGetItems          = 100
+	maxScanParallelism        = 64
+	unprocessedRetries        = 10
+
+	lockPollingBackoffStart = 100 * time.Millisecond
+	unprocessedMaxWait      = 5 * time.Second
+	requestTimeout          = caddy.Duration(10 * time.Second)
+	regionLookupTimeout     = 2 * time.Second
+)
+
+var (
+	// ErrTableRequired is returned when no table name is configured.
+	ErrTableRequired = errors.New("config error: table name is required")
+
+	// ErrEmptyKey is returned when an operation is given an empty key or prefix.
+	ErrEmptyKey = errors.New("key must not be empty")
+
+	// ErrReadOnly is returned by methods that would write to DynamoDB
+	// when the storage is configured to be read-only.
+	ErrReadOnly = errors.New("storage is read-only")
+
+	// ErrChecksumMismatch is returned when VerifyChecksums is set and a loaded
+	// value doesn't match the checksum it was stored with.
+	ErrChecksumMismatch = errors.New("contents do not match their checksum")
+
+	// ErrLockLost is returned by Unlock when the lock acquired by this
+	// instance expired and was removed or taken over by another instance
+	// before it was released.
+	ErrLockLost = errors.New("lock was lost")
+
+	// ErrLockNotHeld is returned by RefreshLock when this instance doesn't
+	// hold the lock, because it never acquired it or already released it.
+	ErrLockNotHeld = errors.New("lock is not held by this instance")
+
+	// ErrThrottled wraps the error of a request that DynamoDB rejected, once the
+	// SDK's retries were used up, because it exceeded the table's provisioned
+	// throughput or the account's request limit. Callers can back off and try again.
+	ErrThrottled = errors.New("throttled by DynamoDB")
+
+	// ErrMissingPermission is wrapped by the errors CheckPermissions returns for the
+	// DynamoDB actions the AWS principal isn't allowed to perform on the table.
+	ErrMissingPermission = errors.New("missing permission")
 )
 
+// base64Encodings are the supported values of Base64Variant
+var base64Encodings = map[string]*base64.Encoding{
+	"std":     base64.StdEncoding,
+	"url":     base64.URLEncoding,
+	"raw-std": base64.RawStdEncoding,
+	"raw-url": base64.RawURLEncoding,
+}
+
 // Item holds structure of domain, certificate data,
 // and last updated for marshaling with DynamoDb
 type Item struct {
 	PrimaryKey  string    `json:"PrimaryKey"`
 	Contents    string    `json:"Contents"`
 	LastUpdated time.Time `json:"LastUpdated"`
+
+	// Owner identifies the instance holding a lock, only set on lock items
+	Owner string `json:"Owner,omitempty"`
+
+	// Meta holds the metadata stored with StoreWithMeta, if any
+	Meta map[string]string `json:"Meta,omitempty"`
+
+	// encoded is Contents as stored in the table, for conditional writes
+	encoded string
+}
+
+// ListFilter is an attribute that items must have, with a string value, to be
+// listed, e.g. a tag set by whatever else stores items in a shared table
+type ListFilter struct {
+	Attribute string `json:"attribute"`
+	Value     string `json:"value"`
 }
 
 // Storage implements certmagic.Storage to facilitate
@@ -43,30 +151,364 @@ type Storage struct {
 	Table      string           `json:"table,omitempty"`
 	AwsSession *session.Session `json:"-"`
 
+	// DynamoAPI - [optional] used for all DynamoDB requests instead of a client for
+	// AwsSession, e.g. a mock in unit tests. TrackConsumedCapacity and the circuit
+	// breaker only apply to the clients created for AwsSession. Default: none
+	DynamoAPI DynamoAPI `json:"-"`
+
+	// StreamsAPI - [optional] used by WatchChanges to read the table's stream instead of a
+	// client for AwsSession, e.g. a mock in unit tests. Default: none
+	StreamsAPI StreamsAPI `json:"-"`
+
+	// ReadAPI - [optional] used instead of DynamoAPI for reads: GetItem, BatchGetItem, Scan, and
+	// Query, e.g. a DynamoDB Accelerator (DAX) client from github.com/aws/aws-dax-go/dax. Writes
+	// still go to DynamoDB directly. DAX only caches eventually consistent reads, so it serves
+	// scans unless ListConsistentRead is set, while loads and locks, which are strongly
+	// consistent, pass through it to DynamoDB. The fallback table isn't read through it.
+	// Default: none
+	ReadAPI DynamoAPI `json:"-"`
+
+	// DaxEndpoint - [optional] endpoint of a DAX cluster to read through, e.g.
+	// dax://my-cluster.abc123.dax-clusters.us-east-1.amazonaws.com, or daxs:// for an encrypted
+	// cluster. Sets ReadAPI to a DAX client with the region and credentials of AwsSession.
+	// Default: none
+	DaxEndpoint string `json:"dax_endpoint,omitempty"`
+
+	// TablePrefix, TableSuffix - [optional] added to Table to make the name of the table used,
+	// e.g. to separate environments. Table may be left empty if one of them is set. Default: none
+	TablePrefix string `json:"table_prefix,omitempty"`
+	TableSuffix string `json:"table_suffix,omitempty"`
+
 	// AwsEndpoint - [optional] provide an override for DynamoDB service.
-	// By default it'll use the standard production DynamoDB endpoints.
-	// Useful for testing with a local DynamoDB instance.
+	// By default it'll use the AWS_ENDPOINT_URL_DYNAMODB, AWS_ENDPOINT_URL, or
+	// AWS_ENDPOINT environment variable if one is set, or else the standard
+	// production DynamoDB endpoints. Useful for testing with a local DynamoDB instance.
 	AwsEndpoint string `json:"aws_endpoint,omitempty"`
 
 	// AwsRegion - [optional] region using DynamoDB in.
 	// Useful for testing with a local DynamoDB instance.
 	AwsRegion string `json:"aws_region,omitempty"`
 
+	// AwsProfile - [optional] profile in the shared credentials and config files to use,
+	// instead of the default one or the AWS_PROFILE environment variable.
+	AwsProfile string `json:"aws_profile,omitempty"`
+
 	// AwsDisableSSL - [optional] disable SSL for DynamoDB connections. Default: false
 	// Only useful for local testing, do not use outside of local testing.
 	AwsDisableSSL bool `json:"aws_disable_ssl,omitempty"`
 
-	// LockTimeout - [optional] how long to wait for a lock to be created. Default: 5 minutes
+	// FallbackTable - [optional] a second table, e.g. a replica in another region, that values
+	// are also written to and that Load reads from when Table can't be reached. Default: none
+	FallbackTable string `json:"fallback_table,omitempty"`
+
+	// FallbackRegion - [optional] region of FallbackTable. Default: AwsRegion
+	FallbackRegion string `json:"fallback_region,omitempty"`
+
+	// UseFIPSEndpoint - [optional] connect to DynamoDB through its FIPS 140-2 validated endpoint,
+	// e.g. for GovCloud. Ignored when AwsEndpoint is set. Default: false
+	UseFIPSEndpoint bool `json:"use_fips_endpoint,omitempty"`
+
+	// UseDualStackEndpoint - [optional] connect to DynamoDB through its dual-stack endpoint,
+	// which supports IPv6. Ignored when AwsEndpoint is set. Default: false
+	UseDualStackEndpoint bool `json:"use_dual_stack_endpoint,omitempty"`
+
+	// DebugAWSRequests - [optional] log every request to and response from DynamoDB, including
+	// their bodies. Only for troubleshooting, as the logs will include stored values such as
+	// private keys. Default: false
+	DebugAWSRequests bool `json:"debug_aws_requests,omitempty"`
+
+	// LockTimeout - [optional] how long a lock lasts before other instances may take it over.
+	// Locks aren't refreshed automatically, so work done under a lock must finish in time
+	// or call RefreshLock before then. Default: 5 minutes
 	LockTimeout caddy.Duration `json:"lock_timeout,omitempty"`
 
 	// LockPollingInterval - [optional] how often to check for lock released. Default: 5 seconds
 	LockPollingInterval caddy.Duration `json:"lock_polling_interval,omitempty"`
+
+	// PrimaryKeyAttribute - [optional] name of the table's partition key attribute. Default: PrimaryKey
+	PrimaryKeyAttribute string `json:"primary_key_attribute,omitempty"`
+
+	// ContentsAttribute - [optional] name of the attribute holding stored values. Default: Contents
+	ContentsAttribute string `json:"contents_attribute,omitempty"`
+
+	// LastUpdatedAttribute - [optional] name of the attribute holding the time a value
+	// was stored. Default: LastUpdated
+	LastUpdatedAttribute string `json:"last_updated_attribute,omitempty"`
+
+	// LockIDAttribute - [optional] name of the attribute holding the ID of a lock. Default: LockID
+	LockIDAttribute string `json:"lock_id_attribute,omitempty"`
+
+	// ExpiresAtAttribute - [optional] name of the attribute holding the time, as a Unix epoch, when
+	// a value stored with StoreWithTTL or a lock expires, for use as the table's TTL attribute.
+	// Default: ExpiresAt
+	ExpiresAtAttribute string `json:"expires_at_attribute,omitempty"`
+
+	// SortKeyAttribute - [optional] name of the table's sort key attribute, for tables with a
+	// composite primary key shared with other data. Requires SortKeyValue. Default: none
+	SortKeyAttribute string `json:"sort_key_attribute,omitempty"`
+
+	// SortKeyValue - [optional] sort key value written on every item, reserving it for this storage.
+	// Required when SortKeyAttribute is set. Default: none
+	SortKeyValue string `json:"sort_key_value,omitempty"`
+
+	// PartitionKeyTemplate - [optional] template for the partition key of each item, in which
+	// {tenant} is replaced by Tenant and {key} by the storage key, keeping tenants sharing the
+	// table apart. Must end with {key}. Default: {tenant}#{key} if Tenant is set, otherwise {key}
+	PartitionKeyTemplate string `json:"partition_key_template,omitempty"`
+
+	// Tenant - [optional] tenant whose items this storage reads and writes, as placed in the
+	// partition key by PartitionKeyTemplate. Default: none
+	Tenant string `json:"tenant,omitempty"`
+
+	// LockPollingBackoff - [optional] start polling for a held lock after 100ms and double the wait
+	// on each check, up to LockPollingInterval, instead of always waiting LockPollingInterval.
+	// Default: false
+	LockPollingBackoff bool `json:"lock_polling_backoff,omitempty"`
+
+	// LockBackoff - [optional] decides how long to wait between checks for a held lock, overriding
+	// LockPollingInterval and LockPollingBackoff. Default: none
+	LockBackoff LockBackoff `json:"-"`
+
+	// IgnoreStolenLockOnUnlock - [optional] have Unlock log a warning and return nil, instead of
+	// ErrLockLost, when the lock it releases was taken over by another instance. Default: false
+	IgnoreStolenLockOnUnlock bool `json:"ignore_stolen_lock_on_unlock,omitempty"`
+
+	// InstanceID - [optional] stable identifier for this instance, recorded on every lock it
+	// creates. Locks found with the same InstanceID are reclaimed instead of waited on, so an
+	// instance that restarts while holding locks doesn't have to wait for them to expire.
+	// Must be unique to each instance sharing the table. Default: none, in which case locks
+	// record the hostname to show which instance holds them, but aren't reclaimed
+	InstanceID string `json:"instance_id,omitempty"`
+
+	// AutoCreateTable - [optional] create the table when Caddy provisions the storage, if it
+	// doesn't exist yet, like EnsureTable. Needs permission to describe and create tables.
+	// Default: false
+	AutoCreateTable bool `json:"auto_create_table,omitempty"`
+
+	// CheckPermissionsOnValidate - [optional] also run CheckPermissions when Caddy validates
+	// the config, so that missing IAM permissions are reported before the first certificate
+	// is issued. Default: false
+	CheckPermissionsOnValidate bool `json:"check_permissions,omitempty"`
+
+	// BillingMode - [optional] billing mode used when EnsureTable creates the table,
+	// either PAY_PER_REQUEST or PROVISIONED. Default: PAY_PER_REQUEST
+	BillingMode string `json:"billing_mode,omitempty"`
+
+	// ReadCapacity - [optional] read capacity units used when EnsureTable creates the table.
+	// Required when BillingMode is PROVISIONED, and must not be set otherwise.
+	ReadCapacity int64 `json:"read_capacity,omitempty"`
+
+	// WriteCapacity - [optional] write capacity units used when EnsureTable creates the table.
+	// Required when BillingMode is PROVISIONED, and must not be set otherwise.
+	WriteCapacity int64 `json:"write_capacity,omitempty"`
+
+	// ContentHashIndex - [optional] name of a global secondary index with ContentHash as its
+	// partition key, for FindByContentHash. EnsureTable creates it along with the table. Default: none
+	ContentHashIndex string `json:"content_hash_index,omitempty"`
+
+	// TableSSEType - [optional] encryption at rest used when EnsureTable creates the table, either
+	// AES256 for a key owned by AWS, or KMS for the KMS key in TableKmsKeyID. Default: AES256
+	TableSSEType string `json:"table_sse_type,omitempty"`
+
+	// TableKmsKeyID - [optional] ID, ARN, or alias of the KMS key used to encrypt the table when
+	// EnsureTable creates it. Required when TableSSEType is KMS, and must not be set otherwise.
+	TableKmsKeyID string `json:"table_kms_key_id,omitempty"`
+
+	// ReadOnly - [optional] reject all writes, including locking, with ErrReadOnly.
+	// Useful for instances that serve certificates but must never modify them. Default: false
+	ReadOnly bool `json:"read_only,omitempty"`
+
+	// DryRun - [optional] validate and log Store, StoreIfVersion, and Delete calls without
+	// writing anything to DynamoDB. Locks are still written so that locking works. Default: false
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// SkipUnchangedWrites - [optional] skip the write, leaving LastUpdated as it was, when the
+	// value being stored is identical to the one already stored, and the stored item has no TTL,
+	// metadata, or version that Store would clear. Default: false
+	SkipUnchangedWrites bool `json:"skip_unchanged_writes,omitempty"`
+
+	// VerifyChecksums - [optional] check loaded values against the SHA-256 checksum they were
+	// stored with, failing with ErrChecksumMismatch if they differ. Values stored without a
+	// checksum are loaded unchecked. Default: false
+	VerifyChecksums bool `json:"verify_checksums,omitempty"`
+
+	// HashSalt - [optional] secret mixed into the SHA-256 content hash of each value, which is
+	// then an HMAC-SHA256, so that identical values can't be correlated across tables or
+	// tenants by anyone able to read them. Values stored with another salt fail VerifyChecksums
+	// and aren't found by FindByContentHash until they're stored again. Default: none
+	HashSalt string `json:"hash_salt,omitempty"`
+
+	// Base64Variant - [optional] base64 encoding used for stored values, one of std, url,
+	// raw-std, or raw-url. Values stored with anything but std record their encoding, so
+	// they can be loaded whatever this is set to. Default: std
+	Base64Variant string `json:"base64_variant,omitempty"`
+
+	// KeyEncoding - [optional] how keys are encoded in the partition key, one of raw, urlencode,
+	// which percent-encodes slashes, colons, and other special characters, or hex-sha256, which
+	// stores the SHA-256 hash of the key. Unless raw, the key is also stored as is in the Key
+	// attribute, for listing. Values stored with another encoding can't be loaded. Default: raw
+	KeyEncoding string `json:"key_encoding,omitempty"`
+
+	// ListConsistentRead - [optional] use strongly consistent reads when listing keys, which
+	// costs twice as much, instead of eventually consistent ones that may miss very recent
+	// writes. Loading a value is always strongly consistent. Default: false
+	ListConsistentRead bool `json:"list_consistent_read,omitempty"`
+
+	// ExistsConsistentRead - [optional] use a strongly consistent read in Exists, which costs
+	// twice as much, instead of an eventually consistent one that may not see a value stored
+	// or deleted very recently. Default: false
+	ExistsConsistentRead bool `json:"exists_consistent_read,omitempty"`
+
+	// FallbackToEventualOnThrottle - [optional] when a strongly consistent read of a value or lock
+	// is still throttled after the SDK's retries, retry it once as an eventually consistent read,
+	// which costs half as much. That read may miss writes made in the last second or so, e.g. load
+	// a value that was just replaced. Locks stay safe, as acquiring one is a conditional write.
+	// Default: false
+	FallbackToEventualOnThrottle bool `json:"fallback_to_eventual_on_throttle,omitempty"`
+
+	// SortListResults - [optional] sort the keys returned by List, which are otherwise in the
+	// order the scan finds them in. Default: false
+	SortListResults bool `json:"sort_list_results,omitempty"`
+
+	// ExtraListFilter - [optional] only list items whose attribute of this name has this string
+	// value, in List, ListFunc, and ListPaged. Items stored by this storage don't get the
+	// attribute, so it's for tables whose items are tagged by another process. Default: none
+	ExtraListFilter *ListFilter `json:"extra_list_filter,omitempty"`
+
+	// ScanParallelism - [optional] number of segments scanned at the same time when listing or
+	// exporting the whole table, at most 64. Each segment reads as fast as a serial scan, so
+	// only raise it with enough read capacity to spare; throttled pages are retried by the SDK
+	// with backoff. Default: 1
+	ScanParallelism int `json:"scan_parallelism,omitempty"`
+
+	// MaxConcurrentOps - [optional] maximum number of requests to DynamoDB in flight at once,
+	// shared by all the methods of this instance. Further requests wait for one to finish,
+	// which smooths out the capacity consumed by bursts of activity. Each page of a scan or
+	// query is a request. Not applied to the client returned by DynamoClient. Default: 0 (no limit)
+	MaxConcurrentOps int `json:"max_concurrent_ops,omitempty"`
+
+	// MaxAttempts - [optional] how many times a request to DynamoDB is sent, including the
+	// first attempt, before giving up. Only throttling, server (5xx), and network errors are
+	// retried, with exponential backoff and jitter; other errors, e.g. validation errors,
+	// fail right away. Not applied to DynamoAPI or ReadAPI. Default: 0 (the SDK's default
+	// of 11 attempts)
+	MaxAttempts int `json:"max_attempts,omitempty"`
+
+	// WriteAuditLog - [optional] log every successful write at info level with its operation,
+	// key, and the size of the value, but never the value itself, for an audit trail of changes.
+	// Copies written to the fallback table aren't logged. Default: false
+	WriteAuditLog bool `json:"write_audit_log,omitempty"`
+
+	// MovePreservesLastUpdated - [optional] keep the LastUpdated time of a value moved with
+	// Move, instead of setting it to the time of the move. Default: false
+	MovePreservesLastUpdated bool `json:"move_preserves_last_updated,omitempty"`
+
+	// Compression - [optional] compress values of 1KB or more with gzip or zstd before storing
+	// them. The codec is recorded on each item, so values can be loaded whatever this is set
+	// to, including values stored uncompressed. Default: none
+	Compression string `json:"compression,omitempty"`
+
+	// EnableChunking - [optional] store values larger than ChunkSize in parts, each in an item of
+	// its own at "<key>#<id>#part<n>", where id is new for every write, so that values aren't
+	// limited to DynamoDB's 400KB item size.
+	// Values stored in parts can be loaded whether this is set or not. Default: false
+	EnableChunking bool `json:"enable_chunking,omitempty"`
+
+	// ChunkSize - [optional] size in bytes of each part of a value stored in parts, at most
+	// 290KB so that the encoded part fits in an item. Default: 256KB
+	ChunkSize int `json:"chunk_size,omitempty"`
+
+	// SizeWarnThreshold - [optional] log a warning when storing an item larger than this many
+	// bytes, as a heads-up before items reach DynamoDB's 400KB limit. Default: 300KB
+	SizeWarnThreshold int `json:"size_warn_threshold,omitempty"`
+
+	// TrackConsumedCapacity - [optional] have DynamoDB return the read and write capacity
+	// consumed by each request, and log it at debug level. Default: false
+	TrackConsumedCapacity bool `json:"track_consumed_capacity,omitempty"`
+
+	// ClockSkewTolerance - [optional] how far Stat's Modified time may be in the future, because the
+	// storing instance's clock was ahead, before a warning is logged. Modified times in the future
+	// are always clamped to now. Default: 0
+	ClockSkewTolerance caddy.Duration `json:"clock_skew_tolerance,omitempty"`
+
+	// CircuitBreakerThreshold - [optional] number of consecutive failed requests to DynamoDB, within
+	// CircuitBreakerWindow, after which requests fail right away with ErrCircuitOpen for
+	// CircuitBreakerCooldown. A single request is then let through to test whether DynamoDB has
+	// recovered. Failures are server errors and requests that got no response. Default: 0 (disabled)
+	CircuitBreakerThreshold int `json:"circuit_breaker_threshold,omitempty"`
+
+	// CircuitBreakerWindow - [optional] period in which consecutive failures are counted. Default: 1 minute
+	CircuitBreakerWindow caddy.Duration `json:"circuit_breaker_window,omitempty"`
+
+	// CircuitBreakerCooldown - [optional] how long requests fail with ErrCircuitOpen once the circuit
+	// breaker opens. Default: 30 seconds
+	CircuitBreakerCooldown caddy.Duration `json:"circuit_breaker_cooldown,omitempty"`
+
+	// ReadTimeout - [optional] how long a single read from DynamoDB, including retries,
+	// may take before it is canceled. Default: 10 seconds
+	ReadTimeout caddy.Duration `json:"read_timeout,omitempty"`
+
+	// WriteTimeout - [optional] how long a single write to DynamoDB, including retries,
+	// may take before it is canceled. Default: 10 seconds
+	WriteTimeout caddy.Duration `json:"write_timeout,omitempty"`
+
+	// CacheTTL - [optional] how long loaded items are kept in an in-process read cache.
+	// Items stored or deleted through this instance are evicted immediately, but changes
+	// made by other instances may not be seen until the TTL passes. Default: 0 (disabled)
+	CacheTTL caddy.Duration `json:"cache_ttl,omitempty"`
+
+	// CacheMaxItems - [optional] maximum number of items kept in the read cache. Default: 1000
+	CacheMaxItems int `json:"cache_max_items,omitempty"`
+
+	// OnStore - [optional] called with the key after Store or StoreIfVersion writes a value.
+	// Not called when the write fails or is skipped because the value is unchanged.
+	OnStore func(key string) `json:"-"`
+
+	// OnDelete - [optional] called with the key after Delete succeeds.
+	OnDelete func(key string) `json:"-"`
+
+	cache *itemCache
+
+	// locks maps each key locked by this instance to the ID of its lock
+	locks *sync.Map
+
+	// fallback reads and writes FallbackTable, if set
+	fallback *Storage
+
+	// dax is the client created for DaxEndpoint, closed by Cleanup
+	dax *dax.Dax
+
+	// breaker stops requests to the table while they keep failing, if CircuitBreakerThreshold is set
+	breaker *circuitBreaker
+
+	// limiter limits the requests in flight to MaxConcurrentOps, if set
+	limiter *semaphore.Weighted
+
+	logger *zap.Logger
+
+	// owner is recorded on locks to show which instance holds them
+	owner string
+
+	// keyPrefix is the part of PartitionKeyTemplate before {key}, with the tenant filled in
+	keyPrefix string
+
+	// initMu makes initConfig safe to call from concurrent first uses, without
+	// holding up other Storages while it creates a session or looks up the region
+	initMu sync.Mutex
 }
 
 // initConfig initializes configuration for table name and AWS session
 func (s *Storage) initConfig() error {
+	s.initMu.Lock()
+	defer s.initMu.Unlock()
+
+	// cleared once added, so that they are only added once
+	if s.TablePrefix != "" || s.TableSuffix != "" {
+		s.Table = s.TablePrefix + s.Table + s.TableSuffix
+		s.TablePrefix, s.TableSuffix = "", ""
+	}
 	if s.Table == "" {
-		return errors.New("config error: table name is required")
+		return ErrTableRequired
 	}
 
 	if s.LockTimeout == 0 {
@@ -75,290 +517,2779 @@ func (s *Storage) initConfig() error {
 	if s.LockPollingInterval == 0 {
 		s.LockPollingInterval = lockPollingInterval
 	}
+	if s.LockPollingInterval >= s.LockTimeout {
+		return fmt.Errorf("config error: lock polling interval (%s) must be less than lock timeout (%s)",
+			time.Duration(s.LockPollingInterval), time.Duration(s.LockTimeout))
+	}
+
+	if s.SortKeyAttribute != "" && s.SortKeyValue == "" {
+		return errors.New("config error: sort key value is required when a sort key attribute is set")
+	}
+
+	if s.Base64Variant == "" {
+		s.Base64Variant = "std"
+	}
+	if _, ok := base64Encodings[s.Base64Variant]; !ok {
+		return fmt.Errorf("config error: unsupported base64 variant %q, must be std, url, raw-std, or raw-url",
+			s.Base64Variant)
+	}
+	if s.KeyEncoding == "" {
+		s.KeyEncoding = "raw"
+	}
+	if s.KeyEncoding != "raw" && s.KeyEncoding != "urlencode" && s.KeyEncoding != "hex-sha256" {
+		return fmt.Errorf("config error: unsupported key encoding %q, must be raw, urlencode, or hex-sha256", s.KeyEncoding)
+	}
+	if s.Compression != "" && s.Compression != "gzip" && s.Compression != "zstd" {
+		return fmt.Errorf("config error: unsupported compression %q, must be gzip or zstd", s.Compression)
+	}
+
+	if s.Tenant != "" && s.PartitionKeyTemplate == "" {
+		s.PartitionKeyTemplate = partitionKeyTemplate
+	}
+	if s.PartitionKeyTemplate != "" {
+		if !strings.HasSuffix(s.PartitionKeyTemplate, "{key}") || strings.Count(s.PartitionKeyTemplate, "{key}") != 1 {
+			return fmt.Errorf("config error: partition key template %q must end with {key}", s.PartitionKeyTemplate)
+		}
+		if strings.Contains(s.PartitionKeyTemplate, "{tenant}") && s.Tenant == "" {
+			return errors.New("config error: tenant is required when the partition key template contains {tenant}")
+		}
+		s.keyPrefix = strings.ReplaceAll(strings.TrimSuffix(s.PartitionKeyTemplate, "{key}"), "{tenant}", s.Tenant)
+	}
+
+	if s.PrimaryKeyAttribute == "" {
+		s.PrimaryKeyAttribute = primaryKeyAttribute
+	}
+	if s.ContentsAttribute == "" {
+		s.ContentsAttribute = contentsAttribute
+	}
+	if s.LastUpdatedAttribute == "" {
+		s.LastUpdatedAttribute = lastUpdatedAttribute
+	}
+	if s.LockIDAttribute == "" {
+		s.LockIDAttribute = lockIDAttribute
+	}
+	if s.ExpiresAtAttribute == "" {
+		s.ExpiresAtAttribute = expiresAtAttribute
+	}
+
+	if s.SizeWarnThreshold == 0 {
+		s.SizeWarnThreshold = sizeWarnThreshold
+	}
+
+	if s.EnableChunking && s.ChunkSize == 0 {
+		s.ChunkSize = chunkSize
+	}
+	if s.ChunkSize < 0 || s.ChunkSize > maxChunkSize {
+		return fmt.Errorf("config error: chunk size (%d) must be between 1 and %d bytes", s.ChunkSize, maxChunkSize)
+	}
+
+	if f := s.ExtraListFilter; f != nil {
+		if f.Attribute == "" || f.Value == "" {
+			return errors.New("config error: the extra list filter needs both an attribute and a value")
+		}
+		if f.Attribute == s.PrimaryKeyAttribute || f.Attribute == s.SortKeyAttribute {
+			return fmt.Errorf("config error: the extra list filter can't be on the key attribute %q", f.Attribute)
+		}
+	}
+
+	if s.ScanParallelism < 0 || s.ScanParallelism > maxScanParallelism {
+		return fmt.Errorf("config error: scan parallelism (%d) must be between 1 and %d", s.ScanParallelism, maxScanParallelism)
+	}
+
+	if s.MaxConcurrentOps < 0 {
+		return fmt.Errorf("config error: max concurrent ops (%d) must not be negative", s.MaxConcurrentOps)
+	}
+	if s.MaxConcurrentOps > 0 && s.limiter == nil {
+		s.limiter = semaphore.NewWeighted(int64(s.MaxConcurrentOps))
+	}
+
+	if s.MaxAttempts < 0 {
+		return fmt.Errorf("config error: max attempts (%d) must not be negative", s.MaxAttempts)
+	}
+
+	if s.ReadTimeout == 0 {
+		s.ReadTimeout = requestTimeout
+	}
+	if s.WriteTimeout == 0 {
+		s.WriteTimeout = requestTimeout
+	}
+
+	if s.locks == nil {
+		s.locks = &sync.Map{}
+	}
+
+	registerMetrics()
+
+	if s.logger == nil {
+		s.logger = caddy.Log().Named("storage.dynamodb")
+	}
+
+	if s.owner == "" {
+		s.owner = s.InstanceID
+		if s.owner == "" {
+			// only shown for troubleshooting, so leave it out if the hostname is unknown
+			s.owner, _ = os.Hostname()
+		}
+	}
+
+	if s.CircuitBreakerThreshold < 0 {
+		return fmt.Errorf("config error: circuit breaker threshold (%d) must not be negative", s.CircuitBreakerThreshold)
+	}
+	if s.CircuitBreakerThreshold > 0 && s.breaker == nil {
+		if s.CircuitBreakerWindow == 0 {
+			s.CircuitBreakerWindow = caddy.Duration(circuitBreakerWindow)
+		}
+		if s.CircuitBreakerCooldown == 0 {
+			s.CircuitBreakerCooldown = caddy.Duration(circuitBreakerCooldown)
+		}
+		s.breaker = s.newCircuitBreaker()
+	}
+
+	if s.CacheTTL > 0 && s.cache == nil {
+		if s.CacheMaxItems == 0 {
+			s.CacheMaxItems = cacheMaxItems
+		}
+		s.cache = newItemCache(time.Duration(s.CacheTTL), s.CacheMaxItems)
+	}
 
 	// Initialize AWS Session if needed
 	if s.AwsSession == nil {
+		if s.AwsEndpoint == "" {
+			s.AwsEndpoint = endpointFromEnv()
+		}
+
 		var err error
-		s.AwsSession, err = session.NewSession(&aws.Config{
-			Endpoint:   &s.AwsEndpoint,
-			Region:     &s.AwsRegion,
-			DisableSSL: &s.AwsDisableSSL,
+		s.AwsSession, err = session.NewSessionWithOptions(session.Options{
+			Config:            *s.awsConfig(),
+			Profile:           s.AwsProfile,
+			SharedConfigState: session.SharedConfigEnable,
 		})
 		if err != nil {
 			return err
 		}
+
+		// the SDK has already tried AWS_REGION, AWS_DEFAULT_REGION, and the shared config file
+		if aws.StringValue(s.AwsSession.Config.Region) == "" {
+			region, err := instanceRegion(s.AwsSession)
+			if err != nil {
+				return fmt.Errorf("config error: no AWS region is set and none was found in the instance metadata: %w", err)
+			}
+			s.AwsSession.Config.Region = aws.String(region)
+		}
 	}
 
-	return nil
-}
+	if s.DaxEndpoint != "" && s.ReadAPI == nil {
+		config := dax.NewConfigWithSession(*s.AwsSession)
+		// the session's endpoint, if any, is DynamoDB's
+		config.HostPorts = []string{s.DaxEndpoint}
+		client, err := dax.New(config)
+		if err != nil {
+			return fmt.Errorf("config error: DAX endpoint %s: %w", s.DaxEndpoint, err)
+		}
+		s.dax = client
+		s.ReadAPI = client
+	}
 
-// Store puts value at key.
-func (s *Storage) Store(_ context.Context, key string, value []byte) error {
-	if err := s.initConfig(); err != nil {
-		return err
+	if s.FallbackTable != "" && s.fallback == nil {
+		fallback := s.newFallback()
+		if err := fallback.initConfig(); err != nil {
+			return fmt.Errorf("config error: fallback table: %w", err)
+		}
+		s.fallback = fallback
 	}
 
-	encVal := base64.StdEncoding.EncodeToString(value)
+	return nil
+}
 
-	if key == "" {
-		return errors.New("key must not be empty")
+// newFallback returns the Storage for FallbackTable, which lays out items the
+// same way as s, through a session of its own. It has no cache, read API,
+// audit log, or hooks, and a circuit breaker of its own, as the fallback table
+// can be available while the primary one isn't.
+func (s *Storage) newFallback() *Storage {
+	config := aws.NewConfig()
+	if s.FallbackRegion != "" {
+		config.WithRegion(s.FallbackRegion)
 	}
 
-	svc := dynamodb.New(s.AwsSession)
-	input := &dynamodb.PutItemInput{
-		Item: map[string]*dynamodb.AttributeValue{
-			primaryKeyAttribute: {
-				S: aws.String(key),
-			},
-			contentsAttribute: {
-				S: aws.String(encVal),
-			},
-			lastUpdatedAttribute: {
-				S: aws.String(time.Now().Format(time.RFC3339)),
-			},
-		},
-		TableName: aws.String(s.Table),
+	return &Storage{
+		Table:                        s.FallbackTable,
+		AwsSession:                   s.AwsSession.Copy(config),
+		DynamoAPI:                    s.DynamoAPI,
+		PrimaryKeyAttribute:          s.PrimaryKeyAttribute,
+		ContentsAttribute:            s.ContentsAttribute,
+		LastUpdatedAttribute:         s.LastUpdatedAttribute,
+		LockIDAttribute:              s.LockIDAttribute,
+		ExpiresAtAttribute:           s.ExpiresAtAttribute,
+		SortKeyAttribute:             s.SortKeyAttribute,
+		SortKeyValue:                 s.SortKeyValue,
+		PartitionKeyTemplate:         s.PartitionKeyTemplate,
+		Tenant:                       s.Tenant,
+		InstanceID:                   s.InstanceID,
+		LockTimeout:                  s.LockTimeout,
+		LockPollingInterval:          s.LockPollingInterval,
+		ReadOnly:                     s.ReadOnly,
+		DryRun:                       s.DryRun,
+		SkipUnchangedWrites:          s.SkipUnchangedWrites,
+		VerifyChecksums:              s.VerifyChecksums,
+		HashSalt:                     s.HashSalt,
+		Base64Variant:                s.Base64Variant,
+		KeyEncoding:                  s.KeyEncoding,
+		ExistsConsistentRead:         s.ExistsConsistentRead,
+		FallbackToEventualOnThrottle: s.FallbackToEventualOnThrottle,
+		MaxConcurrentOps:             s.MaxConcurrentOps,
+		MaxAttempts:                  s.MaxAttempts,
+		MovePreservesLastUpdated:     s.MovePreservesLastUpdated,
+		Compression:                  s.Compression,
+		EnableChunking:               s.EnableChunking,
+		ChunkSize:                    s.ChunkSize,
+		SizeWarnThreshold:            s.SizeWarnThreshold,
+		TrackConsumedCapacity:        s.TrackConsumedCapacity,
+		ClockSkewTolerance:           s.ClockSkewTolerance,
+		CircuitBreakerThreshold:      s.CircuitBreakerThreshold,
+		CircuitBreakerWindow:         s.CircuitBreakerWindow,
+		CircuitBreakerCooldown:       s.CircuitBreakerCooldown,
+		ReadTimeout:                  s.ReadTimeout,
+		WriteTimeout:                 s.WriteTimeout,
+		limiter:                      s.limiter,
+		logger:                       s.logger,
 	}
+}
 
-	_, err := svc.PutItem(input)
-	return err
+// instanceRegion looks up the region of the EC2 instance we are running on
+func instanceRegion(sess *session.Session) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), regionLookupTimeout)
+	defer cancel()
+
+	return ec2metadata.New(sess, aws.NewConfig().WithMaxRetries(0)).RegionWithContext(ctx)
 }
 
-// Load retrieves the value at key.
-func (s *Storage) Load(_ context.Context, key string) ([]byte, error) {
-	if err := s.initConfig(); err != nil {
-		return []byte{}, err
-	}
+// endpointEnvVars are the environment variables an endpoint override is read
+// from when AwsEndpoint isn't set, in order of precedence
+var endpointEnvVars = []string{"AWS_ENDPOINT_URL_DYNAMODB", "AWS_ENDPOINT_URL", "AWS_ENDPOINT"}
 
-	if key == "" {
-		return []byte{}, errors.New("key must not be empty")
+// endpointFromEnv returns the DynamoDB endpoint set in the environment, if any
+func endpointFromEnv() string {
+	for _, name := range endpointEnvVars {
+		if endpoint := os.Getenv(name); endpoint != "" {
+			return endpoint
+		}
 	}
+	return ""
+}
 
-	domainItem, err := s.getItem(key)
-	return []byte(domainItem.Contents), err
+// awsConfig builds the AWS config for the session from the settings that
+// are set, leaving everything else, such as the region, to the SDK's
+// default lookup through environment variables and shared config files
+func (s *Storage) awsConfig() *aws.Config {
+	config := aws.NewConfig()
+	if s.AwsEndpoint != "" {
+		config.WithEndpoint(s.AwsEndpoint)
+	}
+	if s.AwsRegion != "" {
+		config.WithRegion(s.AwsRegion)
+	}
+	if s.AwsDisableSSL {
+		config.WithDisableSSL(true)
+	}
+	if s.UseFIPSEndpoint {
+		config.UseFIPSEndpoint = endpoints.FIPSEndpointStateEnabled
+	}
+	if s.UseDualStackEndpoint {
+		config.UseDualStackEndpoint = endpoints.DualStackEndpointStateEnabled
+	}
+	if s.DebugAWSRequests {
+		config.WithLogLevel(aws.LogDebugWithHTTPBody)
+		config.WithLogger(aws.LoggerFunc(func(args ...interface{}) {
+			s.logger.Info(fmt.Sprint(args...))
+		}))
+	}
+	return config
 }
 
-// Delete deletes key.
-func (s *Storage) Delete(_ context.Context, key string) error {
+// DynamoClient returns a DynamoDB client configured like the ones used by the
+// storage, e.g. to query other data in a table shared with it. It comes with the
+// storage's handlers for TrackConsumedCapacity and the circuit breaker. Changing
+// its configuration or handlers is unsupported, as it may share them with the
+// storage's own clients.
+func (s *Storage) DynamoClient() (*dynamodb.DynamoDB, error) {
 	if err := s.initConfig(); err != nil {
-		return err
+		return nil, err
 	}
+	return s.newClient(), nil
+}
 
-	if key == "" {
-		return errors.New("key must not be empty")
+// client returns DynamoAPI if it's set, or else a new client for the session,
+// limited to MaxConcurrentOps requests at once
+func (s *Storage) client() DynamoAPI {
+	if s.DynamoAPI != nil {
+		return s.limited(s.DynamoAPI)
 	}
+	return s.limited(s.newClient())
+}
 
-	svc := dynamodb.New(s.AwsSession)
-	input := &dynamodb.DeleteItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			primaryKeyAttribute: {
-				S: aws.String(key),
-			},
-		},
-		TableName: aws.String(s.Table),
+// readClient returns ReadAPI if it's set, or else the client for all requests
+func (s *Storage) readClient() DynamoAPI {
+	if s.ReadAPI != nil {
+		return s.limited(s.ReadAPI)
 	}
+	return s.client()
+}
 
-	_, err := svc.DeleteItem(input)
-	if err != nil {
-		return err
+// limited returns api limited to MaxConcurrentOps requests at once, if set
+func (s *Storage) limited(api DynamoAPI) DynamoAPI {
+	if s.limiter == nil {
+		return api
 	}
+	return &limitedAPI{DynamoAPI: api, sem: s.limiter}
+}
 
-	return nil
+// newClient returns a DynamoDB client for the session, which wraps throttling
+// errors in ErrThrottled and records the capacity consumed by each request
+// when TrackConsumedCapacity is set
+func (s *Storage) newClient() *dynamodb.DynamoDB {
+	var configs []*aws.Config
+	if s.MaxAttempts > 0 {
+		configs = append(configs, &aws.Config{Retryer: s.retryer()})
+	}
+	svc := dynamodb.New(s.AwsSession, configs...)
+	svc.Handlers.AfterRetry.PushBack(wrapThrottled)
+	if s.TrackConsumedCapacity {
+		svc.Handlers.Build.PushFront(returnConsumedCapacity)
+		svc.Handlers.Complete.PushBack(s.logConsumedCapacity)
+	}
+	if s.breaker != nil {
+		svc.Handlers.Validate.PushFront(s.breaker.allowRequest)
+		svc.Handlers.Complete.PushBack(s.breaker.recordResult)
+	}
+	return svc
 }
 
-// Exists returns true if the key exists
-// and there was no error checking.
-func (s *Storage) Exists(ctx context.Context, key string) bool {
+// newCircuitBreaker returns a circuit breaker for requests to the table
+func (s *Storage) newCircuitBreaker() *circuitBreaker {
+	return newCircuitBreaker(s.CircuitBreakerThreshold, time.Duration(s.CircuitBreakerWindow),
+		time.Duration(s.CircuitBreakerCooldown), s.logger.With(zap.String("table", s.Table)))
+}
 
-	cert, err := s.Load(ctx, key)
-	if string(cert) != "" && err == nil {
-		return true
+// returnConsumedCapacity asks DynamoDB to return the capacity consumed by
+// the request, for the kinds of requests that support it
+func returnConsumedCapacity(r *request.Request) {
+	total := aws.String(dynamodb.ReturnConsumedCapacityTotal)
+	switch input := r.Params.(type) {
+	case *dynamodb.GetItemInput:
+		input.ReturnConsumedCapacity = total
+	case *dynamodb.PutItemInput:
+		input.ReturnConsumedCapacity = total
+	case *dynamodb.UpdateItemInput:
+		input.ReturnConsumedCapacity = total
+	case *dynamodb.DeleteItemInput:
+		input.ReturnConsumedCapacity = total
+	case *dynamodb.ScanInput:
+		input.ReturnConsumedCapacity = total
+	case *dynamodb.QueryInput:
+		input.ReturnConsumedCapacity = total
+	case *dynamodb.TransactWriteItemsInput:
+		input.ReturnConsumedCapacity = total
 	}
-
-	return false
 }
 
-// List returns all keys that match prefix.
-// If recursive is true, non-terminal keys
-// will be enumerated (i.e. "directories"
-// should be walked); otherwise, only keys
-// prefixed exactly by prefix will be listed.
-func (s *Storage) List(_ context.Context, prefix string, recursive bool) ([]string, error) {
-	if err := s.initConfig(); err != nil {
-		return []string{}, err
+// logConsumedCapacity logs the capacity consumed by a successful request
+// at debug level
+func (s *Storage) logConsumedCapacity(r *request.Request) {
+	if r.Error != nil {
+		return
 	}
 
-	if prefix == "" {
-		return []string{}, errors.New("key prefix must not be empty")
+	var consumed []*dynamodb.ConsumedCapacity
+	switch output := r.Data.(type) {
+	case *dynamodb.GetItemOutput:
+		consumed = append(consumed, output.ConsumedCapacity)
+	case *dynamodb.PutItemOutput:
+		consumed = append(consumed, output.ConsumedCapacity)
+	case *dynamodb.UpdateItemOutput:
+		consumed = append(consumed, output.ConsumedCapacity)
+	case *dynamodb.DeleteItemOutput:
+		consumed = append(consumed, output.ConsumedCapacity)
+	case *dynamodb.ScanOutput:
+		consumed = append(consumed, output.ConsumedCapacity)
+	case *dynamodb.QueryOutput:
+		consumed = append(consumed, output.ConsumedCapacity)
+	case *dynamodb.TransactWriteItemsOutput:
+		consumed = output.ConsumedCapacity
 	}
 
-	svc := dynamodb.New(s.AwsSession)
-	input := &dynamodb.ScanInput{
-		ExpressionAttributeNames: map[string]*string{
-			"#D": aws.String(primaryKeyAttribute),
-		},
-		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
-			":p": {
-				S: aws.String(prefix),
-			},
-		},
-		FilterExpression: aws.String("begins_with(#D, :p)"),
-		TableName:        aws.String(s.Table),
-		ConsistentRead:   aws.Bool(true),
+	for _, c := range consumed {
+		if c == nil {
+			continue
+		}
+		s.logger.Debug("consumed capacity",
+			zap.String("operation", r.Operation.Name),
+			zap.String("table", aws.StringValue(c.TableName)),
+			zap.Float64("capacity_units", aws.Float64Value(c.CapacityUnits)))
 	}
+}
 
-	var matchingKeys []string
-	pageNum := 0
-	err := svc.ScanPages(input,
-		func(page *dynamodb.ScanOutput, lastPage bool) bool {
-			pageNum++
+// readTimeout limits a request that reads items to ReadTimeout
+func (s *Storage) readTimeout() request.Option {
+	return withTimeout(time.Duration(s.ReadTimeout))
+}
 
-			var items []Item
-			err := dynamodbattribute.UnmarshalListOfMaps(page.Items, &items)
-			if err != nil {
-				log.Printf("error unmarshaling page of items: %s", err.Error())
-				return false
-			}
+// writeTimeout limits a request that writes items to WriteTimeout
+func (s *Storage) writeTimeout() request.Option {
+	return withTimeout(time.Duration(s.WriteTimeout))
+}
 
-			for _, i := range items {
-				matchingKeys = append(matchingKeys, i.PrimaryKey)
-			}
+// withTimeout returns a request option that cancels the request once timeout
+// has passed, in addition to any deadline of the context it is made with
+func withTimeout(timeout time.Duration) request.Option {
+	return func(r *request.Request) {
+		if timeout <= 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		r.SetContext(ctx)
+		r.Handlers.Complete.PushBack(func(*request.Request) { cancel() })
+	}
+}
 
-			return !lastPage
-		})
+// Store puts value at key.
+func (s *Storage) Store(ctx context.Context, key string, value []byte) error {
+	return s.store(ctx, key, value, time.Time{}, nil, 0)
+}
 
-	if err != nil {
-		return []string{}, err
+// StoreWithTTL puts value at key like Store, but has DynamoDB delete it once
+// ttl has passed. This relies on the table having TTL enabled on the ExpiresAt
+// attribute. DynamoDB usually deletes expired items within a few days, so the
+// item may still be loaded for a while after it expires.
+func (s *Storage) StoreWithTTL(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("ttl must be positive, got %s", ttl)
 	}
+	return s.store(ctx, key, value, time.Now().Add(ttl), nil, 0)
+}
 
-	return matchingKeys, nil
+// StoreWithMeta puts value at key like Store, along with metadata such as
+// the issuer of a certificate, which can be read back with LoadWithMeta.
+// Storing the key again with Store drops the metadata.
+func (s *Storage) StoreWithMeta(ctx context.Context, key string, value []byte, meta map[string]string) error {
+	return s.store(ctx, key, value, time.Time{}, meta, 0)
 }
 
-// Stat returns information about key.
-func (s *Storage) Stat(_ context.Context, key string) (certmagic.KeyInfo, error) {
+// store puts value at key, setting the ExpiresAt attribute unless
+// expiresAt is the zero time, the Meta attribute unless meta is empty,
+// and the Version attribute unless version is 0
+func (s *Storage) store(ctx context.Context, key string, value []byte, expiresAt time.Time, meta map[string]string, version int64) error {
+	if err := s.initConfig(); err != nil {
+		return err
+	}
 
-	domainItem, err := s.getItem(key)
-	if err != nil {
-		return certmagic.KeyInfo{}, err
+	if s.ReadOnly {
+		return ErrReadOnly
 	}
 
-	return certmagic.KeyInfo{
-		Key:        key,
-		Modified:   domainItem.LastUpdated,
-		Size:       int64(len(domainItem.Contents)),
-		IsTerminal: true,
+	if key == "" {
+		return ErrEmptyKey
+	}
+
+	var parts valueParts
+	if s.EnableChunking && len(value) > s.ChunkSize {
+		// parts are written under keys of their own for every write, so that the
+		// parts of the value the item at key points to are never overwritten
+		parts = valueParts{ID: uuid.NewString(), Count: (len(value) + s.ChunkSize - 1) / s.ChunkSize}
+	}
+
+	item := s.newItem(key, value)
+	if parts.Count > 0 {
+		// the parts hold the contents, so the item at key only records which they are
+		delete(item, s.ContentsAttribute)
+		delete(item, encodingAttribute)
+		delete(item, compressionAttribute)
+		item[partsAttribute] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.Itoa(parts.Count)),
+		}
+		item[partsIDAttribute] = &dynamodb.AttributeValue{
+			S: aws.String(parts.ID),
+		}
+	}
+	if !expiresAt.IsZero() {
+		item[s.ExpiresAtAttribute] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatInt(expiresAt.Unix(), 10)),
+		}
+	}
+	if len(meta) > 0 {
+		item[metaAttribute] = &dynamodb.AttributeValue{
+			M: make(map[string]*dynamodb.AttributeValue, len(meta)),
+		}
+		for name, value := range meta {
+			item[metaAttribute].M[name] = &dynamodb.AttributeValue{S: aws.String(value)}
+		}
+	}
+	if version != 0 {
+		item[versionAttribute] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatInt(version, 10)),
+		}
+	}
+	if err := s.checkItemSize(key, item); err != nil {
+		return err
+	}
+
+	if s.DryRun {
+		s.logger.Info("dry run: skipped storing value",
+			zap.String("key", key), zap.Int("bytes", len(value)), zap.String("table", s.Table))
+		return nil
+	}
+
+	if parts.Count > 0 {
+		if err := s.storeParts(ctx, key, parts, value, expiresAt); err != nil {
+			return err
+		}
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:      item,
+		TableName: aws.String(s.Table),
+	}
+
+	// an unchanged value is still written with a TTL, to extend it,
+	// or with metadata or a version, which may have changed, and values
+	// stored in parts are always written, as the parts already have been
+	skipUnchanged := s.SkipUnchangedWrites && expiresAt.IsZero() && len(meta) == 0 && version == 0 && parts.Count == 0
+	if skipUnchanged {
+		// the item is only left as is if nothing but its value would be
+		// written, so that a TTL, metadata, or version stored before is cleared
+		input.ConditionExpression = aws.String("attribute_not_exists(#H) OR #H <> :h OR " +
+			"attribute_exists(#E) OR attribute_exists(#M) OR attribute_exists(#V) OR attribute_exists(#P)")
+		input.ExpressionAttributeNames = map[string]*string{
+			"#H": aws.String(contentHashAttribute),
+			"#E": aws.String(s.ExpiresAtAttribute),
+			"#M": aws.String(metaAttribute),
+			"#V": aws.String(versionAttribute),
+			"#P": aws.String(partsAttribute),
+		}
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":h": input.Item[contentHashAttribute],
+		}
+	}
+
+	err := s.writeItem(ctx, key, input, parts)
+	skipped := skipUnchanged && isConditionalCheckFailed(err)
+	if skipped {
+		// the stored value is already identical, which it may not be in the fallback table
+		err = nil
+	}
+	if err != nil {
+		if parts.Count > 0 {
+			// the item at key still points to the parts of the previous value
+			if cleanupErr := s.deleteParts(ctx, key, parts); cleanupErr != nil {
+				s.logger.Warn("failed to delete parts of a value that couldn't be stored",
+					zap.String("key", key), zap.Error(cleanupErr))
+			}
+		}
+		return err
+	}
+	if !skipped {
+		s.auditWrite("Store", key, zap.Int("bytes", len(value)))
+	}
+
+	if s.fallback != nil {
+		if err := s.fallback.store(ctx, key, value, expiresAt, meta, version); err != nil {
+			return fmt.Errorf("storing %s in fallback table: %w", key, err)
+		}
+	}
+
+	if s.OnStore != nil {
+		s.OnStore(key)
+	}
+	return nil
+}
+
+// StoreTransaction puts every value in items at its key in a single
+// transaction, so that either all of them are stored or none are.
+// DynamoDB limits a transaction to 100 items. Empty items store nothing.
+func (s *Storage) StoreTransaction(ctx context.Context, items map[string][]byte) error {
+	if err := s.initConfig(); err != nil {
+		return err
+	}
+
+	if s.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if len(items) == 0 {
+		// DynamoDB rejects transactions without items
+		return nil
+	}
+	if len(items) > maxTransactionItems {
+		return fmt.Errorf("a transaction can store at most %d items, got %d", maxTransactionItems, len(items))
+	}
+
+	// sorted so that requests are the same for the same items
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		if key == "" {
+			return ErrEmptyKey
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	input := &dynamodb.TransactWriteItemsInput{}
+	for _, key := range keys {
+		item := s.newItem(key, items[key])
+		if err := s.checkItemSize(key, item); err != nil {
+			return err
+		}
+		input.TransactItems = append(input.TransactItems, &dynamodb.TransactWriteItem{
+			Put: &dynamodb.Put{
+				Item:      item,
+				TableName: aws.String(s.Table),
+			},
+		})
+	}
+
+	if s.DryRun {
+		s.logger.Info("dry run: skipped storing values in a transaction",
+			zap.Strings("keys", keys), zap.String("table", s.Table))
+		return nil
+	}
+
+	// a transaction can't return the items it replaces, so the parts of the
+	// previous values are read first, and the transaction only goes through if
+	// they're still the same
+	previousParts := make(map[string]valueParts, len(keys))
+	if s.EnableChunking {
+		for i, key := range keys {
+			previous, err := s.storedParts(ctx, key)
+			if err != nil {
+				return err
+			}
+			previousParts[key] = previous
+			put := input.TransactItems[i].Put
+			put.ExpressionAttributeNames = map[string]*string{
+				"#P": aws.String(partsAttribute),
+				"#I": aws.String(partsIDAttribute),
+			}
+			switch {
+			case previous.Count == 0:
+				put.ConditionExpression = aws.String("attribute_not_exists(#P)")
+			case previous.ID == "":
+				put.ConditionExpression = aws.String("#P = :p AND attribute_not_exists(#I)")
+				put.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+					":p": {N: aws.String(strconv.Itoa(previous.Count))},
+				}
+			default:
+				put.ConditionExpression = aws.String("#P = :p AND #I = :i")
+				put.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+					":p": {N: aws.String(strconv.Itoa(previous.Count))},
+					":i": {S: aws.String(previous.ID)},
+				}
+			}
+		}
+	}
+
+	svc := s.client()
+	_, err := svc.TransactWriteItemsWithContext(ctx, input, s.writeTimeout())
+	for _, key := range keys {
+		s.evict(key)
+	}
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := s.deleteOldParts(ctx, key, previousParts[key]); err != nil {
+			return err
+		}
+		s.auditWrite("StoreTransaction", key, zap.Int("bytes", len(items[key])))
+	}
+
+	if s.fallback != nil {
+		if err := s.fallback.StoreTransaction(ctx, items); err != nil {
+			return fmt.Errorf("storing transaction in fallback table: %w", err)
+		}
+	}
+
+	if s.OnStore != nil {
+		for _, key := range keys {
+			s.OnStore(key)
+		}
+	}
+	return nil
+}
+
+// StoreIfVersion puts value at key only if the version currently stored
+// at key is expectedVersion, and returns the new version on success.
+// Use an expectedVersion of 0 for a key that doesn't exist yet, or that
+// was last written with Store, which doesn't track versions. If the
+// stored version doesn't match, a *VersionConflictError is returned.
+func (s *Storage) StoreIfVersion(ctx context.Context, key string, value []byte, expectedVersion int64) (int64, error) {
+	if err := s.initConfig(); err != nil {
+		return 0, err
+	}
+
+	if s.ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	if key == "" {
+		return 0, ErrEmptyKey
+	}
+
+	newVersion := expectedVersion + 1
+	item := s.newItem(key, value)
+	item[versionAttribute] = &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(newVersion, 10)),
+	}
+	if err := s.checkItemSize(key, item); err != nil {
+		return 0, err
+	}
+
+	if s.DryRun {
+		s.logger.Info("dry run: skipped storing value",
+			zap.String("key", key), zap.Int("bytes", len(value)), zap.String("table", s.Table),
+			zap.Int64("expected_version", expectedVersion))
+		return newVersion, nil
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item: item,
+		ExpressionAttributeNames: map[string]*string{
+			"#V": aws.String(versionAttribute),
+		},
+		TableName: aws.String(s.Table),
+	}
+	if expectedVersion == 0 {
+		input.ConditionExpression = aws.String("attribute_not_exists(#V)")
+	} else {
+		input.ConditionExpression = aws.String("#V = :v")
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":v": {
+				N: aws.String(strconv.FormatInt(expectedVersion, 10)),
+			},
+		}
+	}
+
+	err := s.writeItem(ctx, key, input, valueParts{})
+	if isConditionalCheckFailed(err) {
+		return 0, &VersionConflictError{Key: key, ExpectedVersion: expectedVersion}
+	}
+	if err != nil {
+		return 0, err
+	}
+	s.auditWrite("StoreIfVersion", key, zap.Int("bytes", len(value)), zap.Int64("version", newVersion))
+
+	if s.fallback != nil {
+		// the fallback table mirrors the item, version included, whatever version it had before
+		err := s.fallback.writeItem(ctx, key, &dynamodb.PutItemInput{
+			Item:      item,
+			TableName: aws.String(s.fallback.Table),
+		}, valueParts{})
+		if err != nil {
+			return newVersion, fmt.Errorf("storing %s in fallback table: %w", key, err)
+		}
+	}
+
+	if s.OnStore != nil {
+		s.OnStore(key)
+	}
+	return newVersion, nil
+}
+
+// VersionConflictError is returned by StoreIfVersion when the version
+// stored at Key is not the version the caller expected.
+type VersionConflictError struct {
+	Key             string
+	ExpectedVersion int64
+}
+
+func (e *VersionConflictError) Error() string {
+	return fmt.Sprintf("version conflict storing key %q: expected version %d", e.Key, e.ExpectedVersion)
+}
+
+// StorageError returns the conflict as a StorageError
+func (e *VersionConflictError) StorageError() *StorageError {
+	return &StorageError{
+		Code:      CodeVersionConflict,
+		Operation: "StoreIfVersion",
+		Key:       e.Key,
+		Message:   e.Error(),
+		Err:       e,
+	}
+}
+
+// Load retrieves the value at key. The item is read with a strongly
+// consistent read, so it reflects every write that completed before it,
+// unless it is served from the read cache.
+func (s *Storage) Load(ctx context.Context, key string) ([]byte, error) {
+	if err := s.initConfig(); err != nil {
+		return []byte{}, err
+	}
+
+	if key == "" {
+		return []byte{}, ErrEmptyKey
+	}
+
+	domainItem, err := s.loadItem(ctx, key)
+	return []byte(domainItem.Contents), err
+}
+
+// LoadWithMeta retrieves the value at key like Load, along with the metadata
+// stored with StoreWithMeta. The metadata is nil if none was stored.
+func (s *Storage) LoadWithMeta(ctx context.Context, key string) ([]byte, map[string]string, error) {
+	if err := s.initConfig(); err != nil {
+		return []byte{}, nil, err
+	}
+
+	if key == "" {
+		return []byte{}, nil, ErrEmptyKey
+	}
+
+	domainItem, err := s.loadItem(ctx, key)
+	if err != nil {
+		return []byte{}, nil, err
+	}
+	return []byte(domainItem.Contents), domainItem.Meta, nil
+}
+
+// LoadRange retrieves length bytes of the value at key, starting at offset.
+// The whole item is still read from DynamoDB, but only the range is returned.
+func (s *Storage) LoadRange(ctx context.Context, key string, offset, length int64) ([]byte, error) {
+	value, err := s.Load(ctx, key)
+	if err != nil {
+		return []byte{}, err
+	}
+
+	if offset < 0 || length < 0 || offset+length > int64(len(value)) {
+		return []byte{}, fmt.Errorf("range %d-%d is out of bounds for %s, which is %d bytes",
+			offset, offset+length, key, len(value))
+	}
+	return value[offset : offset+length], nil
+}
+
+// LoadReader retrieves the value at key like Load, as a reader for callers that
+// stream it into a writer. Values are stored inline in DynamoDB items, so the
+// whole value is still read and decoded before the reader is returned.
+func (s *Storage) LoadReader(ctx context.Context, key string) (io.ReadCloser, error) {
+	value, err := s.Load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(value)), nil
+}
+
+// Delete deletes key.
+func (s *Storage) Delete(ctx context.Context, key string) error {
+	if err := s.initConfig(); err != nil {
+		return err
+	}
+
+	if s.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if key == "" {
+		return ErrEmptyKey
+	}
+
+	if s.DryRun {
+		s.logger.Info("dry run: skipped deleting value", zap.String("key", key), zap.String("table", s.Table))
+		return nil
+	}
+
+	if err := s.deleteValue(ctx, key); err != nil {
+		return err
+	}
+	s.auditWrite("Delete", key)
+
+	if s.fallback != nil {
+		if err := s.fallback.deleteValue(ctx, key); err != nil {
+			return fmt.Errorf("deleting %s from fallback table: %w", key, err)
+		}
+	}
+
+	if s.OnDelete != nil {
+		s.OnDelete(key)
+	}
+	return nil
+}
+
+// DeleteIf deletes key only if its value is expectedContent, and reports
+// whether it was deleted. This keeps an instance from deleting a value
+// that another instance has just replaced.
+func (s *Storage) DeleteIf(ctx context.Context, key string, expectedContent []byte) (bool, error) {
+	if err := s.initConfig(); err != nil {
+		return false, err
+	}
+
+	if s.ReadOnly {
+		return false, ErrReadOnly
+	}
+
+	if key == "" {
+		return false, ErrEmptyKey
+	}
+
+	if s.DryRun {
+		s.logger.Info("dry run: skipped deleting value", zap.String("key", key), zap.String("table", s.Table))
+		return true, nil
+	}
+
+	// the hash matches however the value was encoded or compressed, while
+	// items stored before hashes were recorded can only be compared as is
+	contentHash := s.ContentHash(expectedContent)
+	svc := s.client()
+	input := &dynamodb.DeleteItemInput{
+		ConditionExpression: aws.String("#H = :h OR #C = :c"),
+		ExpressionAttributeNames: map[string]*string{
+			"#H": aws.String(contentHashAttribute),
+			"#C": aws.String(s.ContentsAttribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":h": {
+				S: aws.String(contentHash),
+			},
+			":c": {
+				S: aws.String(base64Encodings[s.Base64Variant].EncodeToString(expectedContent)),
+			},
+		},
+		Key:          s.itemKey(key),
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+		TableName:    aws.String(s.Table),
+	}
+
+	result, err := svc.DeleteItemWithContext(ctx, input, s.writeTimeout())
+	s.evict(key)
+	if isConditionalCheckFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := s.deleteParts(ctx, key, partsOf(result.Attributes)); err != nil {
+		return true, fmt.Errorf("deleting parts of %s: %w", key, err)
+	}
+	s.auditWrite("DeleteIf", key)
+
+	if s.fallback != nil {
+		if err := s.fallback.deleteValue(ctx, key); err != nil {
+			return true, fmt.Errorf("deleting %s from fallback table: %w", key, err)
+		}
+	}
+
+	if s.OnDelete != nil {
+		s.OnDelete(key)
+	}
+	return true, nil
+}
+
+// Move moves the value at oldKey to newKey, replacing any value at newKey,
+// in a single transaction so that the value is never at both keys or neither.
+// The move fails without changes if the value at oldKey changes meanwhile.
+// LastUpdated is set to the time of the move, unless MovePreservesLastUpdated
+// is set. Values stored in parts can't be moved.
+func (s *Storage) Move(ctx context.Context, oldKey, newKey string) error {
+	if err := s.initConfig(); err != nil {
+		return err
+	}
+
+	if s.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if oldKey == "" || newKey == "" {
+		return ErrEmptyKey
+	}
+
+	svc := s.client()
+	result, err := svc.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key:            s.itemKey(oldKey),
+		TableName:      aws.String(s.Table),
+		ConsistentRead: aws.Bool(true),
+	}, s.readTimeout())
+	if err != nil {
+		return err
+	}
+	if _, ok := result.Item[partsAttribute]; ok {
+		return fmt.Errorf("moving %s: values stored in parts can't be moved", oldKey)
+	}
+	if stringAttribute(result.Item, s.ContentsAttribute) == "" {
+		return fmt.Errorf("moving %s: %w", oldKey, fs.ErrNotExist)
+	}
+	if oldKey == newKey {
+		return nil
+	}
+
+	item := make(map[string]*dynamodb.AttributeValue, len(result.Item))
+	for name, value := range result.Item {
+		item[name] = value
+	}
+	for name, value := range s.keyAttributes(newKey) {
+		item[name] = value
+	}
+	if !s.MovePreservesLastUpdated {
+		now := time.Now()
+		item[s.LastUpdatedAttribute] = &dynamodb.AttributeValue{
+			S: aws.String(now.Format(time.RFC3339)),
+		}
+		item[lastUpdatedEpochAttribute] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatInt(now.Unix(), 10)),
+		}
+	}
+
+	if s.DryRun {
+		s.logger.Info("dry run: skipped moving value",
+			zap.String("key", oldKey), zap.String("new_key", newKey), zap.String("table", s.Table))
+		return nil
+	}
+
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []*dynamodb.TransactWriteItem{
+			{
+				Put: &dynamodb.Put{
+					Item:      item,
+					TableName: aws.String(s.Table),
+				},
+			},
+			{
+				Delete: &dynamodb.Delete{
+					ConditionExpression: aws.String("#C = :c"),
+					ExpressionAttributeNames: map[string]*string{
+						"#C": aws.String(s.ContentsAttribute),
+					},
+					ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+						":c": result.Item[s.ContentsAttribute],
+					},
+					Key:       s.itemKey(oldKey),
+					TableName: aws.String(s.Table),
+				},
+			},
+		},
+	}
+	_, err = svc.TransactWriteItemsWithContext(ctx, input, s.writeTimeout())
+	s.evict(oldKey)
+	s.evict(newKey)
+	if err != nil {
+		return fmt.Errorf("moving %s to %s: %w", oldKey, newKey, err)
+	}
+	s.auditWrite("Move", oldKey, zap.String("new_key", newKey))
+
+	if s.fallback != nil {
+		if err := s.fallback.Move(ctx, oldKey, newKey); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("moving %s in fallback table: %w", oldKey, err)
+		}
+	}
+
+	if s.OnDelete != nil {
+		s.OnDelete(oldKey)
+	}
+	if s.OnStore != nil {
+		s.OnStore(newKey)
+	}
+	return nil
+}
+
+// Exists returns true if the key exists
+// and there was no error checking.
+// Like Load, an item without contents doesn't count.
+// The item is read with an eventually consistent read
+// unless ExistsConsistentRead is set, so it may still see a value deleted
+// just before.
+func (s *Storage) Exists(ctx context.Context, key string) bool {
+	if err := s.initConfig(); err != nil {
+		return false
+	}
+
+	if key == "" {
+		return false
+	}
+
+	if s.cache != nil {
+		if _, ok := s.cache.get(key); ok {
+			return true
+		}
+	}
+
+	exists, err := s.itemExists(ctx, key)
+	if err != nil && s.fallback != nil && isUnavailable(err) {
+		exists, err = s.fallback.itemExists(ctx, key)
+	}
+	return err == nil && exists
+}
+
+// unprocessedRetries returns how many times keys left unprocessed by a batch
+// request are requested again, bounded like the SDK's retries by MaxAttempts
+func (s *Storage) unprocessedRetries() int {
+	if s.MaxAttempts > 0 {
+		return s.MaxAttempts - 1
+	}
+	return unprocessedRetries
+}
+
+// itemExists returns true if there is a value at key, reading only the
+// attributes that getItem checks for one
+func (s *Storage) itemExists(ctx context.Context, key string) (bool, error) {
+	input := &dynamodb.GetItemInput{
+		Key:                  s.itemKey(key),
+		ProjectionExpression: aws.String("#C, #P"),
+		ExpressionAttributeNames: map[string]*string{
+			"#C": aws.String(s.ContentsAttribute),
+			"#P": aws.String(partsAttribute),
+		},
+		TableName:      aws.String(s.Table),
+		ConsistentRead: aws.Bool(s.ExistsConsistentRead),
+	}
+
+	result, err := s.readClient().GetItemWithContext(ctx, input, s.readTimeout())
+	if err != nil {
+		return false, err
+	}
+	// values stored in parts have no contents of their own
+	if _, ok := result.Item[partsAttribute]; ok {
+		return true, nil
+	}
+	return stringAttribute(result.Item, s.ContentsAttribute) != "", nil
+}
+
+// ExistsMany checks whether each of keys exists, reading them with as few
+// BatchGetItem requests as possible instead of one request per key. Only the
+// keys of the items are read, consistently if ExistsConsistentRead is set.
+// Keys that DynamoDB leaves unprocessed, e.g. when throttled, are requested
+// again with exponential backoff, up to MaxAttempts in all, or 11 by default,
+// after which an error wrapping ErrThrottled is returned.
+func (s *Storage) ExistsMany(ctx context.Context, keys []string) (map[string]bool, error) {
+	if err := s.initConfig(); err != nil {
+		return nil, err
+	}
+
+	exists := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if key == "" {
+			return nil, ErrEmptyKey
+		}
+		exists[key] = false
+	}
+
+	// duplicate keys aren't allowed in a batch
+	unique := make([]string, 0, len(exists))
+	for key := range exists {
+		unique = append(unique, key)
+	}
+	sort.Strings(unique)
+
+	names := map[string]*string{"#D": aws.String(s.PrimaryKeyAttribute)}
+	projection := "#D"
+	if s.KeyEncoding == "hex-sha256" {
+		names["#K"] = aws.String(keyAttribute)
+		projection = "#D, #K"
+	}
+	svc := s.readClient()
+	for start := 0; start < len(unique); start += maxBatchGetItems {
+		batch := &dynamodb.KeysAndAttributes{
+			ConsistentRead:           aws.Bool(s.ExistsConsistentRead),
+			ExpressionAttributeNames: names,
+			ProjectionExpression:     aws.String(projection),
+		}
+		for _, key := range unique[start:min(start+maxBatchGetItems, len(unique))] {
+			batch.Keys = append(batch.Keys, s.itemKey(key))
+		}
+
+		requestItems := map[string]*dynamodb.KeysAndAttributes{s.Table: batch}
+		wait := lockPollingBackoffStart
+		for retries := 0; len(requestItems) > 0; retries++ {
+			result, err := svc.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: requestItems,
+			}, s.readTimeout())
+			if err != nil {
+				return nil, contextError(ctx, err)
+			}
+			for _, item := range result.Responses[s.Table] {
+				exists[s.storageKey(item)] = true
+			}
+
+			requestItems = result.UnprocessedKeys
+			if len(requestItems) == 0 {
+				break
+			}
+			if retries == s.unprocessedRetries() {
+				return nil, fmt.Errorf("checking whether keys exist: %d keys still unprocessed after %d attempts: %w",
+					len(requestItems[s.Table].Keys), retries+1, ErrThrottled)
+			}
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			wait = min(wait*2, unprocessedMaxWait)
+		}
+	}
+
+	return exists, nil
+}
+
+// List returns all keys that match prefix.
+// If recursive is true, non-terminal keys
+// will be enumerated (i.e. "directories"
+// should be walked); otherwise, only keys
+// prefixed exactly by prefix will be listed.
+// The scan is eventually consistent unless
+// ListConsistentRead is set, so it can miss
+// or still include keys changed just before.
+func (s *Storage) List(ctx context.Context, prefix string, recursive bool) ([]string, error) {
+	var matchingKeys []string
+	err := s.ListFunc(ctx, prefix, func(key string) error {
+		matchingKeys = append(matchingKeys, key)
+		return nil
+	})
+	if err != nil {
+		return []string{}, err
+	}
+
+	if s.SortListResults {
+		sort.Strings(matchingKeys)
+	}
+	return matchingKeys, nil
+}
+
+// ListFunc calls fn with each key that matches prefix as the keys are
+// scanned, without holding all of them in memory like List. The next pages
+// are scanned while fn is called with the keys of the previous ones, up to a
+// few pages ahead. fn is called with one key at a time. If fn returns an
+// error, the scan stops and ListFunc returns that error.
+func (s *Storage) ListFunc(ctx context.Context, prefix string, fn func(key string) error) error {
+	if err := s.initConfig(); err != nil {
+		return err
+	}
+
+	if prefix == "" {
+		return fmt.Errorf("key prefix: %w", ErrEmptyKey)
+	}
+
+	input := s.scanPrefixInput(prefix)
+	s.addListFilter(input)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// each page is its own request, which the SDK retries with backoff when
+	// throttled, so a throttled page doesn't fail the whole scan
+	scan := s.scanKeys(ctx, input)
+	for keys := range scan.pages {
+		for _, key := range keys {
+			if err := fn(key); err != nil {
+				cancel()
+				for range scan.pages {
+				}
+				return err
+			}
+		}
+
+		select {
+		case scan.free <- keys:
+		default:
+		}
+	}
+
+	return scan.err
+}
+
+// ListPaged returns one page of the keys that match prefix, along with a
+// token to pass as startToken to get the next page. Use an empty startToken
+// for the first page. An empty nextToken means there are no more pages.
+// DynamoDB applies pageSize before filtering by prefix, so pages can have
+// fewer than pageSize keys, or none, even when more pages follow.
+func (s *Storage) ListPaged(ctx context.Context, prefix string, pageSize int32, startToken string) (keys []string, nextToken string, err error) {
+	if err := s.initConfig(); err != nil {
+		return nil, "", err
+	}
+
+	if prefix == "" {
+		return nil, "", fmt.Errorf("key prefix: %w", ErrEmptyKey)
+	}
+	if pageSize <= 0 {
+		return nil, "", fmt.Errorf("page size must be positive, got %d", pageSize)
+	}
+
+	input := s.scanPrefixInput(prefix)
+	s.addListFilter(input)
+	input.Limit = aws.Int64(int64(pageSize))
+	if startToken != "" {
+		input.ExclusiveStartKey, err = decodePageToken(startToken)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	svc := s.readClient()
+	result, err := svc.ScanWithContext(ctx, input, s.readTimeout())
+	if err != nil {
+		return nil, "", err
+	}
+
+	for _, i := range result.Items {
+		key, ok := s.listedKey(i)
+		if !ok {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	if len(result.LastEvaluatedKey) > 0 {
+		nextToken, err = encodePageToken(result.LastEvaluatedKey)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	return keys, nextToken, nil
+}
+
+// ListModifiedBetween returns the keys of the values last stored between start
+// and end, inclusive, to the second. Values stored before their LastUpdatedEpoch
+// was recorded are left out, as are locks.
+func (s *Storage) ListModifiedBetween(ctx context.Context, start, end time.Time) ([]string, error) {
+	if err := s.initConfig(); err != nil {
+		return nil, err
+	}
+
+	if end.Before(start) {
+		return nil, fmt.Errorf("end of the time window (%s) is before its start (%s)",
+			end.Format(time.RFC3339), start.Format(time.RFC3339))
+	}
+
+	input := &dynamodb.ScanInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#E": aws.String(lastUpdatedEpochAttribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":start": {
+				N: aws.String(strconv.FormatInt(start.Unix(), 10)),
+			},
+			":end": {
+				N: aws.String(strconv.FormatInt(end.Unix(), 10)),
+			},
+		},
+		FilterExpression: aws.String("#E BETWEEN :start AND :end"),
+		TableName:        aws.String(s.Table),
+		ConsistentRead:   aws.Bool(s.ListConsistentRead),
+	}
+
+	// skip other data sharing the table, and other tenants' items
+	if s.SortKeyAttribute != "" {
+		input.FilterExpression = aws.String(*input.FilterExpression + " AND #S = :s")
+		input.ExpressionAttributeNames["#S"] = aws.String(s.SortKeyAttribute)
+		input.ExpressionAttributeValues[":s"] = &dynamodb.AttributeValue{
+			S: aws.String(s.SortKeyValue),
+		}
+	}
+	if s.keyPrefix != "" {
+		input.FilterExpression = aws.String(*input.FilterExpression + " AND begins_with(#D, :p)")
+		input.ExpressionAttributeNames["#D"] = aws.String(s.PrimaryKeyAttribute)
+		input.ExpressionAttributeValues[":p"] = &dynamodb.AttributeValue{
+			S: aws.String(s.keyPrefix),
+		}
+	}
+
+	var keys []string
+	err := s.scanPages(ctx, input,
+		func(page *dynamodb.ScanOutput) bool {
+			for _, i := range page.Items {
+				key, ok := s.listedKey(i)
+				if !ok || strings.HasPrefix(key, "LOCK-") {
+					continue
+				}
+				keys = append(keys, key)
+			}
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// ContentHash returns the hex encoded hash that value is stored with: its
+// SHA-256 hash, or its HMAC-SHA256 with HashSalt as the key if that is set.
+func (s *Storage) ContentHash(value []byte) string {
+	if s.HashSalt == "" {
+		sum := sha256.Sum256(value)
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, []byte(s.HashSalt))
+	mac.Write(value)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// FindByContentHash returns the keys of the values whose hash is hashHex,
+// as returned by ContentHash, e.g. to find identical certificates stored under
+// several keys. It queries the ContentHashIndex, which must be configured.
+// Like all global secondary indexes, it is eventually consistent.
+func (s *Storage) FindByContentHash(ctx context.Context, hashHex string) ([]string, error) {
+	if err := s.initConfig(); err != nil {
+		return nil, err
+	}
+
+	if s.ContentHashIndex == "" {
+		return nil, errors.New("config error: a content hash index is required to find values by content hash")
+	}
+	if hashHex == "" {
+		return nil, errors.New("content hash must not be empty")
+	}
+
+	input := &dynamodb.QueryInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#H": aws.String(contentHashAttribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":h": {
+				S: aws.String(strings.ToLower(hashHex)),
+			},
+		},
+		IndexName:              aws.String(s.ContentHashIndex),
+		KeyConditionExpression: aws.String("#H = :h"),
+		TableName:              aws.String(s.Table),
+	}
+
+	// skip other data sharing the table, and other tenants' items
+	var filters []string
+	if s.SortKeyAttribute != "" {
+		filters = append(filters, "#S = :s")
+		input.ExpressionAttributeNames["#S"] = aws.String(s.SortKeyAttribute)
+		input.ExpressionAttributeValues[":s"] = &dynamodb.AttributeValue{
+			S: aws.String(s.SortKeyValue),
+		}
+	}
+	if s.keyPrefix != "" {
+		filters = append(filters, "begins_with(#D, :p)")
+		input.ExpressionAttributeNames["#D"] = aws.String(s.PrimaryKeyAttribute)
+		input.ExpressionAttributeValues[":p"] = &dynamodb.AttributeValue{
+			S: aws.String(s.keyPrefix),
+		}
+	}
+	if len(filters) > 0 {
+		input.FilterExpression = aws.String(strings.Join(filters, " AND "))
+	}
+
+	var keys []string
+	err := s.readClient().QueryPagesWithContext(ctx, input,
+		func(page *dynamodb.QueryOutput, lastPage bool) bool {
+			for _, i := range page.Items {
+				key, ok := s.listedKey(i)
+				if !ok || strings.HasPrefix(key, "LOCK-") {
+					continue
+				}
+				keys = append(keys, key)
+			}
+			return !lastPage
+		}, s.readTimeout())
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// encodePageToken turns the key a scan stopped at into an opaque token.
+// Key attributes are always strings, so only their string values are kept.
+func encodePageToken(key map[string]*dynamodb.AttributeValue) (string, error) {
+	values := make(map[string]string, len(key))
+	for name, value := range key {
+		values[name] = aws.StringValue(value.S)
+	}
+
+	token, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// decodePageToken returns the key encoded in a token from encodePageToken
+func decodePageToken(token string) (map[string]*dynamodb.AttributeValue, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("invalid page token: %w", err)
+	}
+
+	var values map[string]string
+	if err := json.Unmarshal(decoded, &values); err != nil {
+		return nil, fmt.Errorf("invalid page token: %w", err)
+	}
+
+	key := make(map[string]*dynamodb.AttributeValue, len(values))
+	for name, value := range values {
+		key[name] = &dynamodb.AttributeValue{
+			S: aws.String(value),
+		}
+	}
+	return key, nil
+}
+
+// scanPrefixInput builds a scan for all items whose key begins with prefix
+func (s *Storage) scanPrefixInput(prefix string) *dynamodb.ScanInput {
+	input := &dynamodb.ScanInput{
+		ExpressionAttributeNames: map[string]*string{
+			"#D": aws.String(s.PrimaryKeyAttribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":p": {
+				S: aws.String(s.keyPrefix + s.encodeKey(prefix)),
+			},
+		},
+		FilterExpression: aws.String("begins_with(#D, :p)"),
+		TableName:        aws.String(s.Table),
+		ConsistentRead:   aws.Bool(s.ListConsistentRead),
+	}
+	if s.KeyEncoding == "hex-sha256" {
+		// hashes don't keep prefixes, so match the key stored as is instead
+		input.ExpressionAttributeNames = map[string]*string{"#D": aws.String(keyAttribute)}
+		input.ExpressionAttributeValues[":p"] = &dynamodb.AttributeValue{S: aws.String(prefix)}
+		if s.keyPrefix != "" {
+			input.FilterExpression = aws.String("begins_with(#D, :p) AND begins_with(#T, :t)")
+			input.ExpressionAttributeNames["#T"] = aws.String(s.PrimaryKeyAttribute)
+			input.ExpressionAttributeValues[":t"] = &dynamodb.AttributeValue{S: aws.String(s.keyPrefix)}
+		}
+	}
+	if s.SortKeyAttribute != "" {
+		// skip other data sharing the table
+		input.FilterExpression = aws.String(*input.FilterExpression + " AND #S = :s")
+		input.ExpressionAttributeNames["#S"] = aws.String(s.SortKeyAttribute)
+		input.ExpressionAttributeValues[":s"] = &dynamodb.AttributeValue{
+			S: aws.String(s.SortKeyValue),
+		}
+	}
+	return input
+}
+
+// addListFilter adds ExtraListFilter, if set, to the filter of a listing scan
+func (s *Storage) addListFilter(input *dynamodb.ScanInput) {
+	if s.ExtraListFilter == nil {
+		return
+	}
+	input.FilterExpression = aws.String(*input.FilterExpression + " AND #F = :f")
+	input.ExpressionAttributeNames["#F"] = aws.String(s.ExtraListFilter.Attribute)
+	input.ExpressionAttributeValues[":f"] = &dynamodb.AttributeValue{
+		S: aws.String(s.ExtraListFilter.Value),
+	}
+}
+
+// Stat returns information about key. Only the item's last updated time
+// and content length are read, unless the item was stored before content
+// lengths were recorded, in which case the whole item is loaded.
+func (s *Storage) Stat(ctx context.Context, key string) (certmagic.KeyInfo, error) {
+	if err := s.initConfig(); err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+
+	if key == "" {
+		return certmagic.KeyInfo{}, ErrEmptyKey
+	}
+
+	if s.cache != nil {
+		if item, ok := s.cache.get(key); ok {
+			return s.keyInfo(key, item.LastUpdated, int64(len(item.Contents))), nil
+		}
+	}
+
+	svc := s.readClient()
+	input := &dynamodb.GetItemInput{
+		Key:                  s.itemKey(key),
+		ProjectionExpression: aws.String("#U, #L"),
+		ExpressionAttributeNames: map[string]*string{
+			"#U": aws.String(s.LastUpdatedAttribute),
+			"#L": aws.String(contentLengthAttribute),
+		},
+		TableName:      aws.String(s.Table),
+		ConsistentRead: aws.Bool(true),
+	}
+
+	result, err := svc.GetItemWithContext(ctx, input, s.readTimeout())
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+	if len(result.Item) == 0 {
+		return certmagic.KeyInfo{}, fs.ErrNotExist
+	}
+
+	length, ok := result.Item[contentLengthAttribute]
+	if !ok {
+		domainItem, err := s.loadItem(ctx, key)
+		if err != nil {
+			return certmagic.KeyInfo{}, err
+		}
+		return s.keyInfo(key, domainItem.LastUpdated, int64(len(domainItem.Contents))), nil
+	}
+
+	size, err := strconv.ParseInt(aws.StringValue(length.N), 10, 64)
+	if err != nil {
+		return certmagic.KeyInfo{}, fmt.Errorf("parsing %s of %s: %w", contentLengthAttribute, key, err)
+	}
+	if size == 0 {
+		// consistent with Load, which treats empty contents as missing
+		return certmagic.KeyInfo{}, fs.ErrNotExist
+	}
+
+	domainItem, err := s.itemFromAttributes(result.Item)
+	if err != nil {
+		return certmagic.KeyInfo{}, err
+	}
+	return s.keyInfo(key, domainItem.LastUpdated, size), nil
+}
+
+// CertExpiry loads the certificate stored at key and returns its NotAfter time,
+// e.g. to show upcoming expiries on a dashboard. The value may be PEM encoded,
+// in which case the first certificate is used, or DER encoded. It returns an
+// error if the value isn't a certificate, such as a private key.
+func (s *Storage) CertExpiry(ctx context.Context, key string) (time.Time, error) {
+	contents, err := s.Load(ctx, key)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	der := contents
+	for rest := contents; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			der = block.Bytes
+			break
+		}
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s is not a certificate: %w", key, err)
+	}
+	return cert.NotAfter, nil
+}
+
+// keyInfo returns the KeyInfo of key. A modified time in the future, written
+// by an instance whose clock is ahead, is clamped to now so that it doesn't
+// throw off certmagic's comparisons with the current time.
+func (s *Storage) keyInfo(key string, modified time.Time, size int64) certmagic.KeyInfo {
+	if now := time.Now(); modified.After(now) {
+		if skew := modified.Sub(now); skew > time.Duration(s.ClockSkewTolerance) {
+			s.logger.Warn("modified time is in the future, the clock of the instance that stored it may be ahead",
+				zap.String("key", key), zap.Time("modified", modified), zap.Duration("skew", skew))
+		}
+		modified = now
+	}
+
+	return certmagic.KeyInfo{
+		Key:        key,
+		Modified:   modified,
+		Size:       size,
+		IsTerminal: true,
+	}
+}
+
+// Usage returns the number of items in the table and their total size in bytes.
+// These figures come from DescribeTable, which DynamoDB only updates about every
+// six hours, so recent changes may not be reflected.
+func (s *Storage) Usage(ctx context.Context) (items int64, bytes int64, err error) {
+	if err := s.initConfig(); err != nil {
+		return 0, 0, err
+	}
+
+	svc := s.client()
+	input := &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.Table),
+	}
+
+	result, err := svc.DescribeTableWithContext(ctx, input)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return aws.Int64Value(result.Table.ItemCount), aws.Int64Value(result.Table.TableSizeBytes), nil
+}
+
+// Lock acquires the lock for key, blocking until the lock
+// can be obtained or an error is returned. Note that, even
+// after acquiring a lock, an idempotent operation may have
+// already been performed by another process that acquired
+// the lock before - so always check to make sure idempotent
+// operations still need to be performed after acquiring the
+// lock.
+//
+// The actual implementation of obtaining of a lock must be
+// an atomic operation so that multiple Lock calls at the
+// same time always results in only one caller receiving the
+// lock at any given time.
+//
+// To prevent deadlocks, all implementations (where this concern
+// is relevant) should put a reasonable expiration on the lock in
+// case Unlock is unable to be called due to some sort of network
+// failure or system crash.
+func (s *Storage) Lock(ctx context.Context, key string) error {
+	return s.LockWithID(ctx, key, "")
+}
+
+// LockWithID acquires the lock for key like Lock, but identifies it with id
+// instead of a random UUID, e.g. to correlate it with a request trace. The ID
+// is stored in the lock's LockIDAttribute and shown by ListLocks. It must be
+// unique to this call, as Unlock only deletes the lock if its ID still
+// matches, which is up to the caller. An empty id is the same as calling Lock.
+func (s *Storage) LockWithID(ctx context.Context, key, id string) error {
+	if err := s.initConfig(); err != nil {
+		return err
+	}
+
+	if s.ReadOnly {
+		return ErrReadOnly
+	}
+
+	lockKey := fmt.Sprintf("LOCK-%s", key)
+	start := time.Now()
+
+	for attempt := 0; ; attempt++ {
+		// Check for existing lock
+		existing, err := s.getItem(ctx, lockKey)
+		isErrNotExists := errors.Is(err, fs.ErrNotExist)
+		if err != nil && !isErrNotExists {
+			return contextError(ctx, err)
+		}
+
+		var previous *Item
+		stolen := false
+		switch {
+		case isErrNotExists:
+			// lock doesn't exist, create a new one
+		case s.InstanceID != "" && existing.Owner == s.InstanceID:
+			// lock was created by this instance before it restarted, so take it over,
+			// unless it's still held in this process, which reserveLock checks below
+			previous = &existing
+		default:
+			// Lock exists, take it over if expired or wait and check again
+			expires, err := time.Parse(time.RFC3339, existing.Contents)
+			if err != nil {
+				return err
+			}
+			if time.Now().After(expires) {
+				previous = &existing
+				stolen = true
+			}
+		}
+
+		lockID := id
+		if lockID == "" {
+			lockID = uuid.NewString()
+		}
+		if (isErrNotExists || previous != nil) && s.reserveLock(key, lockID) {
+			err := s.putLock(ctx, key, lockID, previous)
+			if err == nil {
+				s.observeLockAcquired(start, stolen)
+				return nil
+			}
+			s.releaseLock(key, lockID)
+			// any error other than another instance acquiring the lock first is returned
+			// rather than retried, as it's unlikely to go away by waiting for the lock
+			if !isConditionalCheckFailed(err) {
+				return contextError(ctx, err)
+			}
+		}
+
+		select {
+		case <-time.After(s.pollInterval(ctx, attempt)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// heldLocks maps the locks held in this process, by table and lock item key, to
+// their lock IDs. Storages sharing an InstanceID, e.g. the old and new ones of a
+// Caddy config reload, own the same lock items, so this is what keeps them from
+// taking over each other's locks as if they were left behind by a restart.
+var heldLocks sync.Map
+
+// heldLockKey returns the key of the lock on key in heldLocks
+func (s *Storage) heldLockKey(key string) string {
+	return s.Table + "/" + s.SortKeyValue + "/" + s.keyPrefix + s.encodeKey(fmt.Sprintf("LOCK-%s", key))
+}
+
+// reserveLock marks the lock on key as held in this process with lockID before
+// it's written, and returns false if it already is, by this or another Storage
+// with the same InstanceID. Locks without an InstanceID are never taken over
+// by their owner, so they don't need to be reserved.
+func (s *Storage) reserveLock(key, lockID string) bool {
+	if s.InstanceID == "" {
+		return true
+	}
+	_, loaded := heldLocks.LoadOrStore(s.heldLockKey(key), lockID)
+	return !loaded
+}
+
+// releaseLock removes the reservation of the lock on key with lockID, if any
+func (s *Storage) releaseLock(key string, lockID any) {
+	heldLocks.CompareAndDelete(s.heldLockKey(key), lockID)
+}
+
+// putLock creates the lock for key. To make acquiring the lock atomic, the
+// write only succeeds if the lock item is still the previous one found by
+// Lock, or if previous is nil, if there is still no lock item at all.
+func (s *Storage) putLock(ctx context.Context, key, lockID string, previous *Item) error {
+	svc := s.client()
+	input := &dynamodb.PutItemInput{
+		Item:      s.lockItem(key, lockID),
+		TableName: aws.String(s.Table),
+	}
+	if previous == nil {
+		input.ConditionExpression = aws.String("attribute_not_exists(#K)")
+		input.ExpressionAttributeNames = map[string]*string{
+			"#K": aws.String(s.PrimaryKeyAttribute),
+		}
+	} else {
+		input.ConditionExpression = aws.String("#C = :c")
+		input.ExpressionAttributeNames = map[string]*string{
+			"#C": aws.String(s.ContentsAttribute),
+		}
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":c": {
+				S: aws.String(previous.encoded),
+			},
+		}
+	}
+
+	_, err := svc.PutItemWithContext(ctx, input, s.writeTimeout())
+	if err != nil {
+		return err
+	}
+
+	s.locks.Store(key, lockID)
+	return nil
+}
+
+// lockItem builds the item of the lock on key with lockID, which expires
+// LockTimeout from now
+func (s *Storage) lockItem(key, lockID string) map[string]*dynamodb.AttributeValue {
+	expires := time.Now().Add(time.Duration(s.LockTimeout))
+	contents := []byte(expires.Format(time.RFC3339Nano))
+	item := s.newItem(fmt.Sprintf("LOCK-%s", key), contents)
+	item[s.LockIDAttribute] = &dynamodb.AttributeValue{
+		S: aws.String(lockID),
+	}
+	// lets the table's TTL clean up locks abandoned by crashed instances,
+	// rounded up so that TTL never considers a lock expired too early
+	item[s.ExpiresAtAttribute] = &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(expires.Add(time.Second-1).Unix(), 10)),
+	}
+	if s.owner != "" {
+		item[ownerAttribute] = &dynamodb.AttributeValue{
+			S: aws.String(s.owner),
+		}
+	}
+	return item
+}
+
+// RefreshLock extends the lock on key held by this instance to LockTimeout
+// from now, for work that may take longer than LockTimeout. It returns
+// ErrLockNotHeld if this instance didn't acquire the lock, and ErrLockLost if
+// the lock expired and was taken over or removed in the meantime.
+func (s *Storage) RefreshLock(ctx context.Context, key string) error {
+	if err := s.initConfig(); err != nil {
+		return err
+	}
+
+	if s.ReadOnly {
+		return ErrReadOnly
+	}
+
+	lockID, ok := s.locks.Load(key)
+	if !ok {
+		return &StorageError{
+			Code:      CodeLockNotHeld,
+			Operation: "RefreshLock",
+			Key:       key,
+			Message:   fmt.Sprintf("refreshing lock on %s: %s", key, ErrLockNotHeld),
+			Err:       ErrLockNotHeld,
+		}
+	}
+
+	svc := s.client()
+	input := &dynamodb.PutItemInput{
+		Item:                s.lockItem(key, lockID.(string)),
+		ConditionExpression: aws.String("#L = :l"),
+		ExpressionAttributeNames: map[string]*string{
+			"#L": aws.String(s.LockIDAttribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":l": {
+				S: aws.String(lockID.(string)),
+			},
+		},
+		TableName: aws.String(s.Table),
+	}
+
+	_, err := svc.PutItemWithContext(ctx, input, s.writeTimeout())
+	if err != nil {
+		s.observeLockRefreshFailed()
+	}
+	if isConditionalCheckFailed(err) {
+		// there's nothing left to refresh or release
+		s.locks.CompareAndDelete(key, lockID)
+		s.releaseLock(key, lockID)
+		return &StorageError{
+			Code:      CodeLockLost,
+			Operation: "RefreshLock",
+			Key:       key,
+			Message:   fmt.Sprintf("refreshing lock on %s: %s", key, ErrLockLost),
+			Err:       ErrLockLost,
+		}
+	}
+	return contextError(ctx, err)
+}
+
+// pollInterval returns how long Lock waits before checking a held lock again
+// after the given number of previous attempts, as decided by LockBackoff. By
+// default the wait is LockPollingInterval, or with LockPollingBackoff it
+// doubles on each attempt, up to LockPollingInterval. The wait never extends
+// past the deadline of ctx.
+func (s *Storage) pollInterval(ctx context.Context, attempt int) time.Duration {
+	backoff := s.LockBackoff
+	switch {
+	case backoff != nil:
+	case s.LockPollingBackoff:
+		backoff = ExponentialBackoff{Start: lockPollingBackoffStart, Max: time.Duration(s.LockPollingInterval)}
+	default:
+		backoff = FixedBackoff{Interval: time.Duration(s.LockPollingInterval)}
+	}
+	interval := backoff.NextInterval(attempt)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if remaining := time.Until(deadline); remaining < interval {
+			interval = remaining
+		}
+	}
+	return interval
+}
+
+// Unlock releases the lock for key. This method must ONLY be
+// called after a successful call to Lock, and only after the
+// critical section is finished, even if it errored or timed
+// out. Unlock cleans up any resources allocated during Lock.
+// A lock this instance has no record of, e.g. after a config
+// reload, is only removed if it has this instance's owner or
+// has expired.
+func (s *Storage) Unlock(ctx context.Context, key string) error {
+	if err := s.initConfig(); err != nil {
+		return err
+	}
+
+	if s.ReadOnly {
+		return ErrReadOnly
+	}
+
+	var err error
+	if lockID, ok := s.locks.LoadAndDelete(key); ok {
+		// the lock is forgotten locally even if it was lost, as there's nothing left to release
+		err = s.deleteLock(ctx, key, lockID.(string))
+		s.releaseLock(key, lockID)
+	} else {
+		// this instance doesn't know about the lock, e.g. because it was acquired before
+		// a config reload or restart, so remove it unless another instance holds it now
+		err = s.deleteOwnLock(ctx, key)
+	}
+	if isConditionalCheckFailed(err) && s.IgnoreStolenLockOnUnlock {
+		s.logger.Warn("lock was taken over by another instance before it was released",
+			zap.String("key", key))
+		return nil
+	}
+	if isConditionalCheckFailed(err) {
+		return &StorageError{
+			Code:      CodeLockLost,
+			Operation: "Unlock",
+			Key:       key,
+			Message:   fmt.Sprintf("unlocking %s: %s", key, ErrLockLost),
+			Err:       ErrLockLost,
+		}
+	}
+	return err
+}
+
+// ForceUnlock removes the lock for key no matter which instance holds it.
+// It is meant for clearing a stuck lock by hand, e.g. one left behind by a
+// crashed instance, and should not be used in place of Unlock.
+func (s *Storage) ForceUnlock(ctx context.Context, key string) error {
+	if err := s.initConfig(); err != nil {
+		return err
+	}
+
+	if s.ReadOnly {
+		return ErrReadOnly
+	}
+
+	if key == "" {
+		return ErrEmptyKey
+	}
+
+	s.logger.Warn("force unlocking, ignoring which instance holds the lock", zap.String("key", key))
+	if lockID, ok := s.locks.LoadAndDelete(key); ok {
+		s.releaseLock(key, lockID)
+	}
+	return s.deleteItem(ctx, fmt.Sprintf("LOCK-%s", key))
+}
+
+// Cleanup releases the locks this instance still holds, so that they don't
+// linger until they expire when Caddy unloads the module, e.g. on a config
+// reload. A lock is only deleted if it is still the one this instance created,
+// in case it has already expired and been acquired by another instance.
+// The DAX client created for DaxEndpoint, if any, is closed as well.
+func (s *Storage) Cleanup() error {
+	ctx := context.Background()
+	var errs []error
+	if s.locks != nil {
+		s.locks.Range(func(key, lockID any) bool {
+			err := s.deleteLock(ctx, key.(string), lockID.(string))
+			if err != nil && !isConditionalCheckFailed(err) {
+				errs = append(errs, err)
+			}
+			s.locks.Delete(key)
+			s.releaseLock(key.(string), lockID)
+			return true
+		})
+	}
+
+	if s.dax != nil {
+		// it keeps connections to the cluster open
+		errs = append(errs, s.dax.Close())
+		s.dax, s.ReadAPI = nil, nil
+	}
+
+	return errors.Join(errs...)
+}
+
+// LockInfo describes a lock held in the table
+type LockInfo struct {
+	// Key is the key that is locked
+	Key string
+
+	// LockID identifies the Lock call that acquired the lock
+	LockID string
+
+	// Owner is the InstanceID of the instance holding the lock, or its hostname
+	// if it has none
+	Owner string
+
+	// ExpiresAt is when the lock expires if it isn't released before
+	ExpiresAt time.Time
+}
+
+// ListLocks returns the locks currently held in the table by any instance,
+// leaving out those that have already expired. It scans the whole table, so
+// it is meant for troubleshooting rather than regular use.
+func (s *Storage) ListLocks(ctx context.Context) ([]LockInfo, error) {
+	if err := s.initConfig(); err != nil {
+		return nil, err
+	}
+
+	input := s.scanPrefixInput("LOCK-")
+
+	var locks []LockInfo
+	var parseErr error
+	now := time.Now()
+	err := s.scanPages(ctx, input,
+		func(page *dynamodb.ScanOutput) bool {
+			for _, i := range page.Items {
+				lock, err := s.lockInfo(i)
+				if err != nil {
+					parseErr = err
+					return false
+				}
+				if lock.ExpiresAt.After(now) {
+					locks = append(locks, lock)
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+	if parseErr != nil {
+		return nil, parseErr
+	}
+
+	return locks, nil
+}
+
+// lockInfo reads a LockInfo from the attributes of a lock item
+func (s *Storage) lockInfo(attributes map[string]*dynamodb.AttributeValue) (LockInfo, error) {
+	item, err := s.itemFromAttributes(attributes)
+	if err != nil {
+		return LockInfo{}, err
+	}
+
+	contents, err := decodeContents(attributes, item.Contents)
+	if err != nil {
+		return LockInfo{}, fmt.Errorf("decoding lock %s: %w", item.PrimaryKey, err)
+	}
+	expires, err := time.Parse(time.RFC3339, string(contents))
+	if err != nil {
+		return LockInfo{}, fmt.Errorf("parsing expiry of lock %s: %w", item.PrimaryKey, err)
+	}
+
+	return LockInfo{
+		Key:       strings.TrimPrefix(item.PrimaryKey, "LOCK-"),
+		LockID:    stringAttribute(attributes, s.LockIDAttribute),
+		Owner:     item.Owner,
+		ExpiresAt: expires,
 	}, nil
 }
 
-// Lock acquires the lock for key, blocking until the lock
-// can be obtained or an error is returned. Note that, even
-// after acquiring a lock, an idempotent operation may have
-// already been performed by another process that acquired
-// the lock before - so always check to make sure idempotent
-// operations still need to be performed after acquiring the
-// lock.
-//
-// The actual implementation of obtaining of a lock must be
-// an atomic operation so that multiple Lock calls at the
-// same time always results in only one caller receiving the
-// lock at any given time.
-//
-// To prevent deadlocks, all implementations (where this concern
-// is relevant) should put a reasonable expiration on the lock in
-// case Unlock is unable to be called due to some sort of network
-// failure or system crash.
-func (s *Storage) Lock(ctx context.Context, key string) error {
-	if err := s.initConfig(); err != nil {
+// deleteItem deletes the item stored at key
+func (s *Storage) deleteItem(ctx context.Context, key string) error {
+	svc := s.client()
+	input := &dynamodb.DeleteItemInput{
+		Key:       s.itemKey(key),
+		TableName: aws.String(s.Table),
+	}
+
+	_, err := svc.DeleteItemWithContext(ctx, input, s.writeTimeout())
+	s.evict(key)
+	return err
+}
+
+// deleteValue deletes the item at key, along with its parts if the value
+// was stored in parts, whether or not EnableChunking is still set
+func (s *Storage) deleteValue(ctx context.Context, key string) error {
+	svc := s.client()
+	input := &dynamodb.DeleteItemInput{
+		Key:          s.itemKey(key),
+		ReturnValues: aws.String(dynamodb.ReturnValueAllOld),
+		TableName:    aws.String(s.Table),
+	}
+
+	result, err := svc.DeleteItemWithContext(ctx, input, s.writeTimeout())
+	s.evict(key)
+	if err != nil {
 		return err
 	}
+	return s.deleteParts(ctx, key, partsOf(result.Attributes))
+}
 
-	lockKey := fmt.Sprintf("LOCK-%s", key)
+// writeItem makes the put of input, which replaces the item at key with one
+// whose value is stored in parts, if any, and then deletes the parts of the
+// previous value at key
+func (s *Storage) writeItem(ctx context.Context, key string, input *dynamodb.PutItemInput, parts valueParts) error {
+	if s.EnableChunking {
+		// to find out whether the previous value was stored in parts
+		input.ReturnValues = aws.String(dynamodb.ReturnValueAllOld)
+	}
 
-	// Check for existing lock
-	for {
-		existing, err := s.getItem(lockKey)
-		isErrNotExists := errors.Is(err, fs.ErrNotExist)
-		if err != nil && !isErrNotExists {
-			return err
+	result, err := s.client().PutItemWithContext(ctx, input, s.writeTimeout())
+	s.evict(key)
+	if err != nil {
+		return err
+	}
+	if previous := partsOf(result.Attributes); previous != parts {
+		return s.deleteOldParts(ctx, key, previous)
+	}
+	return nil
+}
+
+// deleteOldParts deletes the parts of a previous value at key, once the
+// item at key no longer points to them
+func (s *Storage) deleteOldParts(ctx context.Context, key string, previous valueParts) error {
+	if err := s.deleteParts(ctx, key, previous); err != nil {
+		return fmt.Errorf("deleting old parts of %s: %w", key, err)
+	}
+	return nil
+}
+
+// valueParts identifies the parts a value is stored in
+type valueParts struct {
+	// ID is new for every write of a value in parts, and empty for
+	// values stored before parts had IDs
+	ID string
+
+	// Count is how many parts the value is stored in, or 0 if it isn't
+	Count int
+}
+
+// storedParts returns the parts the value at key is stored in, which
+// are none if it isn't stored in parts or doesn't exist
+func (s *Storage) storedParts(ctx context.Context, key string) (valueParts, error) {
+	result, err := s.client().GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key:                  s.itemKey(key),
+		ProjectionExpression: aws.String("#P, #I"),
+		ExpressionAttributeNames: map[string]*string{
+			"#P": aws.String(partsAttribute),
+			"#I": aws.String(partsIDAttribute),
+		},
+		TableName:      aws.String(s.Table),
+		ConsistentRead: aws.Bool(true),
+	}, s.readTimeout())
+	if err != nil {
+		return valueParts{}, err
+	}
+	return partsOf(result.Item), nil
+}
+
+// partsOf returns the parts the value of an item was stored in,
+// which are none if it wasn't stored in parts
+func partsOf(attributes map[string]*dynamodb.AttributeValue) valueParts {
+	parts, ok := attributes[partsAttribute]
+	if !ok {
+		return valueParts{}
+	}
+	count, err := strconv.Atoi(aws.StringValue(parts.N))
+	if err != nil {
+		return valueParts{}
+	}
+	id := ""
+	if value, ok := attributes[partsIDAttribute]; ok {
+		id = aws.StringValue(value.S)
+	}
+	return valueParts{ID: id, Count: count}
+}
+
+// partKey returns the key of part n of the value at key with the given parts ID
+func partKey(key, id string, n int) string {
+	if id == "" {
+		return fmt.Sprintf("%s#part%d", key, n)
+	}
+	return fmt.Sprintf("%s#%s#part%d", key, id, n)
+}
+
+// isPartKey returns true if key is the key of a part of a value, rather than of a value
+func isPartKey(key string) bool {
+	i := strings.LastIndex(key, "#part")
+	if i < 0 || i+len("#part") == len(key) {
+		return false
+	}
+	_, err := strconv.Atoi(key[i+len("#part"):])
+	return err == nil
+}
+
+// storeParts puts value at the keys of parts, ChunkSize bytes each.
+// If a part can't be stored, the parts stored so far are deleted again.
+func (s *Storage) storeParts(ctx context.Context, key string, parts valueParts, value []byte, expiresAt time.Time) error {
+	svc := s.client()
+	for n := 0; n < parts.Count; n++ {
+		end := min((n+1)*s.ChunkSize, len(value))
+		item := s.newItem(partKey(key, parts.ID, n), value[n*s.ChunkSize:end])
+		item[partOfAttribute] = &dynamodb.AttributeValue{
+			S: aws.String(key),
+		}
+		// the hash of the whole value, on the item at key, covers the parts,
+		// and keeps them out of the content hash index
+		delete(item, contentHashAttribute)
+		if !expiresAt.IsZero() {
+			item[s.ExpiresAtAttribute] = &dynamodb.AttributeValue{
+				N: aws.String(strconv.FormatInt(expiresAt.Unix(), 10)),
+			}
 		}
 
-		// if lock doesn't exist or is empty, break to create a new one
-		if isErrNotExists || existing.Contents == "" {
-			break
+		_, err := svc.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			Item:      item,
+			TableName: aws.String(s.Table),
+		}, s.writeTimeout())
+		if err != nil {
+			if cleanupErr := s.deleteParts(ctx, key, valueParts{ID: parts.ID, Count: n}); cleanupErr != nil {
+				s.logger.Warn("failed to delete parts of a value that couldn't be stored",
+					zap.String("key", key), zap.Error(cleanupErr))
+			}
+			return fmt.Errorf("storing part %d of %s: %w", n, key, err)
 		}
+	}
+	return nil
+}
 
-		// Lock exists, check if expired or sleep 5 seconds and check again
-		expires, err := time.Parse(time.RFC3339, existing.Contents)
+// deleteParts deletes the parts of the value at key
+func (s *Storage) deleteParts(ctx context.Context, key string, parts valueParts) error {
+	svc := s.client()
+	for n := 0; n < parts.Count; n++ {
+		_, err := svc.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+			Key:       s.itemKey(partKey(key, parts.ID, n)),
+			TableName: aws.String(s.Table),
+		}, s.writeTimeout())
 		if err != nil {
 			return err
 		}
-		if time.Now().After(expires) {
-			if err := s.Unlock(ctx, key); err != nil {
-				return err
-			}
-			break
+	}
+	return nil
+}
+
+// loadParts reads the value at key from its parts
+func (s *Storage) loadParts(ctx context.Context, key string, parts valueParts) ([]byte, error) {
+	var value []byte
+	for n := 0; n < parts.Count; n++ {
+		part, err := s.getItem(ctx, partKey(key, parts.ID, n))
+		if err != nil {
+			return nil, fmt.Errorf("loading part %d of %s: %w", n, key, err)
 		}
+		value = append(value, part.Contents...)
+	}
+	return value, nil
+}
 
-		select {
-		case <-time.After(time.Duration(s.LockPollingInterval)):
-		case <-ctx.Done():
-			return ctx.Err()
+// deleteLock deletes the lock row for key if it still has the given lock ID,
+// failing with a ConditionalCheckFailedException otherwise
+func (s *Storage) deleteLock(ctx context.Context, key, lockID string) error {
+	svc := s.client()
+	input := &dynamodb.DeleteItemInput{
+		ConditionExpression: aws.String("#L = :l"),
+		ExpressionAttributeNames: map[string]*string{
+			"#L": aws.String(s.LockIDAttribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":l": {
+				S: aws.String(lockID),
+			},
+		},
+		Key:       s.itemKey(fmt.Sprintf("LOCK-%s", key)),
+		TableName: aws.String(s.Table),
+	}
+
+	_, err := svc.DeleteItemWithContext(ctx, input, s.writeTimeout())
+	return err
+}
+
+// deleteOwnLock deletes the lock row for key if it has this instance's owner
+// or has expired, failing with a ConditionalCheckFailedException if another
+// instance holds it
+func (s *Storage) deleteOwnLock(ctx context.Context, key string) error {
+	svc := s.client()
+	input := &dynamodb.DeleteItemInput{
+		ConditionExpression: aws.String("attribute_not_exists(#K) OR #O = :o OR #E < :n"),
+		ExpressionAttributeNames: map[string]*string{
+			"#K": aws.String(s.PrimaryKeyAttribute),
+			"#O": aws.String(ownerAttribute),
+			"#E": aws.String(s.ExpiresAtAttribute),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":o": {
+				S: aws.String(s.owner),
+			},
+			":n": {
+				N: aws.String(strconv.FormatInt(time.Now().Unix(), 10)),
+			},
+		},
+		Key:       s.itemKey(fmt.Sprintf("LOCK-%s", key)),
+		TableName: aws.String(s.Table),
+	}
+	if s.owner == "" {
+		// locks created without an owner don't record one
+		input.ConditionExpression = aws.String("attribute_not_exists(#K) OR attribute_not_exists(#O) OR #E < :n")
+		delete(input.ExpressionAttributeValues, ":o")
+	}
+
+	_, err := svc.DeleteItemWithContext(ctx, input, s.writeTimeout())
+	return err
+}
+
+// loadItem returns the item at key, using the read cache when it is enabled,
+// and reading from the fallback table if the table can't be reached.
+// Lock rows are read with getItem directly so they are never cached.
+func (s *Storage) loadItem(ctx context.Context, key string) (Item, error) {
+	if s.cache != nil {
+		if item, ok := s.cache.get(key); ok {
+			return item, nil
+		}
+	}
+
+	item, err := s.getItem(ctx, key)
+	if err != nil && s.fallback != nil && isUnavailable(err) {
+		item, err = s.fallback.getItem(ctx, key)
+	}
+	if err != nil {
+		return Item{}, err
+	}
+
+	if s.cache != nil {
+		s.cache.put(key, item)
+	}
+	return item, nil
+}
+
+// InvalidateCache drops key from the read cache, so that the next Load reads
+// it from DynamoDB again, e.g. after the item was changed by hand.
+func (s *Storage) InvalidateCache(key string) {
+	s.evict(key)
+}
+
+// FlushCache drops every item from the read cache.
+func (s *Storage) FlushCache() {
+	if s.cache != nil {
+		s.cache.flush()
+	}
+}
+
+// auditWrite logs a write that succeeded when WriteAuditLog is set. Values
+// are never logged, only their size.
+func (s *Storage) auditWrite(operation, key string, fields ...zap.Field) {
+	if !s.WriteAuditLog {
+		return
+	}
+	s.logger.Info("write succeeded", append([]zap.Field{
+		zap.String("operation", operation), zap.String("key", key), zap.String("table", s.Table),
+	}, fields...)...)
+}
+
+// evict removes key from the read cache, if enabled.
+func (s *Storage) evict(key string) {
+	if s.cache != nil {
+		s.cache.remove(key)
+	}
+}
+
+// newItem builds the attributes stored for value at key
+func (s *Storage) newItem(key string, value []byte) map[string]*dynamodb.AttributeValue {
+	item := s.keyAttributes(key)
+	contents := value
+	if s.Compression != "" && len(value) >= minCompressedSize && len(value) <= maxCompressedSize {
+		compressed, err := compress(s.Compression, value)
+		if err != nil {
+			s.logger.Warn("storing value uncompressed, as it couldn't be compressed",
+				zap.String("key", key), zap.String("compression", s.Compression), zap.Error(err))
+		} else {
+			contents = compressed
+			item[compressionAttribute] = &dynamodb.AttributeValue{S: aws.String(s.Compression)}
 		}
 	}
+	item[s.ContentsAttribute] = &dynamodb.AttributeValue{
+		S: aws.String(base64Encodings[s.Base64Variant].EncodeToString(contents)),
+	}
+	if s.Base64Variant != "std" {
+		item[encodingAttribute] = &dynamodb.AttributeValue{S: aws.String(s.Base64Variant)}
+	}
+	now := time.Now()
+	item[s.LastUpdatedAttribute] = &dynamodb.AttributeValue{
+		S: aws.String(now.Format(time.RFC3339)),
+	}
+	// a number, unlike LastUpdated, can be compared in filter expressions
+	item[lastUpdatedEpochAttribute] = &dynamodb.AttributeValue{
+		N: aws.String(strconv.FormatInt(now.Unix(), 10)),
+	}
+	item[contentHashAttribute] = &dynamodb.AttributeValue{
+		S: aws.String(s.ContentHash(value)),
+	}
+	item[contentLengthAttribute] = &dynamodb.AttributeValue{
+		N: aws.String(strconv.Itoa(len(value))),
+	}
+	return item
+}
 
-	// lock doesn't exist, create it
-	contents := []byte(time.Now().Add(time.Duration(s.LockTimeout)).Format(time.RFC3339))
-	return s.Store(ctx, lockKey, contents)
+// itemKey returns the DynamoDB key of the item stored at key, including
+// the tenant's partition key prefix and sort key if they are configured
+func (s *Storage) itemKey(key string) map[string]*dynamodb.AttributeValue {
+	itemKey := map[string]*dynamodb.AttributeValue{
+		s.PrimaryKeyAttribute: {
+			S: aws.String(s.keyPrefix + s.encodeKey(key)),
+		},
+	}
+	if s.SortKeyAttribute != "" {
+		itemKey[s.SortKeyAttribute] = &dynamodb.AttributeValue{
+			S: aws.String(s.SortKeyValue),
+		}
+	}
+	return itemKey
 }
 
-// Unlock releases the lock for key. This method must ONLY be
-// called after a successful call to Lock, and only after the
-// critical section is finished, even if it errored or timed
-// out. Unlock cleans up any resources allocated during Lock.
-func (s *Storage) Unlock(ctx context.Context, key string) error {
-	if err := s.initConfig(); err != nil {
-		return err
+// keyAttributes returns the attributes identifying the item stored at key: its
+// DynamoDB key, and the key itself if it's encoded in the partition key
+func (s *Storage) keyAttributes(key string) map[string]*dynamodb.AttributeValue {
+	attributes := s.itemKey(key)
+	if s.KeyEncoding != "" && s.KeyEncoding != "raw" {
+		attributes[keyAttribute] = &dynamodb.AttributeValue{
+			S: aws.String(key),
+		}
 	}
+	return attributes
+}
 
-	lockKey := fmt.Sprintf("LOCK-%s", key)
+// encodeKey returns key encoded with KeyEncoding for the partition key.
+// Percent-encoding keeps prefixes, so a prefix can be encoded like a key.
+func (s *Storage) encodeKey(key string) string {
+	switch s.KeyEncoding {
+	case "urlencode":
+		return url.QueryEscape(key)
+	case "hex-sha256":
+		hash := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(hash[:])
+	default:
+		return key
+	}
+}
+
+// checkItemSize returns an error if item is too large for DynamoDB to store,
+// and logs a warning if it is larger than SizeWarnThreshold.
+// The size of an item is the total length of its attribute names and values.
+func (s *Storage) checkItemSize(key string, item map[string]*dynamodb.AttributeValue) error {
+	size := 0
+	for name, value := range item {
+		size += len(name) + len(aws.StringValue(value.S)) + len(aws.StringValue(value.N))
+		for name, value := range value.M {
+			size += len(name) + len(aws.StringValue(value.S))
+		}
+	}
 
-	return s.Delete(ctx, lockKey)
+	if size > maxItemSize {
+		return fmt.Errorf("value for key %q is %d bytes once encoded, exceeding DynamoDB's 400KB item limit", key, size)
+	}
+	if size > s.SizeWarnThreshold {
+		s.logger.Warn("item is approaching DynamoDB's 400KB item limit",
+			zap.String("key", key), zap.Int("bytes", size), zap.String("table", s.Table))
+	}
+	return nil
 }
 
-func (s *Storage) getItem(key string) (Item, error) {
-	svc := dynamodb.New(s.AwsSession)
+func (s *Storage) getItem(ctx context.Context, key string) (Item, error) {
+	svc := s.readClient()
 	input := &dynamodb.GetItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			primaryKeyAttribute: {
-				S: aws.String(key),
-			},
-		},
+		Key:            s.itemKey(key),
 		TableName:      aws.String(s.Table),
 		ConsistentRead: aws.Bool(true),
 	}
 
-	result, err := svc.GetItem(input)
+	result, err := svc.GetItemWithContext(ctx, input, s.readTimeout())
+	if err != nil && s.FallbackToEventualOnThrottle && isThrottled(err) {
+		// the SDK has given up retrying the consistent read, so settle for one that may be stale
+		s.logger.Debug("consistent read was throttled, retrying with an eventually consistent read",
+			zap.String("key", key), zap.String("table", s.Table))
+		input.ConsistentRead = aws.Bool(false)
+		result, err = svc.GetItemWithContext(ctx, input, s.readTimeout())
+	}
 	if err != nil {
 		return Item{}, err
 	}
 
-	var domainItem Item
-	err = dynamodbattribute.UnmarshalMap(result.Item, &domainItem)
+	domainItem, err := s.itemFromAttributes(result.Item)
 	if err != nil {
 		return Item{}, err
 	}
-	if domainItem.Contents == "" {
-		return Item{}, fs.ErrNotExist
-	}
 
-	dec, err := base64.StdEncoding.DecodeString(domainItem.Contents)
-	if err != nil {
-		return Item{}, err
+	var dec []byte
+	if parts, ok := result.Item[partsAttribute]; ok {
+		if _, err := strconv.Atoi(aws.StringValue(parts.N)); err != nil {
+			return Item{}, fmt.Errorf("parsing %s of %s: %w", partsAttribute, key, err)
+		}
+		dec, err = s.loadParts(ctx, key, partsOf(result.Item))
+		if err != nil {
+			return Item{}, err
+		}
+	} else {
+		if domainItem.Contents == "" {
+			return Item{}, fs.ErrNotExist
+		}
+		dec, err = decodeContents(result.Item, domainItem.Contents)
+		if err != nil {
+			return Item{}, err
+		}
 	}
+	domainItem.encoded = domainItem.Contents
 	domainItem.Contents = string(dec)
 
+	// items stored before content lengths were recorded can't be checked
+	if length, ok := result.Item[contentLengthAttribute]; ok {
+		if expected := aws.StringValue(length.N); expected != strconv.Itoa(len(dec)) {
+			return Item{}, fmt.Errorf("contents of %s are %d bytes, but %s is %s", key, len(dec),
+				contentLengthAttribute, expected)
+		}
+	}
+
+	if s.VerifyChecksums {
+		// items stored before content hashes were recorded can't be verified
+		if hash, ok := result.Item[contentHashAttribute]; ok {
+			if aws.StringValue(hash.S) != s.ContentHash(dec) {
+				return Item{}, &StorageError{
+					Code:      CodeChecksumMismatch,
+					Operation: "Load",
+					Key:       key,
+					Message:   fmt.Sprintf("loading %s: %s", key, ErrChecksumMismatch),
+					Err:       ErrChecksumMismatch,
+				}
+			}
+		}
+	}
+
 	return domainItem, nil
 }
 
+// itemFromAttributes reads an Item from the attributes of a DynamoDB item,
+// using the configured attribute names. Contents are left base64 encoded.
+func (s *Storage) itemFromAttributes(attributes map[string]*dynamodb.AttributeValue) (Item, error) {
+	item := Item{
+		PrimaryKey: s.storageKey(attributes),
+		Contents:   stringAttribute(attributes, s.ContentsAttribute),
+		Owner:      stringAttribute(attributes, ownerAttribute),
+	}
+
+	if meta, ok := attributes[metaAttribute]; ok && meta != nil && len(meta.M) > 0 {
+		item.Meta = make(map[string]string, len(meta.M))
+		for name, value := range meta.M {
+			item.Meta[name] = aws.StringValue(value.S)
+		}
+	}
+
+	if lastUpdated := stringAttribute(attributes, s.LastUpdatedAttribute); lastUpdated != "" {
+		var err error
+		item.LastUpdated, err = time.Parse(time.RFC3339, lastUpdated)
+		if err != nil {
+			return Item{}, fmt.Errorf("parsing %s of %s: %w", s.LastUpdatedAttribute, item.PrimaryKey, err)
+		}
+	}
+
+	return item, nil
+}
+
+// decodeContents decodes the base64 encoded contents of an item, using the
+// variant recorded on the item, or std for items that don't record one, and
+// decompresses them if the item records the codec they were compressed with.
+// Contents of items that don't record a codec are decompressed if they start
+// with gzip or zstd magic bytes, e.g. rows compressed by other tools before
+// the Compression attribute existed, unless the item's ContentLength shows
+// they were stored as they are.
+func decodeContents(attributes map[string]*dynamodb.AttributeValue, contents string) ([]byte, error) {
+	variant := stringAttribute(attributes, encodingAttribute)
+	if variant == "" {
+		variant = "std"
+	}
+	encoding, ok := base64Encodings[variant]
+	if !ok {
+		return nil, fmt.Errorf("unsupported base64 variant %q", variant)
+	}
+	value, err := encoding.DecodeString(contents)
+	if err != nil {
+		return nil, err
+	}
+
+	if codec := stringAttribute(attributes, compressionAttribute); codec != "" {
+		return decompress(codec, value)
+	}
+	if length, ok := attributes[contentLengthAttribute]; ok && aws.StringValue(length.N) == strconv.Itoa(len(value)) {
+		return value, nil
+	}
+	if codec := sniffCompression(value); codec != "" {
+		if decompressed, err := decompress(codec, value); err == nil {
+			return decompressed, nil
+		}
+	}
+	return value, nil
+}
+
+// storageKey returns the key an item is stored at, the reverse of itemKey. An
+// encoded key is read from the Key attribute, or decoded if the item doesn't
+// have it, e.g. when read from an index that only has the DynamoDB key.
+func (s *Storage) storageKey(attributes map[string]*dynamodb.AttributeValue) string {
+	switch s.KeyEncoding {
+	case "urlencode":
+		if key := stringAttribute(attributes, keyAttribute); key != "" {
+			return key
+		}
+		key, err := url.QueryUnescape(strings.TrimPrefix(stringAttribute(attributes, s.PrimaryKeyAttribute), s.keyPrefix))
+		if err != nil {
+			return ""
+		}
+		return key
+	case "hex-sha256":
+		return stringAttribute(attributes, keyAttribute)
+	default:
+		return strings.TrimPrefix(stringAttribute(attributes, s.PrimaryKeyAttribute), s.keyPrefix)
+	}
+}
+
+// listedKey returns the key of a scanned item, and false if the item must be
+// left out of listings: parts of values stored in parts, and items without a
+// key, which a table whose attribute names don't match the configured ones has
+func (s *Storage) listedKey(attributes map[string]*dynamodb.AttributeValue) (string, bool) {
+	if _, ok := attributes[partOfAttribute]; ok {
+		return "", false
+	}
+	key := s.storageKey(attributes)
+	if key == "" {
+		s.logger.Debug("skipping item without a key, the table's attribute names may not match the configured ones",
+			zap.String("attribute", s.PrimaryKeyAttribute), zap.String("table", s.Table))
+		return "", false
+	}
+	return key, true
+}
+
+// stringAttribute returns the string value of the named attribute,
+// or "" if it is missing or not a string
+func stringAttribute(attributes map[string]*dynamodb.AttributeValue, name string) string {
+	if value, ok := attributes[name]; ok && value != nil {
+		return aws.StringValue(value.S)
+	}
+	return ""
+}
+
+// isConditionalCheckFailed returns true if err is DynamoDB rejecting
+// a write because its condition expression was not met
+func isConditionalCheckFailed(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// isThrottled returns true if err is DynamoDB rejecting a request because it
+// exceeded the table's provisioned throughput or the account's request limit
+func isThrottled(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && request.IsErrorThrottle(aerr)
+}
+
+// wrapThrottled is an AfterRetry handler that wraps the error of a request that
+// is still throttled once the SDK won't retry it anymore in ErrThrottled, keeping
+// the AWS error so that its code can still be checked. The error of a request
+// that will be retried has been cleared by then.
+func wrapThrottled(r *request.Request) {
+	if isThrottled(r.Error) && !errors.Is(r.Error, ErrThrottled) {
+		r.Error = fmt.Errorf("%w: %w", ErrThrottled, r.Error)
+	}
+}
+
+// retryer returns the SDK's retryer, bounded to MaxAttempts, with the base delay
+// the SDK uses for DynamoDB. It only retries errors that are likely to go away:
+// throttling, server errors, and network errors.
+func (s *Storage) retryer() request.Retryer {
+	return client.DefaultRetryer{
+		NumMaxRetries: s.MaxAttempts - 1,
+		MinRetryDelay: 50 * time.Millisecond,
+	}
+}
+
+// isResourceNotFound returns true if err is DynamoDB reporting
+// that the table does not exist
+func isResourceNotFound(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeResourceNotFoundException
+}
+
+// contextError returns the error of ctx if it is done, so that callers
+// can tell that a request failed because ctx was canceled or timed out,
+// and err otherwise
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// isUnavailable returns true if err means the table can't be reached,
+// because it doesn't exist, DynamoDB failed to handle the request, or
+// the circuit breaker is open
+func isUnavailable(err error) bool {
+	return isResourceNotFound(err) || errors.Is(err, ErrCircuitOpen) || isFailure(err)
+}
+
+// isFailure returns true if err means DynamoDB failed to handle the request,
+// rather than rejecting it, e.g. because of a failed condition
+func isFailure(err error) bool {
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		return reqErr.StatusCode() >= http.StatusInternalServerError
+	}
+
+	// no response at all, e.g. a connection error
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == request.ErrCodeRequestError
+}
+
+// isCanceled returns true if the request was canceled by its context
+func isCanceled(err error) bool {
+	var aerr awserr.Error
+	return errors.As(err, &aerr) && aerr.Code() == request.CanceledErrorCode
+}
+
 // Interface guard
 var _ certmagic.Storage = (*Storage)(nil)
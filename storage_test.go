@@ -1,19 +1,48 @@
 package dynamodbstorage
 
 import (
+	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
 	"errors"
+	"fmt"
+	"io"
 	"io/fs"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/ssocreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 const TestTableName = "CertMagicTest"
@@ -79,7 +108,38 @@ func initDb() error {
 	return err
 }
 
+// newMockSession returns an AWS session whose requests never leave the process.
+// Instead, handler is called for each request and may fill in r.Data with a
+// response or set r.Error.
+func newMockSession(handler func(r *request.Request)) *session.Session {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("abc123", "abc123", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+	sess.Handlers.Send.Clear()
+	sess.Handlers.Send.PushBack(func(r *request.Request) {
+		r.HTTPResponse = &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("{}")),
+		}
+		handler(r)
+	})
+	return sess
+}
+
+// mockItem builds a GetItem response item as Store would have written it
+func mockItem(key, contents string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		primaryKeyAttribute:  {S: aws.String(key)},
+		contentsAttribute:    {S: aws.String(base64.StdEncoding.EncodeToString([]byte(contents)))},
+		lastUpdatedAttribute: {S: aws.String(time.Now().Format(time.RFC3339))},
+	}
+}
+
 func TestDynamoDBStorage_initConfg(t *testing.T) {
+	clearEndpointEnv(t)
 	defaultAwsSession, err := session.NewSession(&aws.Config{
 		Endpoint:   aws.String(""),
 		Region:     aws.String(""),
@@ -91,13 +151,16 @@ func TestDynamoDBStorage_initConfg(t *testing.T) {
 	}
 
 	type fields struct {
-		Table         string
-		KeyPrefix     string
-		ColumnName    string
-		AwsSession    *session.Session
-		AwsEndpoint   string
-		AwsRegion     string
-		AwsDisableSSL bool
+		Table               string
+		KeyPrefix           string
+		ColumnName          string
+		AwsSession          *session.Session
+		AwsEndpoint         string
+		AwsRegion           string
+		AwsDisableSSL       bool
+		LockTimeout         caddy.Duration
+		LockPollingInterval caddy.Duration
+		SortKeyAttribute    string
 	}
 	tests := []struct {
 		name     string
@@ -117,29 +180,100 @@ func TestDynamoDBStorage_initConfg(t *testing.T) {
 				Table: "Testing123",
 			},
 			wantErr: false,
+			expected: &Storage{
+				Table:                "Testing123",
+				AwsSession:           defaultAwsSession,
+				LockTimeout:          lockTimeoutMinutes,
+				LockPollingInterval:  lockPollingInterval,
+				PrimaryKeyAttribute:  primaryKeyAttribute,
+				ContentsAttribute:    contentsAttribute,
+				LastUpdatedAttribute: lastUpdatedAttribute,
+				LockIDAttribute:      lockIDAttribute,
+				ExpiresAtAttribute:   expiresAtAttribute,
+				Base64Variant:        "std",
+				KeyEncoding:          "raw",
+				SizeWarnThreshold:    sizeWarnThreshold,
+				ReadTimeout:          requestTimeout,
+				WriteTimeout:         requestTimeout,
+			},
+		},
+		{
+			name: "polling interval longer than lock timeout should error",
+			fields: fields{
+				Table:               "Testing123",
+				LockTimeout:         caddy.Duration(time.Second),
+				LockPollingInterval: caddy.Duration(2 * time.Second),
+			},
+			wantErr: true,
+			expected: &Storage{
+				Table:               "Testing123",
+				LockTimeout:         caddy.Duration(time.Second),
+				LockPollingInterval: caddy.Duration(2 * time.Second),
+			},
+		},
+		{
+			name: "polling interval equal to lock timeout should error",
+			fields: fields{
+				Table:               "Testing123",
+				LockTimeout:         caddy.Duration(time.Second),
+				LockPollingInterval: caddy.Duration(time.Second),
+			},
+			wantErr: true,
+			expected: &Storage{
+				Table:               "Testing123",
+				LockTimeout:         caddy.Duration(time.Second),
+				LockPollingInterval: caddy.Duration(time.Second),
+			},
+		},
+		{
+			name: "lock timeout shorter than default polling interval should error",
+			fields: fields{
+				Table:       "Testing123",
+				LockTimeout: caddy.Duration(time.Second),
+			},
+			wantErr: true,
+			expected: &Storage{
+				Table:               "Testing123",
+				LockTimeout:         caddy.Duration(time.Second),
+				LockPollingInterval: lockPollingInterval,
+			},
+		},
+		{
+			name: "sort key attribute without a value should error",
+			fields: fields{
+				Table:            "Testing123",
+				SortKeyAttribute: "SortKey",
+			},
+			wantErr: true,
 			expected: &Storage{
 				Table:               "Testing123",
-				AwsSession:          defaultAwsSession,
 				LockTimeout:         lockTimeoutMinutes,
 				LockPollingInterval: lockPollingInterval,
+				SortKeyAttribute:    "SortKey",
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &Storage{
-				Table:         tt.fields.Table,
-				AwsSession:    tt.fields.AwsSession,
-				AwsEndpoint:   tt.fields.AwsEndpoint,
-				AwsRegion:     tt.fields.AwsRegion,
-				AwsDisableSSL: tt.fields.AwsDisableSSL,
+				Table:               tt.fields.Table,
+				AwsSession:          tt.fields.AwsSession,
+				AwsEndpoint:         tt.fields.AwsEndpoint,
+				AwsRegion:           tt.fields.AwsRegion,
+				AwsDisableSSL:       tt.fields.AwsDisableSSL,
+				LockTimeout:         tt.fields.LockTimeout,
+				LockPollingInterval: tt.fields.LockPollingInterval,
+				SortKeyAttribute:    tt.fields.SortKeyAttribute,
 			}
 			if err := s.initConfig(); (err != nil) != tt.wantErr {
 				t.Errorf("initConfig() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			// unset AwsSession since it is too complicated for reflection testing
+			// unset AwsSession and internal lock state since they are too complicated for reflection testing
 			s.AwsSession = tt.expected.AwsSession
+			s.locks = tt.expected.locks
+			s.logger = tt.expected.logger
+			s.owner = tt.expected.owner
 			if !reflect.DeepEqual(tt.expected, s) {
 				t.Errorf("Expected does not match actual: %+v != %+v. \nAwsSession \n\texpected: %+v, \n\tactual: %+v",
 					tt.expected, s, tt.expected.AwsSession, s.AwsSession)
@@ -148,6 +282,470 @@ func TestDynamoDBStorage_initConfg(t *testing.T) {
 	}
 }
 
+// clearEndpointEnv unsets the endpoint environment variables for the duration
+// of the test, so that initConfig uses the standard DynamoDB endpoints
+func clearEndpointEnv(t *testing.T) {
+	for _, name := range endpointEnvVars {
+		t.Setenv(name, "")
+	}
+}
+
+func TestDynamoDBStorage_endpointFromEnv(t *testing.T) {
+	clearEndpointEnv(t)
+	t.Setenv("AWS_ENDPOINT", "http://localhost:8000")
+
+	storage := Storage{Table: TestTableName, AwsRegion: "us-east-1"}
+	if err := storage.initConfig(); err != nil {
+		t.Errorf("initConfig() error = %v", err)
+		return
+	}
+	if storage.AwsEndpoint != "http://localhost:8000" {
+		t.Errorf("expected the endpoint from AWS_ENDPOINT, got: %q", storage.AwsEndpoint)
+	}
+	if endpoint := dynamodb.New(storage.AwsSession).Endpoint; endpoint != "http://localhost:8000" {
+		t.Errorf("expected the client to use the endpoint from AWS_ENDPOINT, got: %s", endpoint)
+	}
+
+	// the DynamoDB specific variable comes first
+	t.Setenv("AWS_ENDPOINT_URL_DYNAMODB", "http://dynamodb.local:8000")
+	storage = Storage{Table: TestTableName, AwsRegion: "us-east-1"}
+	if err := storage.initConfig(); err != nil {
+		t.Errorf("initConfig() error = %v", err)
+		return
+	}
+	if storage.AwsEndpoint != "http://dynamodb.local:8000" {
+		t.Errorf("expected the endpoint from AWS_ENDPOINT_URL_DYNAMODB, got: %q", storage.AwsEndpoint)
+	}
+
+	// an explicit endpoint wins
+	storage = Storage{Table: TestTableName, AwsRegion: "us-east-1", AwsEndpoint: "http://explicit:8000"}
+	if err := storage.initConfig(); err != nil {
+		t.Errorf("initConfig() error = %v", err)
+		return
+	}
+	if storage.AwsEndpoint != "http://explicit:8000" {
+		t.Errorf("expected the configured endpoint to be kept, got: %q", storage.AwsEndpoint)
+	}
+}
+
+func TestDynamoDBStorage_awsConfig(t *testing.T) {
+	clearEndpointEnv(t)
+	storage := Storage{
+		UseFIPSEndpoint:      true,
+		UseDualStackEndpoint: true,
+	}
+	config := storage.awsConfig()
+	if config.UseFIPSEndpoint != endpoints.FIPSEndpointStateEnabled {
+		t.Errorf("FIPS endpoint should be enabled")
+	}
+	if config.UseDualStackEndpoint != endpoints.DualStackEndpointStateEnabled {
+		t.Errorf("dual-stack endpoint should be enabled")
+	}
+
+	storage = Storage{Table: TestTableName, AwsRegion: "us-gov-west-1", UseFIPSEndpoint: true}
+	if err := storage.initConfig(); err != nil {
+		t.Errorf("initConfig() error = %v", err)
+		return
+	}
+	endpoint := dynamodb.New(storage.AwsSession).Endpoint
+	if endpoint != "https://dynamodb.us-gov-west-1.amazonaws.com" {
+		t.Errorf("GovCloud FIPS endpoint does not match, got: %s", endpoint)
+	}
+
+	storage = Storage{Table: TestTableName, AwsRegion: "us-east-1", UseDualStackEndpoint: true}
+	if err := storage.initConfig(); err != nil {
+		t.Errorf("initConfig() error = %v", err)
+		return
+	}
+	endpoint = dynamodb.New(storage.AwsSession).Endpoint
+	if !strings.Contains(endpoint, "api.aws") {
+		t.Errorf("dual-stack endpoint does not match, got: %s", endpoint)
+	}
+}
+
+func TestDynamoDBStorage_DebugAWSRequests(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	storage := Storage{DebugAWSRequests: true, logger: zap.New(core)}
+
+	config := storage.awsConfig()
+	if !config.LogLevel.Matches(aws.LogDebugWithHTTPBody) {
+		t.Errorf("request and response bodies should be logged, log level: %v", config.LogLevel.Value())
+	}
+	config.Logger.Log("DEBUG: Request dynamodb/GetItem Details:")
+	if logs.FilterMessageSnippet("dynamodb/GetItem").Len() != 1 {
+		t.Errorf("SDK logs should be written to the storage's logger, got: %v", logs.All())
+	}
+
+	storage = Storage{}
+	if config := storage.awsConfig(); config.LogLevel.Value() != aws.LogOff {
+		t.Errorf("SDK logging should be off by default, log level: %v", config.LogLevel.Value())
+	}
+}
+
+func TestDynamoDBStorage_initConfigRegion(t *testing.T) {
+	clearEndpointEnv(t)
+	imds := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/latest/api/token":
+			fmt.Fprint(w, "token")
+		case "/latest/dynamic/instance-identity/document":
+			fmt.Fprint(w, `{"region": "ap-southeast-2"}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer imds.Close()
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "AWS_REGION",
+			env:  map[string]string{"AWS_REGION": "eu-west-2"},
+			want: "eu-west-2",
+		},
+		{
+			name: "AWS_DEFAULT_REGION",
+			env:  map[string]string{"AWS_DEFAULT_REGION": "us-west-1"},
+			want: "us-west-1",
+		},
+		{
+			name: "AWS_REGION before AWS_DEFAULT_REGION",
+			env:  map[string]string{"AWS_REGION": "eu-west-2", "AWS_DEFAULT_REGION": "us-west-1"},
+			want: "eu-west-2",
+		},
+		{
+			name: "instance metadata",
+			env:  map[string]string{"AWS_EC2_METADATA_SERVICE_ENDPOINT": imds.URL},
+			want: "ap-southeast-2",
+		},
+		{
+			name:    "none",
+			env:     map[string]string{"AWS_EC2_METADATA_DISABLED": "true"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("AWS_REGION", "")
+			t.Setenv("AWS_DEFAULT_REGION", "")
+			t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			s := &Storage{Table: TestTableName}
+			err := s.initConfig()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("initConfig() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if region := aws.StringValue(s.AwsSession.Config.Region); region != tt.want {
+				t.Errorf("unexpected region, expected: %q, got: %q", tt.want, region)
+			}
+		})
+	}
+}
+
+func TestDynamoDBStorage_initConfigProfile(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config")
+	err := os.WriteFile(config, []byte("[default]\nregion = us-east-1\n\n[profile dev]\nregion = eu-central-1\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("AWS_CONFIG_FILE", config)
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_DEFAULT_REGION", "")
+
+	s := &Storage{Table: TestTableName, AwsProfile: "dev"}
+	if err := s.initConfig(); err != nil {
+		t.Errorf("initConfig() error = %v", err)
+		return
+	}
+	if region := aws.StringValue(s.AwsSession.Config.Region); region != "eu-central-1" {
+		t.Errorf("region should come from the dev profile, got: %q", region)
+	}
+}
+
+func TestDynamoDBStorage_initConfigSSOProfile(t *testing.T) {
+	// stands in for the AWS SSO portal, which hands out role credentials for the cached token
+	portal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/federation/credentials" || r.Header.Get("x-amz-sso_bearer_token") != "sso-token" ||
+			r.URL.Query().Get("account_id") != "123456789012" || r.URL.Query().Get("role_name") != "CertMagic" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"roleCredentials": {"accessKeyId": "sso-key", "secretAccessKey": "sso-secret",
+			"sessionToken": "sso-session", "expiration": %d}}`, time.Now().Add(time.Hour).UnixMilli())
+	}))
+	defer portal.Close()
+
+	home := t.TempDir()
+	config := filepath.Join(home, "config")
+	err := os.WriteFile(config, []byte(`[profile sso]
+sso_start_url = https://example.awsapps.com/start
+sso_region = us-east-1
+sso_account_id = 123456789012
+sso_role_name = CertMagic
+region = us-east-1
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the token left behind by "aws sso login", named after the hash of the start URL
+	hash := sha1.Sum([]byte("https://example.awsapps.com/start"))
+	cache := filepath.Join(home, ".aws", "sso", "cache")
+	if err := os.MkdirAll(cache, 0o700); err != nil {
+		t.Fatal(err)
+	}
+	token := fmt.Sprintf(`{"accessToken": "sso-token", "expiresAt": %q}`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	if err := os.WriteFile(filepath.Join(cache, hex.EncodeToString(hash[:])+".json"), []byte(token), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("HOME", home)
+	t.Setenv("AWS_CONFIG_FILE", config)
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	s := &Storage{Table: TestTableName, AwsProfile: "sso", AwsEndpoint: portal.URL}
+	if err := s.initConfig(); err != nil {
+		t.Errorf("initConfig() error = %v", err)
+		return
+	}
+	creds, err := s.AwsSession.Config.Credentials.Get()
+	if err != nil {
+		t.Errorf("failed to get credentials: %s", err.Error())
+		return
+	}
+	if creds.AccessKeyID != "sso-key" || creds.SessionToken != "sso-session" {
+		t.Errorf("credentials should come from AWS SSO, got: %s from %s", creds.AccessKeyID, creds.ProviderName)
+	}
+}
+
+// TestDynamoDBStorage_SSOIntegration resolves credentials for a real AWS SSO profile,
+// set in AWS_SSO_TEST_PROFILE, after logging in with "aws sso login --profile <profile>"
+func TestDynamoDBStorage_SSOIntegration(t *testing.T) {
+	profile := os.Getenv("AWS_SSO_TEST_PROFILE")
+	if profile == "" {
+		t.Skip("AWS_SSO_TEST_PROFILE is not set")
+	}
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	s := &Storage{Table: TestTableName, AwsProfile: profile}
+	if err := s.initConfig(); err != nil {
+		t.Errorf("initConfig() error = %v", err)
+		return
+	}
+	creds, err := s.AwsSession.Config.Credentials.Get()
+	if err != nil {
+		t.Errorf("failed to get credentials for profile %s: %s", profile, err.Error())
+		return
+	}
+	if creds.ProviderName != ssocreds.ProviderName {
+		t.Errorf("credentials should come from AWS SSO, got: %s", creds.ProviderName)
+	}
+}
+
+func TestDynamoDBStorage_initConfigTableAffixes(t *testing.T) {
+	tests := []struct {
+		name   string
+		table  string
+		prefix string
+		suffix string
+		want   string
+	}{
+		{name: "table only", table: "certs", want: "certs"},
+		{name: "prefix", table: "certs", prefix: "staging-", want: "staging-certs"},
+		{name: "suffix", table: "certs", suffix: "-prod", want: "certs-prod"},
+		{name: "prefix and suffix", table: "certs", prefix: "app-", suffix: "-prod", want: "app-certs-prod"},
+		{name: "no base name", prefix: "certs-", suffix: "dev", want: "certs-dev"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Storage{
+				Table:       tt.table,
+				TablePrefix: tt.prefix,
+				TableSuffix: tt.suffix,
+				AwsSession:  newMockSession(func(r *request.Request) {}),
+			}
+			// the prefix and suffix must only be added once
+			for i := 0; i < 2; i++ {
+				if err := s.initConfig(); err != nil {
+					t.Errorf("initConfig() error = %v", err)
+					return
+				}
+			}
+			if s.Table != tt.want {
+				t.Errorf("unexpected table name, expected: %q, got: %q", tt.want, s.Table)
+			}
+		})
+	}
+
+	if err := (&Storage{}).initConfig(); !errors.Is(err, ErrTableRequired) {
+		t.Errorf("initConfig without any table name should return ErrTableRequired, got: %v", err)
+	}
+}
+
+// TestDynamoDBStorage_initConfigDefaultChain checks that a storage with no AWS settings
+// at all picks up its region and credentials from the environment it runs in, e.g. an
+// EC2 instance or ECS task role. Set TEST_AWS_DEFAULT_CHAIN to run it on such a host.
+func TestDynamoDBStorage_initConfigDefaultChain(t *testing.T) {
+	if os.Getenv("TEST_AWS_DEFAULT_CHAIN") == "" {
+		t.Skip("TEST_AWS_DEFAULT_CHAIN not set")
+	}
+
+	s := &Storage{Table: TestTableName}
+	if err := s.initConfig(); err != nil {
+		t.Errorf("initConfig() error = %v", err)
+		return
+	}
+	if aws.StringValue(s.AwsSession.Config.Region) == "" {
+		t.Errorf("no region found")
+	}
+	if _, err := s.AwsSession.Config.Credentials.Get(); err != nil {
+		t.Errorf("no credentials found: %s", err.Error())
+	}
+}
+
+func TestDynamoDBStorage_initConfigConcurrent(t *testing.T) {
+	storage := Storage{
+		Table:      TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {}),
+		CacheTTL:   caddy.Duration(time.Minute),
+	}
+
+	// run with -race to check that the first uses don't race to initialize the storage
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := storage.Store(context.Background(), fmt.Sprintf("key%d", i), []byte("value")); err != nil {
+				t.Errorf("error storing: %s", err.Error())
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestDynamoDBStorage_initConfigIndependent(t *testing.T) {
+	slow := &Storage{Table: TestTableName, AwsRegion: "us-east-1"}
+	other := &Storage{Table: TestTableName, AwsRegion: "us-east-1"}
+
+	// as if slow were creating its session or looking up its region
+	slow.initMu.Lock()
+	defer slow.initMu.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- other.initConfig()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("initConfig() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("initializing one storage should not wait for another")
+	}
+}
+
+func TestDynamoDBStorage_LockConcurrentFirstUse(t *testing.T) {
+	storage := Storage{
+		Table:      TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {}),
+	}
+
+	// run with -race to check that all locks are recorded in the same lock map
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := storage.Lock(context.Background(), fmt.Sprintf("key%d", i)); err != nil {
+				t.Errorf("error locking: %s", err.Error())
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 20; i++ {
+		if _, ok := storage.locks.Load(fmt.Sprintf("key%d", i)); !ok {
+			t.Errorf("lock handle for key%d was lost", i)
+		}
+	}
+}
+
+func TestDynamoDBStorage_RequestTimeouts(t *testing.T) {
+	deadlines := map[string]time.Duration{}
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			deadline, ok := r.Context().Deadline()
+			if !ok {
+				t.Errorf("%s has no deadline", r.Operation.Name)
+				return
+			}
+			deadlines[r.Operation.Name] = time.Until(deadline)
+			if out, ok := r.Data.(*dynamodb.GetItemOutput); ok {
+				out.Item = mockItem("key", "value")
+			}
+		}),
+		ReadTimeout:  caddy.Duration(time.Minute),
+		WriteTimeout: caddy.Duration(time.Hour),
+	}
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "key", []byte("value")); err != nil {
+		t.Errorf("error storing: %s", err.Error())
+	}
+	if _, err := storage.Load(ctx, "key"); err != nil {
+		t.Errorf("error loading: %s", err.Error())
+	}
+	if _, err := storage.List(ctx, "key", false); err != nil {
+		t.Errorf("error listing: %s", err.Error())
+	}
+	if err := storage.Delete(ctx, "key"); err != nil {
+		t.Errorf("error deleting: %s", err.Error())
+	}
+
+	expected := map[string]time.Duration{
+		"GetItem":    time.Minute,
+		"Scan":       time.Minute,
+		"PutItem":    time.Hour,
+		"DeleteItem": time.Hour,
+	}
+	for operation, timeout := range expected {
+		deadline, ok := deadlines[operation]
+		if !ok {
+			t.Errorf("%s was not called", operation)
+			continue
+		}
+		if deadline > timeout || deadline < timeout-time.Second {
+			t.Errorf("%s deadline does not match, expected about %s, got %s", operation, timeout, deadline)
+		}
+	}
+
+	// a shorter deadline on the context still applies
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if _, err := storage.Load(ctx, "key"); err != nil {
+		t.Errorf("error loading: %s", err.Error())
+	}
+	if deadlines["GetItem"] > time.Second {
+		t.Errorf("GetItem deadline should come from the context, got %s", deadlines["GetItem"])
+	}
+}
+
 func TestDynamoDBStorage_Store(t *testing.T) {
 	err := initDb()
 	if err != nil {
@@ -232,72 +830,347 @@ func TestDynamoDBStorage_Store(t *testing.T) {
 	}
 }
 
-func TestDynamoDBStorage_List(t *testing.T) {
-	err := initDb()
-	if err != nil {
-		t.Error(err)
+func TestDynamoDBStorage_StoreOversized(t *testing.T) {
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			r.Error = errors.New("unexpected request: " + r.Operation.Name)
+		}),
+	}
+
+	// base64 encoding grows the value by a third, pushing it past the limit
+	value := make([]byte, 350*1024)
+
+	err := storage.Store(context.Background(), "big-key", value)
+	if err == nil {
+		t.Errorf("storing an oversized value should error")
 		return
 	}
+	if !strings.Contains(err.Error(), `"big-key"`) || !strings.Contains(err.Error(), "400KB item limit") {
+		t.Errorf("error should describe the oversized key and limit, got: %s", err.Error())
+	}
+	if strings.Contains(err.Error(), "unexpected request") {
+		t.Errorf("oversized value should be rejected before calling DynamoDB")
+	}
+}
 
+func TestDynamoDBStorage_StoreWithTTL(t *testing.T) {
+	var item map[string]*dynamodb.AttributeValue
 	storage := Storage{
-		Table:         TestTableName,
-		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
-		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
-		AwsDisableSSL: DisableSSL,
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			if input, ok := r.Params.(*dynamodb.PutItemInput); ok {
+				item = input.Item
+			}
+		}),
 	}
+	ctx := context.Background()
 
-	prefix := "domain"
+	before := time.Now().Add(time.Hour).Unix()
+	if err := storage.StoreWithTTL(ctx, "key", []byte("value"), time.Hour); err != nil {
+		t.Errorf("error storing: %s", err.Error())
+		return
+	}
+	after := time.Now().Add(time.Hour).Unix()
 
-	fixturesWithPrefix := map[string]string{
-		"domain1": "cert1",
-		"domain2": "cert2",
-		"domain3": "cert3",
+	if item[expiresAtAttribute] == nil {
+		t.Errorf("stored item is missing %s: %v", expiresAtAttribute, item)
+		return
 	}
-	for k, v := range fixturesWithPrefix {
-		err := storage.Store(context.Background(), k, []byte(v))
+	expiresAt, err := strconv.ParseInt(aws.StringValue(item[expiresAtAttribute].N), 10, 64)
+	if err != nil {
+		t.Errorf("%s should be an epoch in seconds: %s", expiresAtAttribute, err.Error())
+		return
+	}
+	if expiresAt < before || expiresAt > after {
+		t.Errorf("%s does not match, expected between %d and %d, got: %d", expiresAtAttribute, before, after, expiresAt)
+	}
+
+	if err := storage.Store(ctx, "key", []byte("value")); err != nil {
+		t.Errorf("error storing: %s", err.Error())
+		return
+	}
+	if _, ok := item[expiresAtAttribute]; ok {
+		t.Errorf("Store should not set %s", expiresAtAttribute)
+	}
+
+	if err := storage.StoreWithTTL(ctx, "key", []byte("value"), 0); err == nil {
+		t.Errorf("a zero ttl should error")
+	}
+}
+
+func TestDynamoDBStorage_Fallback(t *testing.T) {
+	var primaryErr error
+	var writes []string
+	var fallbackRegion string
+	storage := Storage{
+		Table:          TestTableName,
+		FallbackTable:  "CertMagicFallback",
+		FallbackRegion: "us-west-2",
+		AwsSession: newMockSession(func(r *request.Request) {
+			var table string
+			switch input := r.Params.(type) {
+			case *dynamodb.GetItemInput:
+				table = aws.StringValue(input.TableName)
+			case *dynamodb.PutItemInput:
+				table = aws.StringValue(input.TableName)
+				writes = append(writes, table)
+			case *dynamodb.DeleteItemInput:
+				table = aws.StringValue(input.TableName)
+				writes = append(writes, table)
+			}
+
+			if table == TestTableName {
+				r.Error = primaryErr
+				return
+			}
+			fallbackRegion = aws.StringValue(r.Config.Region)
+			if out, ok := r.Data.(*dynamodb.GetItemOutput); ok {
+				out.Item = mockItem("key", "fallback value")
+			}
+		}),
+	}
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "key", []byte("value")); err != nil {
+		t.Errorf("error storing: %s", err.Error())
+		return
+	}
+	if err := storage.Delete(ctx, "key"); err != nil {
+		t.Errorf("error deleting: %s", err.Error())
+		return
+	}
+	expected := []string{TestTableName, "CertMagicFallback", TestTableName, "CertMagicFallback"}
+	if !reflect.DeepEqual(writes, expected) {
+		t.Errorf("writes should go to both tables, expected: %v, got: %v", expected, writes)
+	}
+	if fallbackRegion != "us-west-2" {
+		t.Errorf("fallback table should be accessed in the fallback region, got: %s", fallbackRegion)
+	}
+
+	writes = nil
+	if _, err := storage.StoreIfVersion(ctx, "key", []byte("value"), 0); err != nil {
+		t.Errorf("error storing version: %s", err.Error())
+		return
+	}
+	expected = []string{TestTableName, "CertMagicFallback"}
+	if !reflect.DeepEqual(writes, expected) {
+		t.Errorf("versioned writes should go to both tables, expected: %v, got: %v", expected, writes)
+	}
+
+	failures := map[string]error{
+		"table not found": awserr.New(dynamodb.ErrCodeResourceNotFoundException, "table not found", nil),
+		"service unavailable": awserr.NewRequestFailure(
+			awserr.New("ServiceUnavailable", "service unavailable", nil), http.StatusServiceUnavailable, "id"),
+		"connection error": awserr.New(request.ErrCodeRequestError, "send request failed", nil),
+	}
+	for name, err := range failures {
+		primaryErr = err
+		value, err := storage.Load(ctx, "key")
 		if err != nil {
-			t.Errorf("failed to store fixture %s, error: %s", k, err.Error())
-			return
+			t.Errorf("%s: Load should read from the fallback table, got error: %s", name, err.Error())
+			continue
+		}
+		if string(value) != "fallback value" {
+			t.Errorf("%s: loaded value does not match, got: %s", name, value)
 		}
 	}
 
-	fixtures := map[string]string{
-		"notinlist":        "cert4",
-		"anothernotinlist": "cert5",
+	// errors that don't mean the table is unavailable are returned as is
+	primaryErr = awserr.New("AccessDeniedException", "not authorized", nil)
+	if _, err := storage.Load(ctx, "key"); err == nil {
+		t.Errorf("Load should not fall back on access denied")
 	}
-	for k, v := range fixtures {
-		err := storage.Store(context.Background(), k, []byte(v))
-		if err != nil {
-			t.Errorf("failed to store fixture %s, error: %s", k, err.Error())
-			return
+}
+
+func TestDynamoDBStorage_Hooks(t *testing.T) {
+	var failRequests bool
+	var stored, deleted []string
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			if failRequests {
+				r.Error = awserr.New("InternalServerError", "try again", nil)
+			}
+		}),
+		OnStore:  func(key string) { stored = append(stored, key) },
+		OnDelete: func(key string) { deleted = append(deleted, key) },
+	}
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "stored", []byte("value")); err != nil {
+		t.Errorf("error storing: %s", err.Error())
+		return
+	}
+	if _, err := storage.StoreIfVersion(ctx, "versioned", []byte("value"), 0); err != nil {
+		t.Errorf("error storing: %s", err.Error())
+		return
+	}
+	if err := storage.Delete(ctx, "deleted"); err != nil {
+		t.Errorf("error deleting: %s", err.Error())
+		return
+	}
+
+	failRequests = true
+	if err := storage.Store(ctx, "failed", []byte("value")); err == nil {
+		t.Errorf("expected store to fail")
+	}
+	if err := storage.Delete(ctx, "failed"); err == nil {
+		t.Errorf("expected delete to fail")
+	}
+
+	if !reflect.DeepEqual(stored, []string{"stored", "versioned"}) {
+		t.Errorf("OnStore should be called only for successful writes, got: %v", stored)
+	}
+	if !reflect.DeepEqual(deleted, []string{"deleted"}) {
+		t.Errorf("OnDelete should be called only for successful deletes, got: %v", deleted)
+	}
+}
+
+func TestDynamoDBStorage_WriteAuditLog(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	var failRequests bool
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			if failRequests {
+				r.Error = awserr.New("InternalServerError", "try again", nil)
+			}
+		}),
+		WriteAuditLog: true,
+		logger:        zap.New(core),
+	}
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "stored", []byte("secret value")); err != nil {
+		t.Errorf("error storing: %s", err.Error())
+		return
+	}
+	if err := storage.Delete(ctx, "deleted"); err != nil {
+		t.Errorf("error deleting: %s", err.Error())
+		return
+	}
+
+	failRequests = true
+	if err := storage.Store(ctx, "failed", []byte("value")); err == nil {
+		t.Errorf("expected store to fail")
+	}
+	if err := storage.Delete(ctx, "failed"); err == nil {
+		t.Errorf("expected delete to fail")
+	}
+
+	entries := logs.FilterMessage("write succeeded").AllUntimed()
+	if len(entries) != 2 {
+		t.Errorf("expected an audit entry for each successful write only, got: %v", entries)
+		return
+	}
+	store, del := entries[0].ContextMap(), entries[1].ContextMap()
+	if store["operation"] != "Store" || store["key"] != "stored" || store["bytes"] != int64(len("secret value")) {
+		t.Errorf("store audit entry does not match, got: %v", store)
+	}
+	if del["operation"] != "Delete" || del["key"] != "deleted" {
+		t.Errorf("delete audit entry does not match, got: %v", del)
+	}
+	for _, entry := range entries {
+		for _, field := range entry.Context {
+			if strings.Contains(field.String, "secret") {
+				t.Errorf("audit entry should not contain the value, got: %v", entry.ContextMap())
+			}
 		}
 	}
+}
 
-	foundKeys, err := storage.List(context.Background(), prefix, false)
+func TestDynamoDBStorage_DryRun(t *testing.T) {
+	var writes []string
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			writes = append(writes, r.Operation.Name)
+		}),
+		DryRun:   true,
+		OnStore:  func(key string) { t.Errorf("OnStore should not be called in dry run") },
+		OnDelete: func(key string) { t.Errorf("OnDelete should not be called in dry run") },
+	}
+	ctx := context.Background()
+
+	if err := storage.Store(ctx, "key", []byte("value")); err != nil {
+		t.Errorf("error storing: %s", err.Error())
+	}
+	if _, err := storage.StoreIfVersion(ctx, "key", []byte("value"), 1); err != nil {
+		t.Errorf("error storing: %s", err.Error())
+	}
+	if err := storage.Delete(ctx, "key"); err != nil {
+		t.Errorf("error deleting: %s", err.Error())
+	}
+	if len(writes) != 0 {
+		t.Errorf("dry run should not call DynamoDB, called: %v", writes)
+	}
+
+	// input is still validated
+	if err := storage.Store(ctx, "", []byte("value")); !errors.Is(err, ErrEmptyKey) {
+		t.Errorf("dry run should still validate keys, got: %v", err)
+	}
+	if err := storage.Store(ctx, "big-key", make([]byte, 350*1024)); err == nil {
+		t.Errorf("dry run should still reject oversized values")
+	}
+}
+
+// TestDynamoDBStorage_StoreRetried checks that a Store whose response is lost after
+// DynamoDB wrote the item, so that the SDK retries it, succeeds without changing the item
+func TestDynamoDBStorage_StoreRetried(t *testing.T) {
+	err := initDb()
 	if err != nil {
-		t.Errorf("failed to list keys: %s", err.Error())
+		t.Error(err)
 		return
 	}
 
-	if len(foundKeys) != len(fixturesWithPrefix) {
-		t.Errorf("did not get back expected number of keys, expected: %v, got: %v",
-			len(fixturesWithPrefix), len(foundKeys))
+	storage := Storage{
+		Table:               TestTableName,
+		AwsEndpoint:         os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:           os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:       DisableSSL,
+		SkipUnchangedWrites: true,
+	}
+	if err := storage.initConfig(); err != nil {
+		t.Error(err)
 		return
 	}
 
-	noKeysFound, err := storage.List(context.Background(), "invalid", false)
-	if err != nil {
-		t.Errorf("unable to list keys with invalid prefix: %s", err.Error())
+	puts := 0
+	var written string
+	storage.AwsSession.Handlers.Send.PushBack(func(r *request.Request) {
+		if r.Operation.Name != "PutItem" {
+			return
+		}
+		puts++
+		if puts == 1 {
+			written = aws.StringValue(r.Params.(*dynamodb.PutItemInput).Item[lastUpdatedAttribute].S)
+			// LastUpdated has a resolution of one second
+			time.Sleep(1100 * time.Millisecond)
+			r.Error = awserr.New(request.ErrCodeRequestError, "response lost", nil)
+			r.Retryable = aws.Bool(true)
+		}
+	})
+
+	if err := storage.Store(context.Background(), "key", []byte("value")); err != nil {
+		t.Errorf("retried store failed: %s", err.Error())
 		return
 	}
+	if puts != 2 {
+		t.Errorf("expected the store to be retried once, got %v PutItem calls", puts)
+	}
 
-	if len(noKeysFound) != 0 {
-		t.Errorf("should not have found any keys but found %v key", len(noKeysFound))
+	info, err := storage.Stat(context.Background(), "key")
+	if err != nil {
+		t.Errorf("failed to stat item: %s", err.Error())
 		return
 	}
+	if info.Modified.Format(time.RFC3339) != written {
+		t.Errorf("retry changed LastUpdated from %s to %s", written, info.Modified.Format(time.RFC3339))
+	}
 }
 
-func TestDynamoDBStorage_Stat(t *testing.T) {
+func TestDynamoDBStorage_StoreSkipUnchanged(t *testing.T) {
 	err := initDb()
 	if err != nil {
 		t.Error(err)
@@ -305,10 +1178,11 @@ func TestDynamoDBStorage_Stat(t *testing.T) {
 	}
 
 	storage := Storage{
-		Table:         TestTableName,
-		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
-		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
-		AwsDisableSSL: DisableSSL,
+		Table:               TestTableName,
+		AwsEndpoint:         os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:           os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:       DisableSSL,
+		SkipUnchangedWrites: true,
 	}
 
 	err = storage.Store(context.Background(), "key", []byte("value"))
@@ -316,28 +1190,104 @@ func TestDynamoDBStorage_Stat(t *testing.T) {
 		t.Errorf("failed to store fixture key/value: %s", err.Error())
 		return
 	}
+	before, err := storage.Stat(context.Background(), "key")
+	if err != nil {
+		t.Errorf("failed to stat item: %s", err.Error())
+		return
+	}
 
-	stat, err := storage.Stat(context.Background(), "key")
+	// LastUpdated has a resolution of one second
+	time.Sleep(1100 * time.Millisecond)
+
+	err = storage.Store(context.Background(), "key", []byte("value"))
+	if err != nil {
+		t.Errorf("failed to store identical value: %s", err.Error())
+		return
+	}
+	after, err := storage.Stat(context.Background(), "key")
 	if err != nil {
 		t.Errorf("failed to stat item: %s", err.Error())
 		return
 	}
+	if !after.Modified.Equal(before.Modified) {
+		t.Errorf("storing identical content changed LastUpdated from %s to %s", before.Modified, after.Modified)
+		return
+	}
 
-	if stat.Key != "key" {
-		t.Errorf("stat key does not match expected. got: %s", stat.Key)
+	err = storage.Store(context.Background(), "key", []byte("new value"))
+	if err != nil {
+		t.Errorf("failed to store new value: %s", err.Error())
 		return
 	}
-	if stat.Size != int64(len("value")) {
-		t.Errorf("stat size does not match expected. got: %v", stat.Size)
+	changed, err := storage.Stat(context.Background(), "key")
+	if err != nil {
+		t.Errorf("failed to stat item: %s", err.Error())
 		return
 	}
-	if time.Since(stat.Modified) > 5*time.Second {
-		t.Errorf("stat modified time is not within 3 seoncds. got: %s", stat.Modified)
+	if !changed.Modified.After(before.Modified) {
+		t.Errorf("storing new content did not update LastUpdated, still %s", changed.Modified)
+		return
+	}
+
+	value, err := storage.Load(context.Background(), "key")
+	if err != nil {
+		t.Errorf("unable to load key: %s", err.Error())
+		return
+	}
+	if string(value) != "new value" {
+		t.Errorf("value returned does not match expected. expected: %s, got: %s", "new value", string(value))
+	}
+
+	// an identical value still clears what Store doesn't keep
+	ctx := context.Background()
+	if err := storage.StoreWithTTL(ctx, "ttl", []byte("value"), time.Hour); err != nil {
+		t.Errorf("failed to store with ttl: %s", err.Error())
+		return
+	}
+	if err := storage.StoreWithMeta(ctx, "meta", []byte("value"), map[string]string{"a": "b"}); err != nil {
+		t.Errorf("failed to store with meta: %s", err.Error())
+		return
+	}
+	if _, err := storage.StoreIfVersion(ctx, "version", []byte("value"), 0); err != nil {
+		t.Errorf("failed to store version: %s", err.Error())
+		return
+	}
+	var stored []string
+	storage.OnStore = func(key string) {
+		stored = append(stored, key)
+	}
+	for _, key := range []string{"ttl", "meta", "version"} {
+		if err := storage.Store(ctx, key, []byte("value")); err != nil {
+			t.Errorf("failed to store %s: %s", key, err.Error())
+			return
+		}
+	}
+	if err := storage.Store(ctx, "key", []byte("new value")); err != nil {
+		t.Errorf("failed to store identical value: %s", err.Error())
 		return
 	}
+	if !reflect.DeepEqual(stored, []string{"ttl", "meta", "version", "key"}) {
+		t.Errorf("expected OnStore for every store, skipped or not, got: %v", stored)
+	}
+
+	item, err := storage.client().GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		Key:       storage.itemKey("ttl"),
+		TableName: aws.String(storage.Table),
+	})
+	if err != nil {
+		t.Errorf("failed to get item: %s", err.Error())
+	} else if _, ok := item.Item[storage.ExpiresAtAttribute]; ok {
+		t.Errorf("expected Store to clear the TTL of an identical value")
+	}
+	if _, meta, err := storage.LoadWithMeta(ctx, "meta"); err != nil || len(meta) != 0 {
+		t.Errorf("expected Store to clear the metadata of an identical value, got: %v, %v", meta, err)
+	}
+	if _, err := storage.StoreIfVersion(ctx, "version", []byte("value"), 0); err != nil {
+		t.Errorf("expected Store to clear the version of an identical value, got: %v", err)
+	}
 }
 
-func TestDynamoDBStorage_Delete(t *testing.T) {
+func TestDynamoDBStorage_Chunking(t *testing.T) {
 	err := initDb()
 	if err != nil {
 		t.Error(err)
@@ -345,84 +1295,149 @@ func TestDynamoDBStorage_Delete(t *testing.T) {
 	}
 
 	storage := Storage{
-		Table:         TestTableName,
-		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
-		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
-		AwsDisableSSL: DisableSSL,
+		Table:          TestTableName,
+		AwsEndpoint:    os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:      os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:  DisableSSL,
+		EnableChunking: true,
+		ChunkSize:      4,
 	}
 
-	err = storage.Store(context.Background(), "key", []byte("value"))
-	if err != nil {
-		t.Errorf("failed to store fixture key/value: %s", err.Error())
+	// three parts, the last one shorter
+	if err := storage.Store(context.Background(), "big", []byte("0123456789")); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
 		return
 	}
 
-	value, err := storage.Load(context.Background(), "key")
+	value, err := storage.Load(context.Background(), "big")
 	if err != nil {
-		t.Errorf("unable to load key that was just stored: %s", err.Error())
+		t.Errorf("failed to load: %s", err.Error())
 		return
 	}
+	if string(value) != "0123456789" {
+		t.Errorf("value does not match, got: %s", value)
+	}
+	parts, err := storage.storedParts(context.Background(), "big")
+	if err != nil || parts.Count != 3 || parts.ID == "" {
+		t.Errorf("expected three parts with an ID, got: %+v, %v", parts, err)
+	}
+	for n, expected := range []string{"0123", "4567", "89"} {
+		part, err := storage.getItem(context.Background(), partKey("big", parts.ID, n))
+		if err != nil || part.Contents != expected {
+			t.Errorf("part %d does not match, expected: %s, got: %s, %v", n, expected, part.Contents, err)
+		}
+	}
 
-	if string(value) != "value" {
-		t.Errorf("value returned does not match expected. expected: %s, got: %s", "value", string(value))
+	keys, err := storage.List(context.Background(), "b", true)
+	if err != nil {
+		t.Errorf("failed to list: %s", err.Error())
 		return
 	}
+	if !reflect.DeepEqual(keys, []string{"big"}) {
+		t.Errorf("parts should not be listed, got: %v", keys)
+	}
 
-	err = storage.Delete(context.Background(), "key")
-	if err != nil {
-		t.Errorf("unable to delete key: %s", err.Error())
+	info, err := storage.Stat(context.Background(), "big")
+	if err != nil || info.Size != 10 {
+		t.Errorf("expected the size of the whole value, got: %v, %v", info.Size, err)
+	}
+
+	// a new value is stored in new parts, and leaves none of the old ones behind
+	if err := storage.Store(context.Background(), "big", []byte("abcdef")); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
 		return
 	}
+	if value, err := storage.Load(context.Background(), "big"); err != nil || string(value) != "abcdef" {
+		t.Errorf("value does not match, got: %s, %v", value, err)
+	}
+	for n := 0; n < 3; n++ {
+		if _, err := storage.getItem(context.Background(), partKey("big", parts.ID, n)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected old part %d to be deleted, got: %v", n, err)
+		}
+	}
 
-	if storage.Exists(context.Background(), "key") {
-		t.Errorf("key still exists after delete")
+	parts, err = storage.storedParts(context.Background(), "big")
+	if err != nil || parts.Count != 2 {
+		t.Errorf("expected two parts, got: %+v, %v", parts, err)
+	}
+	if err := storage.Delete(context.Background(), "big"); err != nil {
+		t.Errorf("failed to delete: %s", err.Error())
 		return
 	}
+	for n := 0; n < parts.Count; n++ {
+		if _, err := storage.getItem(context.Background(), partKey("big", parts.ID, n)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected part %d to be deleted, got: %v", n, err)
+		}
+	}
 
+	// a value that fits in one part is stored as usual
+	if err := storage.Store(context.Background(), "small", []byte("abc")); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+		return
+	}
+	if parts, err := storage.storedParts(context.Background(), "small"); err != nil || parts.Count != 0 {
+		t.Errorf("expected no parts for a small value, got: %+v, %v", parts, err)
+	}
 }
 
-func TestDynamoDBStorage_Lock(t *testing.T) {
+func TestDynamoDBStorage_ChunkingPartialFailure(t *testing.T) {
 	err := initDb()
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	lockTimeout := 1 * time.Second
-
 	storage := Storage{
-		Table:         TestTableName,
-		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
-		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
-		AwsDisableSSL: DisableSSL,
-		LockTimeout:   caddy.Duration(lockTimeout),
+		Table:          TestTableName,
+		AwsEndpoint:    os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:      os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:  DisableSSL,
+		EnableChunking: true,
+		ChunkSize:      4,
 	}
-
-	// create lock
-	key := "test1"
-	err = storage.Lock(context.TODO(), key)
-	if err != nil {
-		t.Errorf("error creating lock: %s", err.Error())
+	if err := storage.initConfig(); err != nil {
+		t.Error(err)
+		return
 	}
 
-	// try to create lock again, it should take about 1-2 seconds to return
-	before := time.Now()
-	err = storage.Lock(context.TODO(), key)
-	if err != nil {
-		t.Errorf("error creating lock second time: %s", err.Error())
+	// fail storing the third part
+	var stored []string
+	storage.AwsSession.Handlers.Build.PushFront(func(r *request.Request) {
+		input, ok := r.Params.(*dynamodb.PutItemInput)
+		if !ok {
+			return
+		}
+		key := aws.StringValue(input.Item[primaryKeyAttribute].S)
+		if strings.HasSuffix(key, "#part2") {
+			r.Error = errors.New("part failed")
+			return
+		}
+		stored = append(stored, key)
+	})
+
+	err = storage.Store(context.Background(), "big", []byte("0123456789"))
+	if err == nil {
+		t.Errorf("expected storing to fail")
+		return
 	}
-	if time.Since(before) < lockTimeout {
-		t.Errorf("creating second lock finished quicker than it shoud, in %v seconds", time.Since(before).Seconds())
+	if !strings.Contains(err.Error(), "storing part 2 of big") {
+		t.Errorf("error should name the failed part, got: %s", err.Error())
 	}
 
-	// try to unlock a key that doesn't exist
-	err = storage.Unlock(context.Background(), "doesntexist")
-	if err != nil {
-		t.Errorf("got error unlocking non-existant key")
+	if _, err := storage.Load(context.Background(), "big"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected no value after a failed store, got: %v", err)
+	}
+	if len(stored) != 2 {
+		t.Errorf("expected two parts to be stored before the failure, got: %v", stored)
+	}
+	for _, key := range stored {
+		if _, err := storage.getItem(context.Background(), key); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected part %s to be cleaned up, got: %v", key, err)
+		}
 	}
 }
 
-func TestDynamoDBStorage_LoadErrNotExist(t *testing.T) {
+func TestDynamoDBStorage_ChunkingReplaceFailure(t *testing.T) {
 	err := initDb()
 	if err != nil {
 		t.Error(err)
@@ -430,14 +1445,3593 @@ func TestDynamoDBStorage_LoadErrNotExist(t *testing.T) {
 	}
 
 	storage := Storage{
-		Table:         TestTableName,
-		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
-		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
-		AwsDisableSSL: DisableSSL,
+		Table:          TestTableName,
+		AwsEndpoint:    os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:      os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:  DisableSSL,
+		EnableChunking: true,
+		ChunkSize:      4,
+	}
+	ctx := context.Background()
+	if err := storage.Store(ctx, "big", []byte("0123456789")); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+		return
 	}
 
-	_, err = storage.Load(context.Background(), "notarealkey")
-	if !errors.Is(err, fs.ErrNotExist) {
-		t.Errorf("err was not a ErrNotExist, got: %s", err.Error())
+	// store all parts of a new value, but fail to point the item at key to them
+	var stored []string
+	storage.AwsSession.Handlers.Build.PushFront(func(r *request.Request) {
+		input, ok := r.Params.(*dynamodb.PutItemInput)
+		if !ok {
+			return
+		}
+		if key := aws.StringValue(input.Item[primaryKeyAttribute].S); key != "big" {
+			stored = append(stored, key)
+			return
+		}
+		r.Error = errors.New("item failed")
+	})
+	if err := storage.Store(ctx, "big", []byte("abcdefghij")); err == nil {
+		t.Errorf("expected storing to fail")
+		return
+	}
+
+	if value, err := storage.Load(ctx, "big"); err != nil || string(value) != "0123456789" {
+		t.Errorf("expected the previous value to be intact, got: %s, %v", value, err)
+	}
+	if len(stored) != 3 {
+		t.Errorf("expected three parts to be stored before the failure, got: %v", stored)
+	}
+	for _, key := range stored {
+		if _, err := storage.getItem(ctx, key); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected part %s to be cleaned up, got: %v", key, err)
+		}
+	}
+}
+
+func TestDynamoDBStorage_ChunkingDeletes(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:          TestTableName,
+		AwsEndpoint:    os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:      os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:  DisableSSL,
+		EnableChunking: true,
+		ChunkSize:      4,
+	}
+	if err := storage.initConfig(); err != nil {
+		t.Error(err)
+		return
+	}
+	var deletes int
+	storage.AwsSession.Handlers.Send.PushFront(func(r *request.Request) {
+		if r.Operation.Name == "DeleteItem" {
+			deletes++
+		}
+	})
+	ctx := context.Background()
+
+	// small values are stored without deleting anything, even under a key that looks like a part
+	for _, key := range []string{"s/small", "s/small", "s/user#part1"} {
+		if err := storage.Store(ctx, key, []byte("abc")); err != nil {
+			t.Errorf("failed to store: %s", err.Error())
+			return
+		}
+	}
+	if deletes != 0 {
+		t.Errorf("expected no deletes when storing small values, got: %d", deletes)
+	}
+	keys, err := storage.List(ctx, "s", true)
+	sort.Strings(keys)
+	if err != nil || !reflect.DeepEqual(keys, []string{"s/small", "s/user#part1"}) {
+		t.Errorf("expected keys that look like parts to be listed, got: %v, %v", keys, err)
+	}
+
+	if err := storage.Store(ctx, "big", []byte("0123456789")); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+		return
+	}
+	parts, err := storage.storedParts(ctx, "big")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if deleted, err := storage.DeleteIf(ctx, "big", []byte("other")); err != nil || deleted {
+		t.Errorf("expected a different value not to be deleted, got: %v, %v", deleted, err)
+	}
+	if _, err := storage.getItem(ctx, partKey("big", parts.ID, 0)); err != nil {
+		t.Errorf("expected the parts to be kept, got: %v", err)
+	}
+
+	deleted, err := storage.DeleteIf(ctx, "big", []byte("0123456789"))
+	if err != nil || !deleted {
+		t.Errorf("expected the value to be deleted, got: %v, %v", deleted, err)
+	}
+	for n := 0; n < 3; n++ {
+		if _, err := storage.getItem(ctx, partKey("big", parts.ID, n)); !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("expected part %d to be deleted, got: %v", n, err)
+		}
+	}
+
+	// values replaced by StoreTransaction and StoreIfVersion leave no parts behind either
+	replace := map[string]func() error{
+		"StoreTransaction": func() error {
+			return storage.StoreTransaction(ctx, map[string][]byte{"big": []byte("abc"), "other": []byte("def")})
+		},
+		"StoreIfVersion": func() error {
+			_, err := storage.StoreIfVersion(ctx, "big", []byte("abc"), 0)
+			return err
+		},
+	}
+	for name, replace := range replace {
+		if err := storage.Store(ctx, "big", []byte("0123456789")); err != nil {
+			t.Errorf("failed to store: %s", err.Error())
+			return
+		}
+		parts, err := storage.storedParts(ctx, "big")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := replace(); err != nil {
+			t.Errorf("%s failed: %s", name, err.Error())
+			continue
+		}
+		if value, err := storage.Load(ctx, "big"); err != nil || string(value) != "abc" {
+			t.Errorf("%s: value does not match, got: %s, %v", name, value, err)
+		}
+		for n := 0; n < 3; n++ {
+			if _, err := storage.getItem(ctx, partKey("big", parts.ID, n)); !errors.Is(err, fs.ErrNotExist) {
+				t.Errorf("%s: expected part %d to be deleted, got: %v", name, n, err)
+			}
+		}
+	}
+}
+
+func TestDynamoDBStorage_ListModifiedBetween(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	if err := storage.initConfig(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// store items as if they were written over the last three days
+	now := time.Now()
+	for key, age := range map[string]time.Duration{
+		"today":      0,
+		"yesterday":  24 * time.Hour,
+		"days-ago":   48 * time.Hour,
+		"LOCK-today": 0,
+	} {
+		item := storage.newItem(key, []byte(key))
+		item[lastUpdatedEpochAttribute] = &dynamodb.AttributeValue{
+			N: aws.String(strconv.FormatInt(now.Add(-age).Unix(), 10)),
+		}
+		_, err := storage.client().PutItemWithContext(context.Background(), &dynamodb.PutItemInput{
+			Item:      item,
+			TableName: aws.String(TestTableName),
+		})
+		if err != nil {
+			t.Errorf("failed to put %s: %s", key, err.Error())
+			return
+		}
+	}
+
+	// an item stored before the epoch was recorded
+	old := storage.newItem("unknown", []byte("unknown"))
+	delete(old, lastUpdatedEpochAttribute)
+	if _, err := storage.client().PutItemWithContext(context.Background(), &dynamodb.PutItemInput{Item: old, TableName: aws.String(TestTableName)}); err != nil {
+		t.Errorf("failed to put: %s", err.Error())
+		return
+	}
+
+	tests := map[string]struct {
+		start, end time.Time
+		want       []string
+	}{
+		"all":           {start: now.Add(-72 * time.Hour), end: now, want: []string{"days-ago", "today", "yesterday"}},
+		"last day":      {start: now.Add(-time.Hour), end: now, want: []string{"today"}},
+		"middle":        {start: now.Add(-36 * time.Hour), end: now.Add(-12 * time.Hour), want: []string{"yesterday"}},
+		"inclusive":     {start: now.Add(-48 * time.Hour), end: now.Add(-24 * time.Hour), want: []string{"days-ago", "yesterday"}},
+		"before any":    {start: now.Add(-96 * time.Hour), end: now.Add(-72 * time.Hour), want: nil},
+		"in the future": {start: now.Add(time.Hour), end: now.Add(2 * time.Hour), want: nil},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			keys, err := storage.ListModifiedBetween(context.Background(), tt.start, tt.end)
+			if err != nil {
+				t.Errorf("failed to list: %s", err.Error())
+				return
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("keys do not match, expected: %v, got: %v", tt.want, keys)
+			}
+		})
+	}
+
+	if _, err := storage.ListModifiedBetween(context.Background(), now, now.Add(-time.Hour)); err == nil {
+		t.Errorf("expected an error for an end before the start")
+	}
+
+	// values written by Store are found too
+	if err := storage.Store(context.Background(), "stored", []byte("stored")); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+		return
+	}
+	keys, err := storage.ListModifiedBetween(context.Background(), now.Add(-time.Minute), time.Now().Add(time.Minute))
+	sort.Strings(keys)
+	if err != nil || !reflect.DeepEqual(keys, []string{"stored", "today"}) {
+		t.Errorf("expected the stored value, got: %v, %v", keys, err)
+	}
+}
+
+func TestDynamoDBStorage_DeleteThenExists(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := storage.Store(context.Background(), "key", []byte("value")); err != nil {
+			t.Errorf("failed to store: %s", err.Error())
+			return
+		}
+		if !storage.Exists(context.Background(), "key") {
+			t.Errorf("expected key to exist right after storing it, attempt %d", i)
+			return
+		}
+		if err := storage.Delete(context.Background(), "key"); err != nil {
+			t.Errorf("failed to delete: %s", err.Error())
+			return
+		}
+		if storage.Exists(context.Background(), "key") {
+			t.Errorf("expected key not to exist right after deleting it, attempt %d", i)
+			return
+		}
+	}
+}
+
+func TestDynamoDBStorage_ConsistentReads(t *testing.T) {
+	var inconsistent []string
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			if input, ok := r.Params.(*dynamodb.GetItemInput); ok {
+				if !aws.BoolValue(input.ConsistentRead) {
+					inconsistent = append(inconsistent, r.Operation.Name)
+				}
+				r.Data.(*dynamodb.GetItemOutput).Item = mockItem("key", "value")
+			}
+		}),
+		ExistsConsistentRead: true,
+	}
+
+	ctx := context.Background()
+	if _, err := storage.Load(ctx, "key"); err != nil {
+		t.Errorf("failed to load: %s", err.Error())
+	}
+	if _, _, err := storage.LoadWithMeta(ctx, "key"); err != nil {
+		t.Errorf("failed to load with metadata: %s", err.Error())
+	}
+	if _, err := storage.Stat(ctx, "key"); err != nil {
+		t.Errorf("failed to stat: %s", err.Error())
+	}
+	storage.Exists(ctx, "key")
+
+	if len(inconsistent) > 0 {
+		t.Errorf("expected only strongly consistent reads, got %d eventually consistent ones", len(inconsistent))
+	}
+}
+
+func TestDynamoDBStorage_ExistsConsistentRead(t *testing.T) {
+	for _, consistent := range []bool{false, true} {
+		var inputs []*dynamodb.GetItemInput
+		storage := Storage{
+			Table: TestTableName,
+			AwsSession: newMockSession(func(r *request.Request) {
+				input := r.Params.(*dynamodb.GetItemInput)
+				inputs = append(inputs, input)
+				switch aws.StringValue(input.Key[primaryKeyAttribute].S) {
+				case "key":
+					r.Data.(*dynamodb.GetItemOutput).Item = map[string]*dynamodb.AttributeValue{
+						contentsAttribute: {S: aws.String("dmFsdWU=")},
+					}
+				case "empty":
+					// what's left of an item with the projection, if it has no contents
+					r.Data.(*dynamodb.GetItemOutput).Item = map[string]*dynamodb.AttributeValue{}
+				}
+			}),
+			ExistsConsistentRead: consistent,
+		}
+
+		if !storage.Exists(context.Background(), "key") {
+			t.Errorf("expected the key to exist")
+		}
+		if storage.Exists(context.Background(), "missing") {
+			t.Errorf("expected a missing key not to exist")
+		}
+		if storage.Exists(context.Background(), "empty") {
+			t.Errorf("expected an item without contents not to exist, like Load reports it")
+		}
+
+		for _, input := range inputs {
+			if aws.BoolValue(input.ConsistentRead) != consistent {
+				t.Errorf("with ExistsConsistentRead %v, got a read with ConsistentRead %v", consistent, aws.BoolValue(input.ConsistentRead))
+			}
+			projection := aws.StringValue(input.ProjectionExpression)
+			if projection != "#C, #P" || aws.StringValue(input.ExpressionAttributeNames["#C"]) != contentsAttribute {
+				t.Errorf("expected only the contents and parts to be read, got projection %q of %v", projection, input.ExpressionAttributeNames)
+			}
+		}
+		if len(inputs) != 3 {
+			t.Errorf("expected a read per check, got: %d", len(inputs))
+		}
+	}
+}
+
+func TestDynamoDBStorage_newFallback(t *testing.T) {
+	storage := &Storage{
+		Table:         TestTableName,
+		FallbackTable: "CertMagicFallback",
+		AwsSession:    newMockSession(func(r *request.Request) {}),
+		CacheTTL:      caddy.Duration(time.Minute),
+		Compression:   "gzip",
+		KeyEncoding:   "urlencode",
+		OnStore:       func(string) {},
+	}
+	if err := storage.initConfig(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	fallback := storage.fallback
+	if fallback == nil {
+		t.Errorf("expected a fallback storage")
+		return
+	}
+	if fallback.Table != "CertMagicFallback" || fallback.FallbackTable != "" {
+		t.Errorf("fallback should use the fallback table only, got: %s, %s", fallback.Table, fallback.FallbackTable)
+	}
+	if fallback.Compression != "gzip" || fallback.KeyEncoding != "urlencode" {
+		t.Errorf("fallback should lay out items like the primary, got: %s, %s", fallback.Compression, fallback.KeyEncoding)
+	}
+	if fallback.cache != nil || fallback.OnStore != nil {
+		t.Errorf("fallback should have no cache or hooks, got: %v, %v", fallback.cache, fallback.OnStore != nil)
+	}
+	if fallback.AwsSession == storage.AwsSession {
+		t.Errorf("fallback should have a session of its own")
+	}
+}
+
+func TestDynamoDBStorage_FallbackToEventualOnThrottle(t *testing.T) {
+	for _, fallback := range []bool{false, true} {
+		var reads []bool
+		storage := Storage{
+			Table: TestTableName,
+			AwsSession: newMockSession(func(r *request.Request) {
+				input := r.Params.(*dynamodb.GetItemInput)
+				reads = append(reads, aws.BoolValue(input.ConsistentRead))
+				if aws.BoolValue(input.ConsistentRead) {
+					r.HTTPResponse.StatusCode = http.StatusBadRequest
+					r.Error = awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil)
+					return
+				}
+				r.Data.(*dynamodb.GetItemOutput).Item = mockItem("key", "value")
+			}),
+			FallbackToEventualOnThrottle: fallback,
+		}
+
+		contents, err := storage.Load(context.Background(), "key")
+		if !fallback {
+			if !isThrottled(err) {
+				t.Errorf("expected the throttling error without the fallback, got: %v", err)
+			}
+			if !reflect.DeepEqual(reads, []bool{true}) {
+				t.Errorf("expected a single consistent read, got: %v", reads)
+			}
+			continue
+		}
+		if err != nil || string(contents) != "value" {
+			t.Errorf("expected the eventually consistent read to succeed, got: %q, %v", contents, err)
+		}
+		if !reflect.DeepEqual(reads, []bool{true, false}) {
+			t.Errorf("expected a consistent read followed by an eventually consistent one, got: %v", reads)
+		}
+	}
+}
+
+func TestDynamoDBStorage_ErrThrottled(t *testing.T) {
+	code := dynamodb.ErrCodeProvisionedThroughputExceededException
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			r.HTTPResponse.StatusCode = http.StatusBadRequest
+			r.Error = awserr.New(code, "throttled", nil)
+		}),
+	}
+	ctx := context.Background()
+
+	_, err := storage.Load(ctx, "key")
+	if !errors.Is(err, ErrThrottled) {
+		t.Errorf("expected a throttled read to return ErrThrottled, got: %v", err)
+	}
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != code {
+		t.Errorf("expected the AWS error to be kept, got: %v", err)
+	}
+
+	code = dynamodb.ErrCodeRequestLimitExceeded
+	if err := storage.Store(ctx, "key", []byte("value")); !errors.Is(err, ErrThrottled) {
+		t.Errorf("expected a throttled write to return ErrThrottled, got: %v", err)
+	}
+	if _, err := storage.List(ctx, "key", true); !errors.Is(err, ErrThrottled) {
+		t.Errorf("expected a throttled scan to return ErrThrottled, got: %v", err)
+	}
+
+	code = dynamodb.ErrCodeInternalServerError
+	if _, err := storage.Load(ctx, "key"); err == nil || errors.Is(err, ErrThrottled) {
+		t.Errorf("expected other errors not to be ErrThrottled, got: %v", err)
+	}
+}
+
+func TestDynamoDBStorage_MaxAttempts(t *testing.T) {
+	var code string
+	var attempts int
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			attempts++
+			r.HTTPResponse.StatusCode = http.StatusBadRequest
+			if code == dynamodb.ErrCodeInternalServerError {
+				r.HTTPResponse.StatusCode = http.StatusInternalServerError
+			}
+			r.Error = awserr.New(code, "failed", nil)
+		}),
+		MaxAttempts: 2,
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		code     string
+		attempts int
+	}{
+		{code: "ValidationException", attempts: 1},
+		{code: dynamodb.ErrCodeConditionalCheckFailedException, attempts: 1},
+		{code: dynamodb.ErrCodeProvisionedThroughputExceededException, attempts: 2},
+		{code: dynamodb.ErrCodeInternalServerError, attempts: 2},
+	}
+	for _, tt := range tests {
+		code, attempts = tt.code, 0
+		if err := storage.Store(ctx, "key", []byte("value")); err == nil {
+			t.Errorf("expected %s to fail the write", tt.code)
+		}
+		if attempts != tt.attempts {
+			t.Errorf("expected %d attempts for %s, got: %d", tt.attempts, tt.code, attempts)
+		}
+	}
+
+	storage = Storage{Table: TestTableName, AwsRegion: "us-east-1", MaxAttempts: -1}
+	if err := storage.initConfig(); err == nil {
+		t.Errorf("expected a config error for a negative MaxAttempts")
+	}
+}
+
+func TestDynamoDBStorage_DynamoClient(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	svc, err := storage.DynamoClient()
+	if err != nil {
+		t.Errorf("failed to get client: %s", err.Error())
+		return
+	}
+	if svc == nil {
+		t.Errorf("expected a client")
+		return
+	}
+
+	// the client reaches the same table
+	if err := storage.Store(context.Background(), "key", []byte("value")); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+		return
+	}
+	result, err := svc.GetItem(&dynamodb.GetItemInput{
+		Key:       storage.itemKey("key"),
+		TableName: aws.String(storage.Table),
+	})
+	if err != nil || len(result.Item) == 0 {
+		t.Errorf("expected the client to read the stored item, got: %v, %v", result, err)
+	}
+
+	if _, err := (&Storage{}).DynamoClient(); !errors.Is(err, ErrTableRequired) {
+		t.Errorf("expected ErrTableRequired without a table, got: %v", err)
+	}
+}
+
+func TestDynamoDBStorage_ExistsMany(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+
+	for _, key := range []string{"cert", "key"} {
+		if err := storage.Store(context.Background(), key, []byte(key)); err != nil {
+			t.Errorf("failed to store: %s", err.Error())
+			return
+		}
+	}
+
+	exists, err := storage.ExistsMany(context.Background(), []string{"cert", "key", "missing", "cert"})
+	if err != nil {
+		t.Errorf("failed to check existence: %s", err.Error())
+		return
+	}
+	want := map[string]bool{"cert": true, "key": true, "missing": false}
+	if !reflect.DeepEqual(exists, want) {
+		t.Errorf("existence does not match, expected: %v, got: %v", want, exists)
+	}
+
+	if _, err := storage.ExistsMany(context.Background(), []string{"cert", ""}); !errors.Is(err, ErrEmptyKey) {
+		t.Errorf("expected ErrEmptyKey, got: %v", err)
+	}
+}
+
+func TestDynamoDBStorage_ExistsManyBatches(t *testing.T) {
+	var requests int
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			input := r.Params.(*dynamodb.BatchGetItemInput)
+			out := r.Data.(*dynamodb.BatchGetItemOutput)
+			requests++
+
+			batch := input.RequestItems[TestTableName]
+			if len(batch.Keys) > 100 {
+				r.Error = awserr.New("ValidationException", "too many keys", nil)
+				return
+			}
+			keys := batch.Keys
+			// leave the last key of the first request unprocessed
+			if requests == 1 {
+				keys = keys[:len(keys)-1]
+				out.UnprocessedKeys = map[string]*dynamodb.KeysAndAttributes{
+					TestTableName: {Keys: batch.Keys[len(batch.Keys)-1:]},
+				}
+			}
+			out.Responses = map[string][]map[string]*dynamodb.AttributeValue{TestTableName: keys}
+		}),
+	}
+
+	keys := make([]string, 150)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%03d", i)
+	}
+	exists, err := storage.ExistsMany(context.Background(), keys)
+	if err != nil {
+		t.Errorf("failed to check existence: %s", err.Error())
+		return
+	}
+	for _, key := range keys {
+		if !exists[key] {
+			t.Errorf("expected %s to exist", key)
+		}
+	}
+	if requests != 3 {
+		t.Errorf("expected two batches and a retry of the unprocessed key, got %d requests", requests)
+	}
+}
+
+func TestDynamoDBStorage_ExistsManyUnprocessed(t *testing.T) {
+	for _, consistent := range []bool{false, true} {
+		var requests int
+		storage := Storage{
+			Table: TestTableName,
+			AwsSession: newMockSession(func(r *request.Request) {
+				batch := r.Params.(*dynamodb.BatchGetItemInput).RequestItems[TestTableName]
+				requests++
+				if aws.BoolValue(batch.ConsistentRead) != consistent {
+					t.Errorf("with ExistsConsistentRead %v, got a read with ConsistentRead %v", consistent, aws.BoolValue(batch.ConsistentRead))
+				}
+				// as if throttled for good
+				r.Data.(*dynamodb.BatchGetItemOutput).UnprocessedKeys = map[string]*dynamodb.KeysAndAttributes{
+					TestTableName: batch,
+				}
+			}),
+			ExistsConsistentRead: consistent,
+			MaxAttempts:          3,
+		}
+
+		_, err := storage.ExistsMany(context.Background(), []string{"cert", "key"})
+		if !errors.Is(err, ErrThrottled) {
+			t.Errorf("expected ErrThrottled once the attempts are used up, got: %v", err)
+		}
+		if requests != 3 {
+			t.Errorf("expected MaxAttempts requests, got: %d", requests)
+		}
+	}
+}
+
+func TestDynamoDBStorage_StoreWithMeta(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+
+	meta := map[string]string{"issuer": "acme-v02.api.letsencrypt.org", "ari_window": "2024-01-01T00:00:00Z"}
+	if err := storage.StoreWithMeta(context.Background(), "cert", []byte("cert"), meta); err != nil {
+		t.Errorf("failed to store with metadata: %s", err.Error())
+		return
+	}
+	if err := storage.Store(context.Background(), "plain", []byte("plain")); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+		return
+	}
+
+	value, loadedMeta, err := storage.LoadWithMeta(context.Background(), "cert")
+	if err != nil {
+		t.Errorf("failed to load with metadata: %s", err.Error())
+		return
+	}
+	if string(value) != "cert" {
+		t.Errorf("value does not match, got: %s", value)
+	}
+	if !reflect.DeepEqual(loadedMeta, meta) {
+		t.Errorf("metadata does not match, expected: %v, got: %v", meta, loadedMeta)
+	}
+
+	// plain Load ignores the metadata
+	value, err = storage.Load(context.Background(), "cert")
+	if err != nil || string(value) != "cert" {
+		t.Errorf("Load should return just the value, got: %s, %v", value, err)
+	}
+
+	value, loadedMeta, err = storage.LoadWithMeta(context.Background(), "plain")
+	if err != nil {
+		t.Errorf("failed to load with metadata: %s", err.Error())
+		return
+	}
+	if string(value) != "plain" || loadedMeta != nil {
+		t.Errorf("expected the value without metadata, got: %s, %v", value, loadedMeta)
+	}
+
+	// storing without metadata drops it
+	if err := storage.Store(context.Background(), "cert", []byte("cert")); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+		return
+	}
+	if _, loadedMeta, _ = storage.LoadWithMeta(context.Background(), "cert"); loadedMeta != nil {
+		t.Errorf("Store should replace the metadata, got: %v", loadedMeta)
+	}
+}
+
+func TestDynamoDBStorage_Tenants(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tenant := func(name string) *Storage {
+		return &Storage{
+			Table:         TestTableName,
+			AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+			AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+			AwsDisableSSL: DisableSSL,
+			Tenant:        name,
+		}
+	}
+	a, b := tenant("a"), tenant("b")
+
+	for _, storage := range []*Storage{a, b} {
+		for _, key := range []string{"certificates/one", "certificates/" + storage.Tenant} {
+			if err := storage.Store(context.Background(), key, []byte(storage.Tenant)); err != nil {
+				t.Errorf("failed to store %s for tenant %s: %s", key, storage.Tenant, err.Error())
+				return
+			}
+		}
+	}
+
+	for _, storage := range []*Storage{a, b} {
+		value, err := storage.Load(context.Background(), "certificates/one")
+		if err != nil {
+			t.Errorf("failed to load for tenant %s: %s", storage.Tenant, err.Error())
+			continue
+		}
+		if string(value) != storage.Tenant {
+			t.Errorf("tenant %s loaded another tenant's value: %s", storage.Tenant, value)
+		}
+
+		keys, err := storage.List(context.Background(), "certificates", true)
+		if err != nil {
+			t.Errorf("failed to list for tenant %s: %s", storage.Tenant, err.Error())
+			continue
+		}
+		sort.Strings(keys)
+		expected := []string{"certificates/" + storage.Tenant, "certificates/one"}
+		sort.Strings(expected)
+		if !reflect.DeepEqual(keys, expected) {
+			t.Errorf("tenant %s should only list its own keys, expected: %v, got: %v", storage.Tenant, expected, keys)
+		}
+	}
+
+	// the tenant is part of the partition key in the table
+	item, err := dynamodb.New(a.AwsSession).GetItem(&dynamodb.GetItemInput{
+		Key:       map[string]*dynamodb.AttributeValue{primaryKeyAttribute: {S: aws.String("a#certificates/one")}},
+		TableName: aws.String(TestTableName),
+	})
+	if err != nil || item.Item == nil {
+		t.Errorf("expected an item with the tenant in its partition key, got: %v, %v", item, err)
+	}
+}
+
+func TestDynamoDBStorage_PartitionKeyTemplate(t *testing.T) {
+	tests := []struct {
+		name      string
+		template  string
+		tenant    string
+		keyPrefix string
+		wantErr   bool
+	}{
+		{name: "default template", tenant: "a", keyPrefix: "a#"},
+		{name: "custom template", template: "TENANT#{tenant}#CERT#{key}", tenant: "a", keyPrefix: "TENANT#a#CERT#"},
+		{name: "static prefix", template: "certmagic/{key}", keyPrefix: "certmagic/"},
+		{name: "no tenant", template: "{tenant}/{key}", wantErr: true},
+		{name: "key not at the end", template: "{key}#{tenant}", tenant: "a", wantErr: true},
+		{name: "no key", template: "{tenant}", tenant: "a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Storage{
+				Table:                TestTableName,
+				AwsSession:           newMockSession(func(r *request.Request) {}),
+				PartitionKeyTemplate: tt.template,
+				Tenant:               tt.tenant,
+			}
+			err := s.initConfig()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("initConfig() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if s.keyPrefix != tt.keyPrefix {
+				t.Errorf("unexpected key prefix, expected: %q, got: %q", tt.keyPrefix, s.keyPrefix)
+			}
+		})
+	}
+}
+
+func TestDynamoDBStorage_DeleteIf(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+
+	if err := storage.Store(context.Background(), "key", []byte("value")); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+		return
+	}
+
+	deleted, err := storage.DeleteIf(context.Background(), "key", []byte("other value"))
+	if err != nil {
+		t.Errorf("failed to delete: %s", err.Error())
+		return
+	}
+	if deleted {
+		t.Errorf("key should not be deleted when its value doesn't match")
+	}
+	if !storage.Exists(context.Background(), "key") {
+		t.Errorf("key should still exist after a non-matching DeleteIf")
+	}
+
+	deleted, err = storage.DeleteIf(context.Background(), "key", []byte("value"))
+	if err != nil {
+		t.Errorf("failed to delete: %s", err.Error())
+		return
+	}
+	if !deleted {
+		t.Errorf("key should be deleted when its value matches")
+	}
+	if storage.Exists(context.Background(), "key") {
+		t.Errorf("key should not exist after a matching DeleteIf")
+	}
+
+	// a compressed value is matched by its hash
+	storage.Compression = "gzip"
+	value := bytes.Repeat([]byte("value"), 1000)
+	if err := storage.Store(context.Background(), "key", value); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+		return
+	}
+	deleted, err = storage.DeleteIf(context.Background(), "key", value)
+	if err != nil || !deleted {
+		t.Errorf("compressed key should be deleted when its value matches, got: %v, %v", deleted, err)
+	}
+}
+
+func TestDynamoDBStorage_Move(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	ctx := context.Background()
+
+	err = storage.StoreWithMeta(ctx, "old", []byte("value"), map[string]string{"issuer": "acme"})
+	if err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+		return
+	}
+	if err := storage.Store(ctx, "new", []byte("replaced")); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+		return
+	}
+
+	if err := storage.Move(ctx, "old", "new"); err != nil {
+		t.Errorf("failed to move: %s", err.Error())
+		return
+	}
+	if storage.Exists(ctx, "old") {
+		t.Errorf("old key should not exist after moving it")
+	}
+	value, meta, err := storage.LoadWithMeta(ctx, "new")
+	if err != nil || string(value) != "value" || meta["issuer"] != "acme" {
+		t.Errorf("expected the value and metadata at the new key, got: %q, %v, %v", value, meta, err)
+	}
+
+	err = storage.Move(ctx, "missing", "other")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist moving a missing key, got: %v", err)
+	}
+	if storage.Exists(ctx, "other") {
+		t.Errorf("nothing should be stored when the old key is missing")
+	}
+}
+
+func TestDynamoDBStorage_MovePreservesLastUpdated(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, preserve := range []bool{false, true} {
+		storage := Storage{
+			Table:                    TestTableName,
+			AwsEndpoint:              os.Getenv("AWS_ENDPOINT"),
+			AwsRegion:                os.Getenv("AWS_DEFAULT_REGION"),
+			AwsDisableSSL:            DisableSSL,
+			MovePreservesLastUpdated: preserve,
+		}
+		ctx := context.Background()
+		if err := storage.initConfig(); err != nil {
+			t.Errorf("failed to init config: %s", err.Error())
+			return
+		}
+
+		// stored an hour ago
+		item := storage.newItem("old", []byte("value"))
+		lastUpdated := time.Now().Add(-time.Hour).Truncate(time.Second)
+		item[lastUpdatedAttribute] = &dynamodb.AttributeValue{S: aws.String(lastUpdated.Format(time.RFC3339))}
+		_, err := storage.client().PutItemWithContext(ctx, &dynamodb.PutItemInput{Item: item, TableName: aws.String(TestTableName)})
+		if err != nil {
+			t.Errorf("failed to put: %s", err.Error())
+			return
+		}
+
+		if err := storage.Move(ctx, "old", "new"); err != nil {
+			t.Errorf("failed to move: %s", err.Error())
+			return
+		}
+		info, err := storage.Stat(ctx, "new")
+		if err != nil {
+			t.Errorf("failed to stat: %s", err.Error())
+			return
+		}
+		if preserved := info.Modified.Equal(lastUpdated); preserved != preserve {
+			t.Errorf("with MovePreservesLastUpdated %v, got modified time: %s", preserve, info.Modified)
+		}
+	}
+}
+
+func TestDynamoDBStorage_StoreTransaction(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+
+	err = storage.StoreTransaction(context.Background(), map[string][]byte{
+		"cert": []byte("cert"),
+		"meta": []byte("meta"),
+	})
+	if err != nil {
+		t.Errorf("failed to store transaction: %s", err.Error())
+		return
+	}
+	for _, key := range []string{"cert", "meta"} {
+		value, err := storage.Load(context.Background(), key)
+		if err != nil {
+			t.Errorf("failed to load %s: %s", key, err.Error())
+			continue
+		}
+		if string(value) != key {
+			t.Errorf("value of %s does not match, got: %s", key, value)
+		}
+	}
+
+	// force the transaction to fail by adding a condition on an item that doesn't exist
+	failing := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	if err := failing.initConfig(); err != nil {
+		t.Error(err)
+		return
+	}
+	failing.AwsSession.Handlers.Build.PushFront(func(r *request.Request) {
+		if input, ok := r.Params.(*dynamodb.TransactWriteItemsInput); ok {
+			input.TransactItems = append(input.TransactItems, &dynamodb.TransactWriteItem{
+				ConditionCheck: &dynamodb.ConditionCheck{
+					ConditionExpression:      aws.String("attribute_exists(#K)"),
+					ExpressionAttributeNames: map[string]*string{"#K": aws.String(primaryKeyAttribute)},
+					Key:                      failing.itemKey("missing"),
+					TableName:                aws.String(failing.Table),
+				},
+			})
+		}
+	})
+
+	err = failing.StoreTransaction(context.Background(), map[string][]byte{
+		"cert":  []byte("cert2"),
+		"chain": []byte("chain2"),
+	})
+	if err == nil {
+		t.Errorf("expected the transaction to fail")
+		return
+	}
+	if value, err := storage.Load(context.Background(), "cert"); err != nil || string(value) != "cert" {
+		t.Errorf("cert should be unchanged after a failed transaction, got: %s, %v", value, err)
+	}
+	if storage.Exists(context.Background(), "chain") {
+		t.Errorf("chain should not be stored by a failed transaction")
+	}
+
+	tooMany := make(map[string][]byte)
+	for i := 0; i <= maxTransactionItems; i++ {
+		tooMany[fmt.Sprintf("key%d", i)] = []byte("value")
+	}
+	if err := storage.StoreTransaction(context.Background(), tooMany); err == nil {
+		t.Errorf("expected an error storing more than %d items in a transaction", maxTransactionItems)
+	}
+}
+
+func TestDynamoDBStorage_StoreTransactionEmpty(t *testing.T) {
+	var requests []string
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			requests = append(requests, r.Operation.Name)
+			r.HTTPResponse.StatusCode = http.StatusBadRequest
+			r.Error = awserr.New("ValidationException", "transactions must have at least one item", nil)
+		}),
+	}
+
+	for _, items := range []map[string][]byte{nil, {}} {
+		if err := storage.StoreTransaction(context.Background(), items); err != nil {
+			t.Errorf("expected no error storing no items, got: %v", err)
+		}
+	}
+	if len(requests) != 0 {
+		t.Errorf("expected no requests for an empty transaction, got: %v", requests)
+	}
+}
+
+func TestDynamoDBStorage_StoreIfVersion(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+
+	// first write
+	version, err := storage.StoreIfVersion(context.Background(), "key", []byte("value1"), 0)
+	if err != nil {
+		t.Errorf("first versioned store failed: %s", err.Error())
+		return
+	}
+	if version != 1 {
+		t.Errorf("first versioned store should return version 1, got: %v", version)
+		return
+	}
+
+	// matching version
+	version, err = storage.StoreIfVersion(context.Background(), "key", []byte("value2"), version)
+	if err != nil {
+		t.Errorf("versioned store with matching version failed: %s", err.Error())
+		return
+	}
+	if version != 2 {
+		t.Errorf("versioned store should return version 2, got: %v", version)
+		return
+	}
+
+	// conflicting versions
+	for _, expected := range []int64{0, 1, 3} {
+		_, err = storage.StoreIfVersion(context.Background(), "key", []byte("conflict"), expected)
+		var conflict *VersionConflictError
+		if !errors.As(err, &conflict) {
+			t.Errorf("versioned store with expected version %v should conflict, got: %v", expected, err)
+			return
+		}
+		if conflict.Key != "key" || conflict.ExpectedVersion != expected {
+			t.Errorf("conflict error has unexpected fields: %+v", conflict)
+			return
+		}
+	}
+
+	value, err := storage.Load(context.Background(), "key")
+	if err != nil {
+		t.Errorf("unable to load key: %s", err.Error())
+		return
+	}
+	if string(value) != "value2" {
+		t.Errorf("value returned does not match expected. expected: %s, got: %s", "value2", string(value))
+	}
+}
+
+func TestDynamoDBStorage_List(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+
+	prefix := "domain"
+
+	fixturesWithPrefix := map[string]string{
+		"domain1": "cert1",
+		"domain2": "cert2",
+		"domain3": "cert3",
+	}
+	for k, v := range fixturesWithPrefix {
+		err := storage.Store(context.Background(), k, []byte(v))
+		if err != nil {
+			t.Errorf("failed to store fixture %s, error: %s", k, err.Error())
+			return
+		}
+	}
+
+	fixtures := map[string]string{
+		"notinlist":        "cert4",
+		"anothernotinlist": "cert5",
+	}
+	for k, v := range fixtures {
+		err := storage.Store(context.Background(), k, []byte(v))
+		if err != nil {
+			t.Errorf("failed to store fixture %s, error: %s", k, err.Error())
+			return
+		}
+	}
+
+	foundKeys, err := storage.List(context.Background(), prefix, false)
+	if err != nil {
+		t.Errorf("failed to list keys: %s", err.Error())
+		return
+	}
+
+	if len(foundKeys) != len(fixturesWithPrefix) {
+		t.Errorf("did not get back expected number of keys, expected: %v, got: %v",
+			len(fixturesWithPrefix), len(foundKeys))
+		return
+	}
+
+	noKeysFound, err := storage.List(context.Background(), "invalid", false)
+	if err != nil {
+		t.Errorf("unable to list keys with invalid prefix: %s", err.Error())
+		return
+	}
+
+	if len(noKeysFound) != 0 {
+		t.Errorf("should not have found any keys but found %v key", len(noKeysFound))
+		return
+	}
+}
+
+func TestDynamoDBStorage_ListFuncStops(t *testing.T) {
+	var scans int
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			scans++
+			out := r.Data.(*dynamodb.ScanOutput)
+			out.Items = []map[string]*dynamodb.AttributeValue{
+				mockItem(fmt.Sprintf("key%d-a", scans), "value"),
+				mockItem(fmt.Sprintf("key%d-b", scans), "value"),
+			}
+			if scans < 20 {
+				out.LastEvaluatedKey = mockItem(fmt.Sprintf("key%d-b", scans), "")
+			}
+		}),
+	}
+
+	errStop := errors.New("stop")
+	var keys []string
+	err := storage.ListFunc(context.Background(), "key", func(key string) error {
+		keys = append(keys, key)
+		if len(keys) == 3 {
+			return errStop
+		}
+		return nil
+	})
+
+	if !errors.Is(err, errStop) {
+		t.Errorf("ListFunc should return the error from fn, got: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"key1-a", "key1-b", "key2-a"}) {
+		t.Errorf("fn should not be called after it errors, called with: %v", keys)
+	}
+	// the scan may have got a few pages ahead
+	if scans > 3+listBuffer {
+		t.Errorf("scan should stop once fn errors, scanned %d pages", scans)
+	}
+}
+
+func TestDynamoDBStorage_SortListResults(t *testing.T) {
+	for _, sorted := range []bool{false, true} {
+		storage := Storage{
+			Table: TestTableName,
+			AwsSession: newMockSession(func(r *request.Request) {
+				r.Data.(*dynamodb.ScanOutput).Items = []map[string]*dynamodb.AttributeValue{
+					mockItem("key2", "value"),
+					mockItem("key10", "value"),
+					mockItem("key1", "value"),
+				}
+			}),
+			SortListResults: sorted,
+		}
+
+		keys, err := storage.List(context.Background(), "key", true)
+		if err != nil {
+			t.Errorf("failed to list: %s", err.Error())
+			continue
+		}
+		expected := []string{"key2", "key10", "key1"}
+		if sorted {
+			expected = []string{"key1", "key10", "key2"}
+		}
+		if !reflect.DeepEqual(keys, expected) {
+			t.Errorf("with SortListResults %v, expected: %q, got: %q", sorted, expected, keys)
+		}
+	}
+}
+
+func TestDynamoDBStorage_ExtraListFilter(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:           TestTableName,
+		AwsEndpoint:     os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:       os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:   DisableSSL,
+		ExtraListFilter: &ListFilter{Attribute: "Team", Value: "blue"},
+	}
+	if err := storage.initConfig(); err != nil {
+		t.Errorf("initConfig() error = %v", err)
+		return
+	}
+	ctx := context.Background()
+
+	// tagged the way another process sharing the table would
+	for key, team := range map[string]string{"certs/blue": "blue", "certs/red": "red", "certs/none": ""} {
+		item := storage.newItem(key, []byte("value"))
+		if team != "" {
+			item["Team"] = &dynamodb.AttributeValue{S: aws.String(team)}
+		}
+		_, err := storage.client().PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(TestTableName),
+			Item:      item,
+		})
+		if err != nil {
+			t.Errorf("failed to put %s: %s", key, err.Error())
+			return
+		}
+	}
+
+	keys, err := storage.List(ctx, "certs", true)
+	if err != nil || !reflect.DeepEqual(keys, []string{"certs/blue"}) {
+		t.Errorf("expected only the matching key to be listed, got: %v, %v", keys, err)
+	}
+	keys, _, err = storage.ListPaged(ctx, "certs", 10, "")
+	if err != nil || !reflect.DeepEqual(keys, []string{"certs/blue"}) {
+		t.Errorf("expected only the matching key to be listed by page, got: %v, %v", keys, err)
+	}
+
+	for _, filter := range []*ListFilter{{Attribute: "Team"}, {Value: "blue"}, {Attribute: primaryKeyAttribute, Value: "blue"}} {
+		storage := Storage{Table: TestTableName, AwsSession: newMockSession(func(r *request.Request) {}), ExtraListFilter: filter}
+		if err := storage.initConfig(); err == nil {
+			t.Errorf("expected an error for the invalid filter %+v", filter)
+		}
+	}
+}
+
+func TestDynamoDBStorage_ListSkipsItemsWithoutKey(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			r.Data.(*dynamodb.ScanOutput).Items = []map[string]*dynamodb.AttributeValue{
+				mockItem("key1", "value"),
+				// as stored by another application sharing the table
+				{"pk": {S: aws.String("key2")}, "data": {S: aws.String("value")}},
+				{primaryKeyAttribute: {N: aws.String("3")}},
+				mockItem("key4", "value"),
+			}
+		}),
+		logger: zap.New(core),
+	}
+
+	keys, err := storage.List(context.Background(), "key", true)
+	if err != nil {
+		t.Errorf("failed to list: %s", err.Error())
+		return
+	}
+	if !reflect.DeepEqual(keys, []string{"key1", "key4"}) {
+		t.Errorf("items without a key should be skipped, got: %q", keys)
+	}
+	if skipped := logs.FilterMessageSnippet("skipping item without a key").Len(); skipped != 2 {
+		t.Errorf("expected a debug message for each skipped item, got %d", skipped)
+	}
+}
+
+func TestDynamoDBStorage_ListRetriesThrottledPage(t *testing.T) {
+	var scans int
+	sess := newMockSession(func(r *request.Request) {
+		scans++
+		if scans == 2 {
+			r.HTTPResponse.StatusCode = http.StatusBadRequest
+			r.Error = awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil)
+			return
+		}
+		out := r.Data.(*dynamodb.ScanOutput)
+		out.Items = []map[string]*dynamodb.AttributeValue{mockItem(fmt.Sprintf("key%d", scans), "value")}
+		if scans < 3 {
+			out.LastEvaluatedKey = mockItem(fmt.Sprintf("key%d", scans), "")
+		}
+	})
+	// the mock session disables retries, turn them back on like the SDK's default
+	sess.Config.MaxRetries = aws.Int(1)
+	storage := Storage{Table: TestTableName, AwsSession: sess}
+
+	keys, err := storage.List(context.Background(), "key", true)
+	if err != nil {
+		t.Errorf("a throttled page should be retried, got: %s", err.Error())
+		return
+	}
+	if !reflect.DeepEqual(keys, []string{"key1", "key3"}) {
+		t.Errorf("list should include every page, got: %v", keys)
+	}
+}
+
+func TestDynamoDBStorage_ListConsistentRead(t *testing.T) {
+	for _, consistent := range []bool{false, true} {
+		var consistentRead *bool
+		storage := Storage{
+			Table: TestTableName,
+			AwsSession: newMockSession(func(r *request.Request) {
+				consistentRead = r.Params.(*dynamodb.ScanInput).ConsistentRead
+			}),
+			ListConsistentRead: consistent,
+		}
+
+		if _, err := storage.List(context.Background(), "key", true); err != nil {
+			t.Errorf("failed to list: %s", err.Error())
+			continue
+		}
+		if consistentRead == nil || *consistentRead != consistent {
+			t.Errorf("scan should use ConsistentRead %v, got: %v", consistent, aws.BoolValue(consistentRead))
+		}
+	}
+}
+
+func TestDynamoDBStorage_ScanParallelism(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	serial := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("domain%d", i)
+		if err := serial.Store(context.Background(), key, []byte(key)); err != nil {
+			t.Errorf("failed to store %s: %s", key, err.Error())
+			return
+		}
+	}
+
+	parallel := Storage{
+		Table:           TestTableName,
+		AwsEndpoint:     os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:       os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:   DisableSSL,
+		ScanParallelism: 4,
+	}
+
+	serialKeys, err := serial.List(context.Background(), "domain", true)
+	if err != nil {
+		t.Errorf("failed to list serially: %s", err.Error())
+		return
+	}
+	parallelKeys, err := parallel.List(context.Background(), "domain", true)
+	if err != nil {
+		t.Errorf("failed to list in parallel: %s", err.Error())
+		return
+	}
+	sort.Strings(serialKeys)
+	sort.Strings(parallelKeys)
+	if len(serialKeys) != 50 || !reflect.DeepEqual(serialKeys, parallelKeys) {
+		t.Errorf("parallel scan should list the same keys, serial: %v, parallel: %v", serialKeys, parallelKeys)
+	}
+
+	var serialExport, parallelExport bytes.Buffer
+	if err := serial.Export(context.Background(), &serialExport); err != nil {
+		t.Errorf("failed to export serially: %s", err.Error())
+		return
+	}
+	if err := parallel.Export(context.Background(), &parallelExport); err != nil {
+		t.Errorf("failed to export in parallel: %s", err.Error())
+		return
+	}
+	serialLines := strings.Split(strings.TrimSpace(serialExport.String()), "\n")
+	parallelLines := strings.Split(strings.TrimSpace(parallelExport.String()), "\n")
+	sort.Strings(serialLines)
+	sort.Strings(parallelLines)
+	if !reflect.DeepEqual(serialLines, parallelLines) {
+		t.Errorf("parallel export should write the same entries, serial: %d, parallel: %d", len(serialLines), len(parallelLines))
+	}
+
+	invalid := Storage{Table: TestTableName, ScanParallelism: maxScanParallelism + 1}
+	if err := invalid.initConfig(); err == nil {
+		t.Errorf("expected an error for a scan parallelism above %d", maxScanParallelism)
+	}
+}
+
+func TestDynamoDBStorage_ScanParallelismSegmentFails(t *testing.T) {
+	var mu sync.Mutex
+	var segments []int64
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			input := r.Params.(*dynamodb.ScanInput)
+			mu.Lock()
+			segments = append(segments, aws.Int64Value(input.Segment))
+			mu.Unlock()
+			if aws.Int64Value(input.TotalSegments) != 3 {
+				t.Errorf("expected 3 segments, got: %v", input.TotalSegments)
+			}
+			if aws.Int64Value(input.Segment) == 1 {
+				r.HTTPResponse.StatusCode = http.StatusBadRequest
+				r.Error = awserr.New(dynamodb.ErrCodeResourceNotFoundException, "not found", nil)
+			}
+		}),
+		ScanParallelism: 3,
+	}
+
+	_, err := storage.List(context.Background(), "key", true)
+	if !isResourceNotFound(err) {
+		t.Errorf("expected the failing segment's error, got: %v", err)
+	}
+	// the other segments may be canceled before they're sent
+	scanned := map[int64]bool{}
+	for _, segment := range segments {
+		if scanned[segment] || segment < 0 || segment > 2 {
+			t.Errorf("expected each segment to be scanned at most once, got: %v", segments)
+			break
+		}
+		scanned[segment] = true
+	}
+}
+
+func TestDynamoDBStorage_ListPaged(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	ctx := context.Background()
+
+	expected := map[string]bool{}
+	for i := 0; i < 25; i++ {
+		key := fmt.Sprintf("paged/key%02d", i)
+		expected[key] = true
+		if err := storage.Store(ctx, key, []byte("value")); err != nil {
+			t.Errorf("error storing: %s", err.Error())
+			return
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if err := storage.Store(ctx, fmt.Sprintf("other/key%02d", i), []byte("value")); err != nil {
+			t.Errorf("error storing: %s", err.Error())
+			return
+		}
+	}
+
+	found := map[string]bool{}
+	token := ""
+	for pages := 1; ; pages++ {
+		if pages > 10 {
+			t.Errorf("too many pages, the token isn't advancing")
+			return
+		}
+
+		var keys []string
+		keys, token, err = storage.ListPaged(ctx, "paged/", 7, token)
+		if err != nil {
+			t.Errorf("error listing page %d: %s", pages, err.Error())
+			return
+		}
+		if len(keys) > 7 {
+			t.Errorf("page %d has more keys than the page size: %v", pages, keys)
+		}
+		for _, key := range keys {
+			if found[key] {
+				t.Errorf("key %s listed more than once", key)
+			}
+			found[key] = true
+		}
+		if token == "" {
+			break
+		}
+	}
+
+	if !reflect.DeepEqual(found, expected) {
+		t.Errorf("listed keys do not match, expected: %v, got: %v", expected, found)
+	}
+
+	if _, _, err := storage.ListPaged(ctx, "paged/", 7, "not a token"); err == nil {
+		t.Errorf("an invalid token should error")
+	}
+}
+
+func TestDynamoDBStorage_Stat(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+
+	err = storage.Store(context.Background(), "key", []byte("value"))
+	if err != nil {
+		t.Errorf("failed to store fixture key/value: %s", err.Error())
+		return
+	}
+
+	stat, err := storage.Stat(context.Background(), "key")
+	if err != nil {
+		t.Errorf("failed to stat item: %s", err.Error())
+		return
+	}
+
+	if stat.Key != "key" {
+		t.Errorf("stat key does not match expected. got: %s", stat.Key)
+		return
+	}
+	if stat.Size != int64(len("value")) {
+		t.Errorf("stat size does not match expected. got: %v", stat.Size)
+		return
+	}
+	if time.Since(stat.Modified) > 5*time.Second {
+		t.Errorf("stat modified time is not within 3 seoncds. got: %s", stat.Modified)
+		return
+	}
+}
+
+func TestDynamoDBStorage_StatProjection(t *testing.T) {
+	modified := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var projection string
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			input := r.Params.(*dynamodb.GetItemInput)
+			projection = aws.StringValue(input.ProjectionExpression)
+			for _, name := range input.ExpressionAttributeNames {
+				if aws.StringValue(name) == contentsAttribute {
+					t.Errorf("Stat should not request the contents")
+				}
+			}
+			r.Data.(*dynamodb.GetItemOutput).Item = map[string]*dynamodb.AttributeValue{
+				lastUpdatedAttribute:   {S: aws.String(modified.Format(time.RFC3339))},
+				contentLengthAttribute: {N: aws.String("12345")},
+			}
+		}),
+	}
+
+	stat, err := storage.Stat(context.Background(), "key")
+	if err != nil {
+		t.Errorf("failed to stat item: %s", err.Error())
+		return
+	}
+	if projection == "" {
+		t.Errorf("Stat should use a projection expression")
+	}
+	if stat.Size != 12345 {
+		t.Errorf("stat size should come from %s, got: %v", contentLengthAttribute, stat.Size)
+	}
+	if !stat.Modified.Equal(modified) {
+		t.Errorf("stat modified time does not match, expected: %s, got: %s", modified, stat.Modified)
+	}
+}
+
+func TestDynamoDBStorage_ContentLength(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+
+	value := []byte("a value that grows once base64 encoded")
+	err = storage.Store(context.Background(), "key", value)
+	if err != nil {
+		t.Errorf("failed to store fixture key/value: %s", err.Error())
+		return
+	}
+
+	result, err := dynamodb.New(storage.AwsSession).GetItem(&dynamodb.GetItemInput{
+		Key:       storage.itemKey("key"),
+		TableName: aws.String(storage.Table),
+	})
+	if err != nil {
+		t.Errorf("error getting raw item: %s", err.Error())
+		return
+	}
+	if length := result.Item[contentLengthAttribute]; length == nil || aws.StringValue(length.N) != strconv.Itoa(len(value)) {
+		t.Errorf("%s should be the length of the original value %d, got: %v", contentLengthAttribute, len(value), length)
+	}
+
+	stat, err := storage.Stat(context.Background(), "key")
+	if err != nil {
+		t.Errorf("failed to stat item: %s", err.Error())
+		return
+	}
+	if stat.Size != int64(len(value)) {
+		t.Errorf("stat size does not match expected. got: %v", stat.Size)
+	}
+}
+
+// expiringProvider hands out credentials that expire shortly after they
+// are retrieved, like those of an assumed role near the end of their life
+type expiringProvider struct {
+	credentials.Expiry
+	retrieved int
+}
+
+func (p *expiringProvider) Retrieve() (credentials.Value, error) {
+	p.retrieved++
+	p.SetExpiration(time.Now().Add(200*time.Millisecond), 100*time.Millisecond)
+	return credentials.Value{AccessKeyID: "abc123", SecretAccessKey: "abc123"}, nil
+}
+
+func TestDynamoDBStorage_CredentialsRefresh(t *testing.T) {
+	provider := &expiringProvider{}
+	sess := newMockSession(func(r *request.Request) {
+		r.Data.(*dynamodb.GetItemOutput).Item = mockItem("key", "value")
+	})
+	sess.Config.Credentials = credentials.NewCredentials(provider)
+	storage := Storage{Table: TestTableName, AwsSession: sess}
+
+	for i := 0; i < 2; i++ {
+		if _, err := storage.Load(context.Background(), "key"); err != nil {
+			t.Errorf("failed to load: %s", err.Error())
+			return
+		}
+	}
+	if provider.retrieved != 1 {
+		t.Errorf("credentials should be reused until they near expiry, retrieved %d times", provider.retrieved)
+	}
+
+	// wait until the credentials are within their expiry window
+	time.Sleep(150 * time.Millisecond)
+	if _, err := storage.Load(context.Background(), "key"); err != nil {
+		t.Errorf("failed to load: %s", err.Error())
+		return
+	}
+	if provider.retrieved != 2 {
+		t.Errorf("credentials should be refreshed before they expire, retrieved %d times", provider.retrieved)
+	}
+}
+
+func TestDynamoDBStorage_TrackConsumedCapacity(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			consumed := &dynamodb.ConsumedCapacity{TableName: aws.String(TestTableName)}
+			switch input := r.Params.(type) {
+			case *dynamodb.PutItemInput:
+				if aws.StringValue(input.ReturnConsumedCapacity) != dynamodb.ReturnConsumedCapacityTotal {
+					t.Errorf("PutItem should ask for consumed capacity, got: %v", input.ReturnConsumedCapacity)
+				}
+				consumed.CapacityUnits = aws.Float64(1)
+				r.Data.(*dynamodb.PutItemOutput).ConsumedCapacity = consumed
+			case *dynamodb.GetItemInput:
+				if aws.StringValue(input.ReturnConsumedCapacity) != dynamodb.ReturnConsumedCapacityTotal {
+					t.Errorf("GetItem should ask for consumed capacity, got: %v", input.ReturnConsumedCapacity)
+				}
+				consumed.CapacityUnits = aws.Float64(0.5)
+				out := r.Data.(*dynamodb.GetItemOutput)
+				out.Item = mockItem("key", "value")
+				out.ConsumedCapacity = consumed
+			}
+		}),
+		TrackConsumedCapacity: true,
+		logger:                zap.New(core),
+	}
+
+	if err := storage.Store(context.Background(), "key", []byte("value")); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+	}
+	if _, err := storage.Load(context.Background(), "key"); err != nil {
+		t.Errorf("failed to load: %s", err.Error())
+	}
+
+	expected := map[string]float64{"PutItem": 1, "GetItem": 0.5}
+	entries := logs.FilterMessage("consumed capacity").All()
+	if len(entries) != len(expected) {
+		t.Errorf("expected consumed capacity to be logged for each request, got: %v", logs.All())
+		return
+	}
+	for _, entry := range entries {
+		fields := entry.ContextMap()
+		operation := fields["operation"].(string)
+		if fields["capacity_units"] != expected[operation] {
+			t.Errorf("unexpected capacity logged for %s: %v", operation, fields["capacity_units"])
+		}
+	}
+}
+
+func TestDynamoDBStorage_SizeWarnThreshold(t *testing.T) {
+	core, logs := observer.New(zap.WarnLevel)
+	puts := 0
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			puts++
+		}),
+		SizeWarnThreshold: 1000,
+		logger:            zap.New(core),
+	}
+
+	if err := storage.Store(context.Background(), "small", make([]byte, 100)); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+	}
+	if logs.Len() != 0 {
+		t.Errorf("no warning expected below the threshold, got: %v", logs.All())
+	}
+
+	if err := storage.Store(context.Background(), "large", make([]byte, 1000)); err != nil {
+		t.Errorf("an item over the threshold should still be stored, got: %s", err.Error())
+	}
+	if puts != 2 {
+		t.Errorf("expected both items to be written, got %v PutItem calls", puts)
+	}
+	warnings := logs.FilterField(zap.String("key", "large")).All()
+	if len(warnings) != 1 {
+		t.Errorf("expected a warning for the large item, got: %v", logs.All())
+	}
+}
+
+func TestDynamoDBStorage_StatFutureModified(t *testing.T) {
+	tests := []struct {
+		name      string
+		ahead     time.Duration
+		tolerance time.Duration
+		wantWarn  bool
+	}{
+		{name: "in the past", ahead: -time.Hour},
+		{name: "in the future", ahead: time.Hour, wantWarn: true},
+		{name: "within the tolerance", ahead: time.Hour, tolerance: 2 * time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, logs := observer.New(zap.WarnLevel)
+			modified := time.Now().Add(tt.ahead).Truncate(time.Second)
+			storage := Storage{
+				Table: TestTableName,
+				AwsSession: newMockSession(func(r *request.Request) {
+					item := mockItem("key", "value")
+					item[lastUpdatedAttribute] = &dynamodb.AttributeValue{S: aws.String(modified.Format(time.RFC3339))}
+					item[contentLengthAttribute] = &dynamodb.AttributeValue{N: aws.String("5")}
+					r.Data.(*dynamodb.GetItemOutput).Item = item
+				}),
+				ClockSkewTolerance: caddy.Duration(tt.tolerance),
+				logger:             zap.New(core),
+			}
+
+			info, err := storage.Stat(context.Background(), "key")
+			if err != nil {
+				t.Errorf("failed to stat: %s", err.Error())
+				return
+			}
+			if tt.ahead > 0 && info.Modified.After(time.Now()) {
+				t.Errorf("modified time should be clamped to now, got: %s", info.Modified)
+			}
+			if tt.ahead < 0 && !info.Modified.Equal(modified) {
+				t.Errorf("modified time in the past should be kept, expected: %s, got: %s", modified, info.Modified)
+			}
+			if warned := logs.Len() > 0; warned != tt.wantWarn {
+				t.Errorf("expected a warning: %v, got: %v", tt.wantWarn, logs.All())
+			}
+		})
+	}
+}
+
+func TestDynamoDBStorage_CertExpiry(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Errorf("failed to generate key: %s", err.Error())
+		return
+	}
+	notAfter := time.Now().Add(90 * 24 * time.Hour).Truncate(time.Second).UTC()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Errorf("failed to create certificate: %s", err.Error())
+		return
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Errorf("failed to marshal key: %s", err.Error())
+		return
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	values := map[string][]byte{
+		"certificates/example.com/example.com.crt": certPEM,
+		"certificates/example.com/bundle.pem":      append(keyPEM, certPEM...),
+		"certificates/example.com/example.com.der": der,
+	}
+	for k, v := range values {
+		if err := storage.Store(context.Background(), k, v); err != nil {
+			t.Errorf("failed to store %s: %s", k, err.Error())
+			return
+		}
+		expiry, err := storage.CertExpiry(context.Background(), k)
+		if err != nil {
+			t.Errorf("failed to get the expiry of %s: %s", k, err.Error())
+			continue
+		}
+		if !expiry.Equal(notAfter) {
+			t.Errorf("expected %s to expire at %s, got: %s", k, notAfter, expiry)
+		}
+	}
+
+	notCerts := map[string][]byte{
+		"certificates/example.com/example.com.key":  keyPEM,
+		"certificates/example.com/example.com.json": []byte(`{"sans":["example.com"]}`),
+	}
+	for k, v := range notCerts {
+		if err := storage.Store(context.Background(), k, v); err != nil {
+			t.Errorf("failed to store %s: %s", k, err.Error())
+			return
+		}
+		if _, err := storage.CertExpiry(context.Background(), k); err == nil {
+			t.Errorf("expected an error for %s, which is not a certificate", k)
+		}
+	}
+
+	if _, err := storage.CertExpiry(context.Background(), "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist for a missing key, got: %v", err)
+	}
+}
+
+func TestDynamoDBStorage_LoadRange(t *testing.T) {
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			r.Data.(*dynamodb.GetItemOutput).Item = mockItem("key", "0123456789")
+		}),
+	}
+
+	tests := []struct {
+		name    string
+		offset  int64
+		length  int64
+		want    string
+		wantErr bool
+	}{
+		{name: "prefix", offset: 0, length: 4, want: "0123"},
+		{name: "middle", offset: 3, length: 2, want: "34"},
+		{name: "suffix", offset: 6, length: 4, want: "6789"},
+		{name: "empty at end", offset: 10, length: 0, want: ""},
+		{name: "past end", offset: 8, length: 3, wantErr: true},
+		{name: "offset past end", offset: 11, length: 0, wantErr: true},
+		{name: "negative offset", offset: -1, length: 2, wantErr: true},
+		{name: "negative length", offset: 2, length: -1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := storage.LoadRange(context.Background(), "key", tt.offset, tt.length)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadRange() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if string(got) != tt.want {
+				t.Errorf("LoadRange() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDynamoDBStorage_LoadReader(t *testing.T) {
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			if key := aws.StringValue(r.Params.(*dynamodb.GetItemInput).Key[primaryKeyAttribute].S); key == "key" {
+				r.Data.(*dynamodb.GetItemOutput).Item = mockItem("key", "0123456789")
+			}
+		}),
+	}
+
+	reader, err := storage.LoadReader(context.Background(), "key")
+	if err != nil {
+		t.Errorf("LoadReader() error = %v", err)
+		return
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, reader); err != nil || buf.String() != "0123456789" {
+		t.Errorf("expected to read the stored value, got: %q, %v", buf.String(), err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+
+	if _, err := storage.LoadReader(context.Background(), "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist for a missing key, got: %v", err)
+	}
+}
+
+func TestDynamoDBStorage_LoadContentLengthMismatch(t *testing.T) {
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			item := mockItem("key", "value")
+			item[contentLengthAttribute] = &dynamodb.AttributeValue{N: aws.String("3")}
+			r.Data.(*dynamodb.GetItemOutput).Item = item
+		}),
+	}
+
+	_, err := storage.Load(context.Background(), "key")
+	if err == nil || !strings.Contains(err.Error(), contentLengthAttribute) {
+		t.Errorf("loading contents that don't match their recorded length should error, got: %v", err)
+	}
+}
+
+func TestDynamoDBStorage_HashSalt(t *testing.T) {
+	value := []byte("-----BEGIN CERTIFICATE-----")
+	hashes := map[string]string{}
+	for _, salt := range []string{"", "tenant-a", "tenant-b"} {
+		var stored map[string]*dynamodb.AttributeValue
+		storage := Storage{
+			Table: TestTableName,
+			AwsSession: newMockSession(func(r *request.Request) {
+				switch r.Operation.Name {
+				case "PutItem":
+					stored = r.Params.(*dynamodb.PutItemInput).Item
+				case "GetItem":
+					r.Data.(*dynamodb.GetItemOutput).Item = stored
+				}
+			}),
+			HashSalt:        salt,
+			VerifyChecksums: true,
+		}
+
+		if err := storage.Store(context.Background(), "key", value); err != nil {
+			t.Errorf("failed to store: %s", err.Error())
+			return
+		}
+		hash := aws.StringValue(stored[contentHashAttribute].S)
+		if hash != storage.ContentHash(value) {
+			t.Errorf("expected the value to be stored with its ContentHash, got: %s", hash)
+		}
+		for other, otherHash := range hashes {
+			if hash == otherHash {
+				t.Errorf("expected salts %q and %q to hash identical content differently", salt, other)
+			}
+		}
+		hashes[salt] = hash
+
+		// the salted hash is what loaded values are verified against
+		if loaded, err := storage.Load(context.Background(), "key"); err != nil || !bytes.Equal(loaded, value) {
+			t.Errorf("expected to load the value with salt %q, got: %q, %v", salt, loaded, err)
+		}
+	}
+
+	sum := sha256.Sum256(value)
+	if hashes[""] != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected the plain SHA-256 hash without a salt, got: %s", hashes[""])
+	}
+	mac := hmac.New(sha256.New, []byte("tenant-a"))
+	mac.Write(value)
+	if hashes["tenant-a"] != hex.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("expected the HMAC-SHA256 hash with a salt, got: %s", hashes["tenant-a"])
+	}
+}
+
+func TestDynamoDBStorage_VerifyChecksums(t *testing.T) {
+	checksum := func(value string) *dynamodb.AttributeValue {
+		sum := sha256.Sum256([]byte(value))
+		return &dynamodb.AttributeValue{S: aws.String(hex.EncodeToString(sum[:]))}
+	}
+	tests := []struct {
+		name     string
+		checksum *dynamodb.AttributeValue
+		wantErr  bool
+	}{
+		{
+			name:     "matching checksum",
+			checksum: checksum("value"),
+		},
+		{
+			name:     "tampered contents",
+			checksum: checksum("original value"),
+			wantErr:  true,
+		},
+		{
+			name: "stored without a checksum",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := Storage{
+				Table: TestTableName,
+				AwsSession: newMockSession(func(r *request.Request) {
+					item := mockItem("key", "value")
+					if tt.checksum != nil {
+						item[contentHashAttribute] = tt.checksum
+					}
+					r.Data.(*dynamodb.GetItemOutput).Item = item
+				}),
+				VerifyChecksums: true,
+			}
+
+			value, err := storage.Load(context.Background(), "key")
+			if tt.wantErr {
+				if !errors.Is(err, ErrChecksumMismatch) {
+					t.Errorf("expected ErrChecksumMismatch, got: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("error loading: %s", err.Error())
+				return
+			}
+			if string(value) != "value" {
+				t.Errorf("loaded value does not match, got: %s", value)
+			}
+		})
+	}
+}
+
+func TestDynamoDBStorage_StatWithoutContentLength(t *testing.T) {
+	var requests int
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			requests++
+			item := mockItem("key", "value")
+			if aws.StringValue(r.Params.(*dynamodb.GetItemInput).ProjectionExpression) != "" {
+				// items stored before content lengths were recorded only have the last updated time
+				item = map[string]*dynamodb.AttributeValue{
+					lastUpdatedAttribute: item[lastUpdatedAttribute],
+				}
+			}
+			r.Data.(*dynamodb.GetItemOutput).Item = item
+		}),
+	}
+
+	stat, err := storage.Stat(context.Background(), "key")
+	if err != nil {
+		t.Errorf("failed to stat item: %s", err.Error())
+		return
+	}
+	if stat.Size != int64(len("value")) {
+		t.Errorf("stat size does not match expected. got: %v", stat.Size)
+	}
+	if requests != 2 {
+		t.Errorf("Stat should fall back to loading the whole item, made %d requests", requests)
+	}
+}
+
+func TestDynamoDBStorage_Delete(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+
+	err = storage.Store(context.Background(), "key", []byte("value"))
+	if err != nil {
+		t.Errorf("failed to store fixture key/value: %s", err.Error())
+		return
+	}
+
+	value, err := storage.Load(context.Background(), "key")
+	if err != nil {
+		t.Errorf("unable to load key that was just stored: %s", err.Error())
+		return
+	}
+
+	if string(value) != "value" {
+		t.Errorf("value returned does not match expected. expected: %s, got: %s", "value", string(value))
+		return
+	}
+
+	err = storage.Delete(context.Background(), "key")
+	if err != nil {
+		t.Errorf("unable to delete key: %s", err.Error())
+		return
+	}
+
+	if storage.Exists(context.Background(), "key") {
+		t.Errorf("key still exists after delete")
+		return
+	}
+
+}
+
+func TestDynamoDBStorage_Usage(t *testing.T) {
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			input, ok := r.Params.(*dynamodb.DescribeTableInput)
+			if !ok {
+				r.Error = errors.New("unexpected request: " + r.Operation.Name)
+				return
+			}
+			if aws.StringValue(input.TableName) != TestTableName {
+				r.Error = awserr.New(dynamodb.ErrCodeResourceNotFoundException, "table not found", nil)
+				return
+			}
+			r.Data.(*dynamodb.DescribeTableOutput).Table = &dynamodb.TableDescription{
+				TableName:      input.TableName,
+				ItemCount:      aws.Int64(42),
+				TableSizeBytes: aws.Int64(123456),
+			}
+		}),
+	}
+
+	items, bytes, err := storage.Usage(context.Background())
+	if err != nil {
+		t.Errorf("failed to get usage: %s", err.Error())
+		return
+	}
+	if items != 42 {
+		t.Errorf("item count does not match expected. expected: 42, got: %v", items)
+	}
+	if bytes != 123456 {
+		t.Errorf("table size does not match expected. expected: 123456, got: %v", bytes)
+	}
+}
+
+func TestDynamoDBStorage_Lock(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	lockTimeout := 1 * time.Second
+
+	storage := Storage{
+		Table:               TestTableName,
+		AwsEndpoint:         os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:           os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:       DisableSSL,
+		LockTimeout:         caddy.Duration(lockTimeout),
+		LockPollingInterval: caddy.Duration(100 * time.Millisecond),
+	}
+
+	// create lock
+	key := "test1"
+	err = storage.Lock(context.TODO(), key)
+	if err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+	}
+
+	// try to create lock again, it should take about 1-2 seconds to return
+	before := time.Now()
+	err = storage.Lock(context.TODO(), key)
+	if err != nil {
+		t.Errorf("error creating lock second time: %s", err.Error())
+	}
+	if time.Since(before) < lockTimeout {
+		t.Errorf("creating second lock finished quicker than it shoud, in %v seconds", time.Since(before).Seconds())
+	}
+
+	// try to unlock a key that doesn't exist
+	err = storage.Unlock(context.Background(), "doesntexist")
+	if err != nil {
+		t.Errorf("got error unlocking non-existant key")
+	}
+}
+
+func TestDynamoDBStorage_ReadOnly(t *testing.T) {
+	var writes []string
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			switch out := r.Data.(type) {
+			case *dynamodb.GetItemOutput:
+				out.Item = mockItem("key", "value")
+			case *dynamodb.ScanOutput:
+				out.Items = []map[string]*dynamodb.AttributeValue{mockItem("key", "value")}
+			default:
+				writes = append(writes, r.Operation.Name)
+			}
+		}),
+		ReadOnly: true,
+	}
+	ctx := context.Background()
+
+	mutators := map[string]func() error{
+		"Store":  func() error { return storage.Store(ctx, "key", []byte("value")) },
+		"Delete": func() error { return storage.Delete(ctx, "key") },
+		"Lock":   func() error { return storage.Lock(ctx, "key") },
+		"Unlock": func() error { return storage.Unlock(ctx, "key") },
+		"StoreIfVersion": func() error {
+			_, err := storage.StoreIfVersion(ctx, "key", []byte("value"), 0)
+			return err
+		},
+	}
+	for name, mutate := range mutators {
+		if err := mutate(); !errors.Is(err, ErrReadOnly) {
+			t.Errorf("%s should return ErrReadOnly, got: %v", name, err)
+		}
+	}
+	if len(writes) != 0 {
+		t.Errorf("read-only storage should not write to DynamoDB, got: %v", writes)
+	}
+
+	value, err := storage.Load(ctx, "key")
+	if err != nil || string(value) != "value" {
+		t.Errorf("Load should succeed, got: %s, %v", value, err)
+	}
+	if _, err := storage.Stat(ctx, "key"); err != nil {
+		t.Errorf("Stat should succeed, got: %v", err)
+	}
+	keys, err := storage.List(ctx, "key", false)
+	if err != nil || len(keys) != 1 {
+		t.Errorf("List should succeed, got: %v, %v", keys, err)
+	}
+	if !storage.Exists(ctx, "key") {
+		t.Errorf("Exists should succeed")
+	}
+}
+
+func TestDynamoDBStorage_Cleanup(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	other := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+
+	for _, key := range []string{"held1", "held2", "taken"} {
+		if err := storage.Lock(context.Background(), key); err != nil {
+			t.Errorf("error creating lock: %s", err.Error())
+			return
+		}
+	}
+
+	// simulate the lock on "taken" expiring and being acquired by another instance
+	if err := other.Unlock(context.Background(), "taken"); err != nil {
+		t.Errorf("error removing lock: %s", err.Error())
+		return
+	}
+	if err := other.Lock(context.Background(), "taken"); err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+		return
+	}
+
+	if err := storage.Cleanup(); err != nil {
+		t.Errorf("error cleaning up: %s", err.Error())
+		return
+	}
+
+	for _, key := range []string{"held1", "held2"} {
+		_, err := storage.getItem(context.Background(), "LOCK-"+key)
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("lock for %s should have been released by cleanup, got: %v", key, err)
+		}
+	}
+
+	_, err = storage.getItem(context.Background(), "LOCK-taken")
+	if err != nil {
+		t.Errorf("lock held by another instance should not be released by cleanup, got: %v", err)
+	}
+
+	count := 0
+	storage.locks.Range(func(_, _ any) bool {
+		count++
+		return true
+	})
+	if count != 0 {
+		t.Errorf("cleanup should forget all held locks, still holds %v", count)
+	}
+}
+
+func TestDynamoDBStorage_LockAccessDenied(t *testing.T) {
+	var puts int
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			if r.Operation.Name == "PutItem" {
+				puts++
+				r.Error = awserr.New("AccessDeniedException", "not authorized to perform dynamodb:PutItem", nil)
+			}
+		}),
+		LockPollingInterval: caddy.Duration(10 * time.Millisecond),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := storage.Lock(ctx, "key")
+
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() != "AccessDeniedException" {
+		t.Errorf("Lock should return the access denied error, got: %v", err)
+	}
+	if puts != 1 {
+		t.Errorf("Lock should fail without retrying, tried %d times", puts)
+	}
+}
+
+func TestDynamoDBStorage_LockConflictRetries(t *testing.T) {
+	var puts int
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			if r.Operation.Name == "PutItem" {
+				puts++
+				if puts == 1 {
+					// another instance acquired the lock first
+					r.Error = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "conditional request failed", nil)
+				}
+			}
+		}),
+		LockPollingInterval: caddy.Duration(10 * time.Millisecond),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := storage.Lock(ctx, "key"); err != nil {
+		t.Errorf("Lock should retry after losing the race, got: %v", err)
+	}
+	if puts != 2 {
+		t.Errorf("Lock should have tried twice, tried %d times", puts)
+	}
+}
+
+func TestDynamoDBStorage_pollInterval(t *testing.T) {
+	storage := Storage{
+		LockPollingInterval: caddy.Duration(time.Second),
+		LockPollingBackoff:  true,
+	}
+	ctx := context.Background()
+
+	expected := []time.Duration{
+		100 * time.Millisecond,
+		200 * time.Millisecond,
+		400 * time.Millisecond,
+		800 * time.Millisecond,
+		time.Second,
+		time.Second,
+	}
+	for attempt, want := range expected {
+		if got := storage.pollInterval(ctx, attempt); got != want {
+			t.Errorf("wait after attempt %d does not match, expected: %s, got: %s", attempt, want, got)
+		}
+	}
+
+	storage.LockPollingBackoff = false
+	if got := storage.pollInterval(ctx, 0); got != time.Second {
+		t.Errorf("wait without backoff should be the polling interval, got: %s", got)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if got := storage.pollInterval(ctx, 0); got > 50*time.Millisecond {
+		t.Errorf("wait should not extend past the context deadline, got: %s", got)
+	}
+}
+
+// recordingBackoff is a LockBackoff that records the attempts it's asked about
+type recordingBackoff struct {
+	attempts []int
+}
+
+func (b *recordingBackoff) NextInterval(attempt int) time.Duration {
+	b.attempts = append(b.attempts, attempt)
+	return time.Millisecond
+}
+
+func TestDynamoDBStorage_LockBackoff(t *testing.T) {
+	var puts int
+	backoff := &recordingBackoff{}
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			if r.Operation.Name == "PutItem" {
+				puts++
+				if puts <= 3 {
+					// another instance acquired the lock first
+					r.Error = awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "conditional request failed", nil)
+				}
+			}
+		}),
+		// would time the test out if it were used instead of the backoff
+		LockPollingInterval: caddy.Duration(time.Minute),
+		LockBackoff:         backoff,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := storage.Lock(ctx, "key"); err != nil {
+		t.Errorf("failed to lock: %s", err.Error())
+		return
+	}
+	if !reflect.DeepEqual(backoff.attempts, []int{0, 1, 2}) {
+		t.Errorf("backoff attempts do not match, got: %v", backoff.attempts)
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	backoff := ExponentialBackoff{Start: time.Second, Max: 5 * time.Second}
+	for attempt, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if got := backoff.NextInterval(attempt); got != want {
+			t.Errorf("wait after attempt %d does not match, expected: %s, got: %s", attempt, want, got)
+		}
+	}
+}
+
+func TestDynamoDBStorage_ListLocks(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+		InstanceID:    "instance-1",
+	}
+	ctx := context.Background()
+
+	// an expired lock left behind by a crashed instance
+	err = storage.Store(ctx, "LOCK-expired", []byte(time.Now().Add(-time.Minute).Format(time.RFC3339)))
+	if err != nil {
+		t.Errorf("error storing expired lock: %s", err.Error())
+		return
+	}
+	// a regular item that isn't a lock
+	err = storage.Store(ctx, "key", []byte("value"))
+	if err != nil {
+		t.Errorf("error storing: %s", err.Error())
+		return
+	}
+
+	if err := storage.Lock(ctx, "held"); err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+		return
+	}
+
+	locks, err := storage.ListLocks(ctx)
+	if err != nil {
+		t.Errorf("error listing locks: %s", err.Error())
+		return
+	}
+	if len(locks) != 1 {
+		t.Errorf("expected only the held lock, got: %+v", locks)
+		return
+	}
+
+	lockID, _ := storage.locks.Load("held")
+	lock := locks[0]
+	if lock.Key != "held" || lock.LockID != lockID || lock.Owner != "instance-1" {
+		t.Errorf("lock info does not match, got: %+v", lock)
+	}
+	if !lock.ExpiresAt.After(time.Now()) || lock.ExpiresAt.After(time.Now().Add(time.Duration(storage.LockTimeout))) {
+		t.Errorf("lock expiry should be within the lock timeout, got: %s", lock.ExpiresAt)
+	}
+
+	if err := storage.Unlock(ctx, "held"); err != nil {
+		t.Errorf("error unlocking: %s", err.Error())
+	}
+}
+
+func TestDynamoDBStorage_LockWithID(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	ctx := context.Background()
+
+	if err := storage.LockWithID(ctx, "key", "trace-4bf92f35"); err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+		return
+	}
+
+	locks, err := storage.ListLocks(ctx)
+	if err != nil {
+		t.Errorf("error listing locks: %s", err.Error())
+		return
+	}
+	if len(locks) != 1 || locks[0].LockID != "trace-4bf92f35" {
+		t.Errorf("expected the lock to have the given ID, got: %+v", locks)
+	}
+
+	// the lock is held like any other
+	other := Storage{
+		Table:               TestTableName,
+		AwsEndpoint:         os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:           os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:       DisableSSL,
+		LockPollingInterval: caddy.Duration(100 * time.Millisecond),
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	if err := other.Lock(waitCtx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("lock with a custom ID should not be acquired by another instance, got: %v", err)
+		return
+	}
+
+	if err := storage.Unlock(ctx, "key"); err != nil {
+		t.Errorf("error unlocking: %s", err.Error())
+		return
+	}
+	if _, err := storage.getItem(ctx, "LOCK-key"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected the lock to be deleted, got: %v", err)
+	}
+}
+
+func TestDynamoDBStorage_RefreshLock(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	newStorage := func() *Storage {
+		return &Storage{
+			Table:               TestTableName,
+			AwsEndpoint:         os.Getenv("AWS_ENDPOINT"),
+			AwsRegion:           os.Getenv("AWS_DEFAULT_REGION"),
+			AwsDisableSSL:       DisableSSL,
+			LockTimeout:         caddy.Duration(time.Second),
+			LockPollingInterval: caddy.Duration(50 * time.Millisecond),
+		}
+	}
+	holder, other := newStorage(), newStorage()
+	ctx := context.Background()
+
+	if err := holder.RefreshLock(ctx, "key"); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected ErrLockNotHeld before locking, got: %v", err)
+	}
+
+	if err := holder.Lock(ctx, "key"); err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+		return
+	}
+	// keep the lock past its original timeout
+	for i := 0; i < 3; i++ {
+		time.Sleep(500 * time.Millisecond)
+		if err := holder.RefreshLock(ctx, "key"); err != nil {
+			t.Errorf("error refreshing lock: %s", err.Error())
+			return
+		}
+	}
+
+	lockCtx, cancel := context.WithTimeout(ctx, 300*time.Millisecond)
+	defer cancel()
+	if err := other.Lock(lockCtx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the refreshed lock to still be held, got: %v", err)
+		return
+	}
+
+	// once it expires, another instance takes it over
+	if err := other.Lock(ctx, "key"); err != nil {
+		t.Errorf("error taking over expired lock: %s", err.Error())
+		return
+	}
+	if err := holder.RefreshLock(ctx, "key"); !errors.Is(err, ErrLockLost) {
+		t.Errorf("expected ErrLockLost after the lock was taken over, got: %v", err)
+	}
+	if err := holder.RefreshLock(ctx, "key"); !errors.Is(err, ErrLockNotHeld) {
+		t.Errorf("expected the lost lock to be forgotten, got: %v", err)
+	}
+	if err := other.Unlock(ctx, "key"); err != nil {
+		t.Errorf("error unlocking: %s", err.Error())
+	}
+}
+
+func TestDynamoDBStorage_LockOwnerHostname(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("hostname unknown: %s", err.Error())
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	ctx := context.Background()
+
+	if err := storage.Lock(ctx, "key"); err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+		return
+	}
+	defer storage.Unlock(ctx, "key")
+
+	lock, err := storage.getItem(context.Background(), "LOCK-key")
+	if err != nil {
+		t.Errorf("error loading lock: %s", err.Error())
+		return
+	}
+	if lock.Owner != hostname {
+		t.Errorf("lock owner should default to the hostname %s, got: %s", hostname, lock.Owner)
+	}
+
+	locks, err := storage.ListLocks(ctx)
+	if err != nil {
+		t.Errorf("error listing locks: %s", err.Error())
+		return
+	}
+	if len(locks) != 1 || locks[0].Owner != hostname {
+		t.Errorf("listed lock should show the hostname as owner, got: %+v", locks)
+	}
+}
+
+func TestDynamoDBStorage_UnlockWithoutHandle(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	previous := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	if err := previous.Lock(context.Background(), "key"); err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+		return
+	}
+
+	// a fresh instance, as after a config reload, has no record of the lock
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	if err := storage.Unlock(context.Background(), "key"); err != nil {
+		t.Errorf("error unlocking without a lock handle: %s", err.Error())
+		return
+	}
+
+	if _, err := storage.getItem(context.Background(), "LOCK-key"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("lock should have been removed, got: %v", err)
+	}
+}
+
+func TestDynamoDBStorage_UnlockWithoutHandleOtherOwner(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	holder := Storage{
+		Table:               TestTableName,
+		AwsEndpoint:         os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:           os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:       DisableSSL,
+		InstanceID:          "instance-1",
+		LockTimeout:         caddy.Duration(time.Second),
+		LockPollingInterval: caddy.Duration(100 * time.Millisecond),
+	}
+	if err := holder.Lock(context.Background(), "key"); err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+		return
+	}
+
+	// an instance without a handle, e.g. one reloaded while unlocking, leaves
+	// another instance's lock alone
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+		InstanceID:    "instance-2",
+	}
+	if err := storage.Unlock(context.Background(), "key"); !errors.Is(err, ErrLockLost) {
+		t.Errorf("unlocking another instance's lock should return ErrLockLost, got: %v", err)
+	}
+	if _, err := storage.getItem(context.Background(), "LOCK-key"); err != nil {
+		t.Errorf("lock held by another instance should not be removed, got: %v", err)
+	}
+
+	// but removes it once it has expired, which ExpiresAt records rounded up to the second
+	time.Sleep(3 * time.Second)
+	if err := storage.Unlock(context.Background(), "key"); err != nil {
+		t.Errorf("error unlocking an expired lock: %s", err.Error())
+	}
+	if _, err := storage.getItem(context.Background(), "LOCK-key"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expired lock should have been removed, got: %v", err)
+	}
+	holder.locks.Delete("key")
+	heldLocks.Delete(holder.heldLockKey("key"))
+}
+
+func TestDynamoDBStorage_LockReclaim(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	previous := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+		InstanceID:    "instance-1",
+	}
+	if err := previous.Lock(context.Background(), "key"); err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+		return
+	}
+
+	// another instance must wait for the lock
+	other := Storage{
+		Table:               TestTableName,
+		AwsEndpoint:         os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:           os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:       DisableSSL,
+		InstanceID:          "instance-2",
+		LockPollingInterval: caddy.Duration(100 * time.Millisecond),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := other.Lock(ctx, "key"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("lock held by another instance should not be acquired, got: %v", err)
+		return
+	}
+
+	// the same instance, recreated as after a restart, reclaims it immediately once
+	// the process no longer holds it
+	heldLocks.Delete(previous.heldLockKey("key"))
+	restarted := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+		InstanceID:    "instance-1",
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := restarted.Lock(ctx, "key"); err != nil {
+		t.Errorf("error reclaiming lock: %s", err.Error())
+		return
+	}
+
+	lock, err := restarted.getItem(context.Background(), "LOCK-key")
+	if err != nil {
+		t.Errorf("error loading lock: %s", err.Error())
+		return
+	}
+	if lock.Owner != "instance-1" {
+		t.Errorf("lock owner does not match, expected: instance-1, got: %s", lock.Owner)
+	}
+
+	if err := restarted.Unlock(context.Background(), "key"); err != nil {
+		t.Errorf("error unlocking reclaimed lock: %s", err.Error())
+	}
+}
+
+func TestDynamoDBStorage_LockSameInstanceID(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	newStorage := func() *Storage {
+		return &Storage{
+			Table:               TestTableName,
+			AwsEndpoint:         os.Getenv("AWS_ENDPOINT"),
+			AwsRegion:           os.Getenv("AWS_DEFAULT_REGION"),
+			AwsDisableSSL:       DisableSSL,
+			InstanceID:          "instance-1",
+			LockPollingInterval: caddy.Duration(50 * time.Millisecond),
+		}
+	}
+	// like the old and new storage of a config reload, each locking from two goroutines
+	old, reloaded := newStorage(), newStorage()
+	for _, storage := range []*Storage{old, reloaded} {
+		if err := storage.initConfig(); err != nil {
+			t.Errorf("initConfig() error = %v", err)
+			return
+		}
+	}
+
+	var mu sync.Mutex
+	var holders, maxHolders int
+	var wg sync.WaitGroup
+	for _, storage := range []*Storage{old, old, reloaded, reloaded} {
+		wg.Add(1)
+		go func(storage *Storage) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := storage.Lock(ctx, "key"); err != nil {
+				t.Errorf("error creating lock: %s", err.Error())
+				return
+			}
+
+			mu.Lock()
+			holders++
+			maxHolders = max(maxHolders, holders)
+			mu.Unlock()
+			time.Sleep(100 * time.Millisecond)
+			mu.Lock()
+			holders--
+			mu.Unlock()
+
+			if err := storage.Unlock(context.Background(), "key"); err != nil {
+				t.Errorf("error unlocking: %s", err.Error())
+			}
+		}(storage)
+	}
+	wg.Wait()
+
+	if maxHolders != 1 {
+		t.Errorf("expected the lock to be held by one caller at a time, got: %d", maxHolders)
+	}
+}
+
+func TestDynamoDBStorage_ForceUnlock(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	holder := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	admin := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+
+	if err := holder.Lock(context.Background(), "key"); err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+		return
+	}
+
+	if err := admin.ForceUnlock(context.Background(), "key"); err != nil {
+		t.Errorf("error force unlocking: %s", err.Error())
+		return
+	}
+
+	if _, err := admin.getItem(context.Background(), "LOCK-key"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("lock should have been removed, got: %v", err)
+	}
+
+	// the lock can now be acquired right away
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if err := admin.Lock(ctx, "key"); err != nil {
+		t.Errorf("error creating lock after force unlock: %s", err.Error())
+		return
+	}
+	if err := admin.Unlock(context.Background(), "key"); err != nil {
+		t.Errorf("error unlocking: %s", err.Error())
+	}
+}
+
+func TestDynamoDBStorage_UnlockLost(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	other := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+
+	if err := storage.Lock(context.Background(), "key"); err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+		return
+	}
+
+	// simulate the lock expiring and being acquired by another instance
+	if err := other.Unlock(context.Background(), "key"); err != nil {
+		t.Errorf("error removing lock: %s", err.Error())
+		return
+	}
+	if err := other.Lock(context.Background(), "key"); err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+		return
+	}
+
+	err = storage.Unlock(context.Background(), "key")
+	if !errors.Is(err, ErrLockLost) {
+		t.Errorf("unlocking a lock taken by another instance should return ErrLockLost, got: %v", err)
+	}
+
+	if _, err := storage.getItem(context.Background(), "LOCK-key"); err != nil {
+		t.Errorf("lock held by another instance should not be released, got: %v", err)
+	}
+
+	if err := other.Unlock(context.Background(), "key"); err != nil {
+		t.Errorf("error unlocking: %s", err.Error())
+	}
+}
+
+func TestDynamoDBStorage_IgnoreStolenLockOnUnlock(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	core, logs := observer.New(zap.WarnLevel)
+	storage := Storage{
+		Table:                    TestTableName,
+		AwsEndpoint:              os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:                os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:            DisableSSL,
+		IgnoreStolenLockOnUnlock: true,
+		logger:                   zap.New(core),
+	}
+	other := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+
+	if err := storage.Lock(context.Background(), "key"); err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+		return
+	}
+
+	// simulate the lock expiring and being acquired by another instance
+	if err := other.ForceUnlock(context.Background(), "key"); err != nil {
+		t.Errorf("error removing lock: %s", err.Error())
+		return
+	}
+	if err := other.Lock(context.Background(), "key"); err != nil {
+		t.Errorf("error creating lock: %s", err.Error())
+		return
+	}
+
+	if err := storage.Unlock(context.Background(), "key"); err != nil {
+		t.Errorf("a stolen lock should be ignored, got: %v", err)
+	}
+	if logs.FilterMessage("lock was taken over by another instance before it was released").Len() != 1 {
+		t.Errorf("expected a warning about the stolen lock, got: %v", logs.All())
+	}
+	if _, ok := storage.locks.Load("key"); ok {
+		t.Errorf("the lost lock should be forgotten locally")
+	}
+	if _, err := storage.getItem(context.Background(), "LOCK-key"); err != nil {
+		t.Errorf("lock held by another instance should not be released, got: %v", err)
+	}
+
+	if err := other.Unlock(context.Background(), "key"); err != nil {
+		t.Errorf("error unlocking: %s", err.Error())
+	}
+}
+
+func TestDynamoDBStorage_SentinelErrors(t *testing.T) {
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			r.Error = errors.New("unexpected request: " + r.Operation.Name)
+		}),
+	}
+	ctx := context.Background()
+
+	if err := (&Storage{}).initConfig(); !errors.Is(err, ErrTableRequired) {
+		t.Errorf("initConfig without a table should return ErrTableRequired, got: %v", err)
+	}
+	if err := (&Storage{}).Store(ctx, "key", []byte("value")); !errors.Is(err, ErrTableRequired) {
+		t.Errorf("Store without a table should return ErrTableRequired, got: %v", err)
+	}
+
+	emptyKey := map[string]func() error{
+		"Store":  func() error { return storage.Store(ctx, "", []byte("value")) },
+		"Delete": func() error { return storage.Delete(ctx, "") },
+		"Load": func() error {
+			_, err := storage.Load(ctx, "")
+			return err
+		},
+		"List": func() error {
+			_, err := storage.List(ctx, "", false)
+			return err
+		},
+		"StoreIfVersion": func() error {
+			_, err := storage.StoreIfVersion(ctx, "", []byte("value"), 0)
+			return err
+		},
+	}
+	for name, call := range emptyKey {
+		if err := call(); !errors.Is(err, ErrEmptyKey) {
+			t.Errorf("%s with an empty key should return ErrEmptyKey, got: %v", name, err)
+		}
+	}
+}
+
+func TestDynamoDBStorage_LoadErrNotExist(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:         TestTableName,
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+
+	_, err = storage.Load(context.Background(), "notarealkey")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("err was not a ErrNotExist, got: %s", err.Error())
+	}
+}
+
+func TestDynamoDBStorage_LoadCached(t *testing.T) {
+	getItemCalls := 0
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			if out, ok := r.Data.(*dynamodb.GetItemOutput); ok {
+				getItemCalls++
+				out.Item = mockItem("key", "value")
+			}
+		}),
+		CacheTTL: caddy.Duration(time.Minute),
+	}
+
+	for i := 0; i < 2; i++ {
+		value, err := storage.Load(context.Background(), "key")
+		if err != nil {
+			t.Errorf("unable to load key: %s", err.Error())
+			return
+		}
+		if string(value) != "value" {
+			t.Errorf("value returned does not match expected. expected: %s, got: %s", "value", string(value))
+			return
+		}
+	}
+	if getItemCalls != 1 {
+		t.Errorf("second load within the cache TTL should not call DynamoDB, got %v GetItem calls", getItemCalls)
+		return
+	}
+
+	// storing the key must evict it from the cache
+	err := storage.Store(context.Background(), "key", []byte("value"))
+	if err != nil {
+		t.Errorf("failed to store key: %s", err.Error())
+		return
+	}
+	_, err = storage.Load(context.Background(), "key")
+	if err != nil {
+		t.Errorf("unable to load key: %s", err.Error())
+		return
+	}
+	if getItemCalls != 2 {
+		t.Errorf("load after store should call DynamoDB, got %v GetItem calls", getItemCalls)
+		return
+	}
+
+	// as must deleting it
+	err = storage.Delete(context.Background(), "key")
+	if err != nil {
+		t.Errorf("failed to delete key: %s", err.Error())
+		return
+	}
+	_, err = storage.Load(context.Background(), "key")
+	if err != nil {
+		t.Errorf("unable to load key: %s", err.Error())
+		return
+	}
+	if getItemCalls != 3 {
+		t.Errorf("load after delete should call DynamoDB, got %v GetItem calls", getItemCalls)
+	}
+}
+
+func TestDynamoDBStorage_Base64Variant(t *testing.T) {
+	// contains both characters that differ between the std and url alphabets
+	value := []byte{0xfb, 0xff, 0xbf}
+
+	for variant, encoding := range base64Encodings {
+		t.Run(variant, func(t *testing.T) {
+			var stored map[string]*dynamodb.AttributeValue
+			sess := newMockSession(func(r *request.Request) {
+				switch r.Operation.Name {
+				case "PutItem":
+					stored = r.Params.(*dynamodb.PutItemInput).Item
+				case "GetItem":
+					r.Data.(*dynamodb.GetItemOutput).Item = stored
+				}
+			})
+
+			storage := Storage{Table: TestTableName, AwsSession: sess, Base64Variant: variant}
+			if err := storage.Store(context.Background(), "key", value); err != nil {
+				t.Fatalf("failed to store: %s", err.Error())
+			}
+			if contents := aws.StringValue(stored[contentsAttribute].S); contents != encoding.EncodeToString(value) {
+				t.Errorf("value not encoded as %s, got: %s", variant, contents)
+			}
+
+			// a storage using a different variant must still decode it
+			for _, loadVariant := range []string{variant, "std", "raw-url"} {
+				loader := Storage{Table: TestTableName, AwsSession: sess, Base64Variant: loadVariant}
+				loaded, err := loader.Load(context.Background(), "key")
+				if err != nil {
+					t.Errorf("failed to load with %s: %s", loadVariant, err.Error())
+					continue
+				}
+				if !bytes.Equal(loaded, value) {
+					t.Errorf("value loaded with %s does not match, expected: %v, got: %v", loadVariant, value, loaded)
+				}
+			}
+		})
+	}
+
+	storage := Storage{Table: TestTableName, AwsSession: newMockSession(func(r *request.Request) {}), Base64Variant: "hex"}
+	if err := storage.initConfig(); err == nil {
+		t.Errorf("expected an error for an unsupported base64 variant")
+	}
+}
+
+func TestDynamoDBStorage_KeyEncoding(t *testing.T) {
+	keys := []string{
+		"certificates/acme-v02.api.letsencrypt.org-directory/example.com/example.com.crt",
+		"certificates/acme:v02/bücher.example/bücher.example.key",
+		"acme/🔒/100% done+more",
+	}
+	encoded := map[string]func(key string) string{
+		"raw":       func(key string) string { return key },
+		"urlencode": url.QueryEscape,
+		"hex-sha256": func(key string) string {
+			hash := sha256.Sum256([]byte(key))
+			return hex.EncodeToString(hash[:])
+		},
+	}
+
+	for encoding, encode := range encoded {
+		t.Run(encoding, func(t *testing.T) {
+			if err := initDb(); err != nil {
+				t.Error(err)
+				return
+			}
+			storage := Storage{
+				Table:         TestTableName,
+				AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+				AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+				AwsDisableSSL: DisableSSL,
+				KeyEncoding:   encoding,
+			}
+			ctx := context.Background()
+
+			for _, key := range keys {
+				if err := storage.Store(ctx, key, []byte(key)); err != nil {
+					t.Errorf("failed to store %s: %s", key, err.Error())
+					return
+				}
+				contents, err := storage.Load(ctx, key)
+				if err != nil || string(contents) != key {
+					t.Errorf("expected to load %s, got: %q, %v", key, contents, err)
+				}
+
+				result, err := storage.client().GetItemWithContext(ctx, &dynamodb.GetItemInput{
+					TableName: aws.String(TestTableName),
+					Key: map[string]*dynamodb.AttributeValue{
+						primaryKeyAttribute: {S: aws.String(encode(key))},
+					},
+				})
+				if err != nil || result.Item == nil {
+					t.Errorf("expected %s to be stored at %s, got: %v", key, encode(key), err)
+				}
+			}
+
+			listed, err := storage.List(ctx, "certificates/acme", true)
+			if err != nil {
+				t.Errorf("failed to list: %s", err.Error())
+				return
+			}
+			sort.Strings(listed)
+			if !reflect.DeepEqual(listed, keys[:2]) {
+				t.Errorf("expected the decoded keys to be listed, got: %q", listed)
+			}
+
+			exists, err := storage.ExistsMany(ctx, keys)
+			if err != nil {
+				t.Errorf("failed to check keys: %s", err.Error())
+			}
+			for _, key := range keys {
+				if !exists[key] {
+					t.Errorf("expected %s to exist, got: %v", key, exists)
+				}
+			}
+
+			if err := storage.Lock(ctx, keys[1]); err != nil {
+				t.Errorf("failed to lock: %s", err.Error())
+				return
+			}
+			locks, err := storage.ListLocks(ctx)
+			if err != nil || len(locks) != 1 || locks[0].Key != keys[1] {
+				t.Errorf("expected the lock on %s to be listed, got: %v, %v", keys[1], locks, err)
+			}
+			if err := storage.Unlock(ctx, keys[1]); err != nil {
+				t.Errorf("failed to unlock: %s", err.Error())
+			}
+
+			if err := storage.Delete(ctx, keys[2]); err != nil {
+				t.Errorf("failed to delete: %s", err.Error())
+			}
+			if storage.Exists(ctx, keys[2]) {
+				t.Errorf("expected %s to be deleted", keys[2])
+			}
+		})
+	}
+
+	storage := Storage{Table: TestTableName, AwsSession: newMockSession(func(r *request.Request) {}), KeyEncoding: "base32"}
+	if err := storage.initConfig(); err == nil {
+		t.Errorf("expected an error for an unsupported key encoding")
+	}
+}
+
+func TestDynamoDBStorage_Compression(t *testing.T) {
+	value := bytes.Repeat([]byte("-----BEGIN CERTIFICATE-----\nMIIBszCCAVmgAwIBAgIU\n"), 200)
+
+	for _, codec := range []string{"", "gzip", "zstd"} {
+		t.Run("codec "+codec, func(t *testing.T) {
+			var stored map[string]*dynamodb.AttributeValue
+			sess := newMockSession(func(r *request.Request) {
+				switch r.Operation.Name {
+				case "PutItem":
+					stored = r.Params.(*dynamodb.PutItemInput).Item
+				case "GetItem":
+					r.Data.(*dynamodb.GetItemOutput).Item = stored
+				}
+			})
+
+			storage := Storage{Table: TestTableName, AwsSession: sess, Compression: codec, VerifyChecksums: true}
+			if err := storage.Store(context.Background(), "key", value); err != nil {
+				t.Fatalf("failed to store: %s", err.Error())
+			}
+			if recorded := stringAttribute(stored, compressionAttribute); recorded != codec {
+				t.Errorf("expected the codec %q to be recorded, got: %q", codec, recorded)
+			}
+			encodedSize := len(aws.StringValue(stored[contentsAttribute].S))
+			if codec != "" && encodedSize >= len(value) {
+				t.Errorf("expected the value to be compressed, stored %d bytes of %d", encodedSize, len(value))
+			}
+			if length := aws.StringValue(stored[contentLengthAttribute].N); length != strconv.Itoa(len(value)) {
+				t.Errorf("content length should be of the uncompressed value, got: %s", length)
+			}
+
+			// a storage using a different codec, or none, must still decompress it
+			for _, loadCodec := range []string{codec, "", "zstd"} {
+				loader := Storage{Table: TestTableName, AwsSession: sess, Compression: loadCodec, VerifyChecksums: true}
+				loaded, err := loader.Load(context.Background(), "key")
+				if err != nil {
+					t.Errorf("failed to load with %q: %s", loadCodec, err.Error())
+					continue
+				}
+				if !bytes.Equal(loaded, value) {
+					t.Errorf("value loaded with %q does not match", loadCodec)
+				}
+			}
+
+			// short values are left uncompressed
+			if err := storage.Store(context.Background(), "key", []byte("value")); err != nil {
+				t.Fatalf("failed to store: %s", err.Error())
+			}
+			if _, ok := stored[compressionAttribute]; ok {
+				t.Errorf("expected a short value to be stored uncompressed")
+			}
+		})
+	}
+
+	storage := Storage{Table: TestTableName, AwsSession: newMockSession(func(r *request.Request) {}), Compression: "lz4"}
+	if err := storage.initConfig(); err == nil {
+		t.Errorf("expected an error for an unsupported compression codec")
+	}
+}
+
+func TestDynamoDBStorage_CompressionSniffing(t *testing.T) {
+	value := bytes.Repeat([]byte("-----BEGIN CERTIFICATE-----\nMIIBszCCAVmgAwIBAgIU\n"), 200)
+	gzipped, err := compress("gzip", value)
+	if err != nil {
+		t.Fatalf("failed to compress: %s", err.Error())
+	}
+	zstded, err := compress("zstd", value)
+	if err != nil {
+		t.Fatalf("failed to compress: %s", err.Error())
+	}
+	withAttribute := func(item map[string]*dynamodb.AttributeValue, name string, value *dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+		item[name] = value
+		return item
+	}
+
+	tests := []struct {
+		name string
+		item map[string]*dynamodb.AttributeValue
+		want []byte
+	}{
+		{
+			name: "marker present",
+			item: withAttribute(mockItem("key", string(gzipped)), compressionAttribute, &dynamodb.AttributeValue{S: aws.String("gzip")}),
+			want: value,
+		},
+		{
+			name: "marker absent, gzipped",
+			item: mockItem("key", string(gzipped)),
+			want: value,
+		},
+		{
+			name: "marker absent, zstd compressed",
+			item: mockItem("key", string(zstded)),
+			want: value,
+		},
+		{
+			name: "plain",
+			item: mockItem("key", string(value)),
+			want: value,
+		},
+		{
+			name: "stored gzipped by the caller",
+			item: withAttribute(mockItem("key", string(gzipped)), contentLengthAttribute,
+				&dynamodb.AttributeValue{N: aws.String(strconv.Itoa(len(gzipped)))}),
+			want: gzipped,
+		},
+		{
+			name: "magic bytes but not compressed",
+			item: mockItem("key", "\x1f\x8bnot gzip"),
+			want: []byte("\x1f\x8bnot gzip"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := Storage{Table: TestTableName, AwsSession: newMockSession(func(r *request.Request) {
+				if out, ok := r.Data.(*dynamodb.GetItemOutput); ok {
+					out.Item = tt.item
+				}
+			})}
+			loaded, err := storage.Load(context.Background(), "key")
+			if err != nil {
+				t.Fatalf("failed to load: %s", err.Error())
+			}
+			if !bytes.Equal(loaded, tt.want) {
+				t.Errorf("loaded value does not match, got %d bytes, want %d", len(loaded), len(tt.want))
+			}
+		})
+	}
+}
+
+func TestDynamoDBStorage_CompressionChunked(t *testing.T) {
+	err := initDb()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	storage := Storage{
+		Table:          TestTableName,
+		AwsEndpoint:    os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:      os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:  DisableSSL,
+		EnableChunking: true,
+		ChunkSize:      4096,
+		Compression:    "zstd",
+	}
+
+	value := bytes.Repeat([]byte("0123456789abcdef"), 1000)
+	if err := storage.Store(context.Background(), "big", value); err != nil {
+		t.Errorf("failed to store: %s", err.Error())
+		return
+	}
+	loaded, err := storage.Load(context.Background(), "big")
+	if err != nil {
+		t.Errorf("failed to load: %s", err.Error())
+		return
+	}
+	if !bytes.Equal(loaded, value) {
+		t.Errorf("value stored in compressed parts does not match, got %d bytes", len(loaded))
+	}
+}
+
+func TestDynamoDBStorage_DecompressLimit(t *testing.T) {
+	for _, codec := range []string{"gzip", "zstd"} {
+		for _, size := range []int{maxCompressedSize, maxCompressedSize + 1} {
+			compressed, err := compress(codec, make([]byte, size))
+			if err != nil {
+				t.Fatalf("failed to compress with %s: %s", codec, err.Error())
+			}
+			decompressed, err := decompress(codec, compressed)
+			if size > maxCompressedSize {
+				if err == nil {
+					t.Errorf("expected an error decompressing %d bytes with %s", size, codec)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("failed to decompress %d bytes with %s: %s", size, codec, err.Error())
+			} else if len(decompressed) != size {
+				t.Errorf("expected %d bytes decompressed with %s, got: %d", size, codec, len(decompressed))
+			}
+		}
+	}
+}
+
+func TestDynamoDBStorage_InvalidateCache(t *testing.T) {
+	getItemCalls := 0
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			if out, ok := r.Data.(*dynamodb.GetItemOutput); ok {
+				getItemCalls++
+				out.Item = mockItem(aws.StringValue(r.Params.(*dynamodb.GetItemInput).Key[primaryKeyAttribute].S), "value")
+			}
+		}),
+		CacheTTL: caddy.Duration(time.Minute),
+	}
+
+	load := func(key string) {
+		if _, err := storage.Load(context.Background(), key); err != nil {
+			t.Fatalf("unable to load %s: %s", key, err.Error())
+		}
+	}
+
+	load("a")
+	load("b")
+	storage.InvalidateCache("a")
+	load("a")
+	load("b")
+	if getItemCalls != 3 {
+		t.Errorf("only the invalidated key should be loaded again, got %v GetItem calls", getItemCalls)
+	}
+
+	storage.FlushCache()
+	load("a")
+	load("b")
+	if getItemCalls != 5 {
+		t.Errorf("every key should be loaded again after flushing the cache, got %v GetItem calls", getItemCalls)
+	}
+}
+
+func TestDynamoDBStorage_LoadCacheExpires(t *testing.T) {
+	getItemCalls := 0
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			if out, ok := r.Data.(*dynamodb.GetItemOutput); ok {
+				getItemCalls++
+				out.Item = mockItem("key", "value")
+			}
+		}),
+		CacheTTL: caddy.Duration(50 * time.Millisecond),
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := storage.Load(context.Background(), "key"); err != nil {
+			t.Errorf("unable to load key: %s", err.Error())
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if getItemCalls != 2 {
+		t.Errorf("load after the cache TTL should call DynamoDB, got %v GetItem calls", getItemCalls)
 	}
 }
@@ -0,0 +1,188 @@
+package dynamodbstorage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+)
+
+// streamPollingInterval is how often WatchChanges reads new records from each shard
+// of the stream. DynamoDB allows a shard to be read up to 5 times per second.
+const streamPollingInterval = time.Second
+
+// WatchChanges reads the table's DynamoDB Stream and calls fn with the key and
+// event type (INSERT, MODIFY, or REMOVE) of each change made from now on, by
+// this or any other instance. Lock changes are left out, and changed keys are
+// evicted from the read cache before fn is called. The table must have a stream
+// enabled that includes keys. WatchChanges blocks until ctx is canceled or the
+// stream can't be read, and returns the error.
+func (s *Storage) WatchChanges(ctx context.Context, fn func(key string, eventType string)) error {
+	if err := s.initConfig(); err != nil {
+		return err
+	}
+
+	table, err := s.client().DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.Table),
+	}, s.readTimeout())
+	if err != nil {
+		return contextError(ctx, err)
+	}
+	streamArn := table.Table.LatestStreamArn
+	if streamArn == nil {
+		return fmt.Errorf("table %s has no stream enabled", s.Table)
+	}
+
+	svc := s.streamsClient()
+	seen := make(map[string]bool)
+	iterators := make(map[string]*string)
+
+	// shards that exist now are read from their latest record, and shards that
+	// are split off from them later on from their first
+	iteratorType := dynamodbstreams.ShardIteratorTypeLatest
+	for {
+		shards, err := s.streamShards(ctx, svc, streamArn)
+		if err != nil {
+			return err
+		}
+		pruneShards(seen, iterators, shards)
+		for _, shardID := range shards {
+			if seen[shardID] {
+				continue
+			}
+			iterator, err := svc.GetShardIteratorWithContext(ctx, &dynamodbstreams.GetShardIteratorInput{
+				ShardId:           aws.String(shardID),
+				ShardIteratorType: aws.String(iteratorType),
+				StreamArn:         streamArn,
+			}, s.readTimeout())
+			if err != nil {
+				return contextError(ctx, err)
+			}
+			seen[shardID] = true
+			iterators[shardID] = iterator.ShardIterator
+		}
+		iteratorType = dynamodbstreams.ShardIteratorTypeTrimHorizon
+
+		for shardID, iterator := range iterators {
+			records, err := svc.GetRecordsWithContext(ctx, &dynamodbstreams.GetRecordsInput{
+				ShardIterator: iterator,
+			}, s.readTimeout())
+			if err != nil {
+				return contextError(ctx, err)
+			}
+			for _, record := range records.Records {
+				s.handleStreamRecord(record, fn)
+			}
+
+			// a closed shard has no more records
+			if records.NextShardIterator == nil {
+				delete(iterators, shardID)
+				continue
+			}
+			iterators[shardID] = records.NextShardIterator
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(streamPollingInterval):
+		}
+	}
+}
+
+// streamsClient returns StreamsAPI if it's set, or else a new DynamoDB Streams
+// client for the session
+func (s *Storage) streamsClient() StreamsAPI {
+	if s.StreamsAPI != nil {
+		return s.StreamsAPI
+	}
+	return dynamodbstreams.New(s.AwsSession)
+}
+
+// streamShards returns the IDs of every shard in the stream
+func (s *Storage) streamShards(ctx context.Context, svc StreamsAPI, streamArn *string) ([]string, error) {
+	var shards []string
+	input := &dynamodbstreams.DescribeStreamInput{
+		StreamArn: streamArn,
+	}
+	for {
+		result, err := svc.DescribeStreamWithContext(ctx, input, s.readTimeout())
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		for _, shard := range result.StreamDescription.Shards {
+			shards = append(shards, aws.StringValue(shard.ShardId))
+		}
+		if result.StreamDescription.LastEvaluatedShardId == nil {
+			return shards, nil
+		}
+		input.ExclusiveStartShardId = result.StreamDescription.LastEvaluatedShardId
+	}
+}
+
+// pruneShards forgets closed shards that have been read to the end and are no
+// longer in the stream's shards, so that seen doesn't grow for as long as the
+// stream is watched. Closed shards still in the stream stay seen, so that they
+// aren't read again.
+func pruneShards(seen map[string]bool, iterators map[string]*string, shards []string) {
+	current := make(map[string]bool, len(shards))
+	for _, shardID := range shards {
+		current[shardID] = true
+	}
+	for shardID := range seen {
+		if _, reading := iterators[shardID]; !reading && !current[shardID] {
+			delete(seen, shardID)
+		}
+	}
+}
+
+// handleStreamRecord calls fn for a change to a stored value, skipping locks,
+// other tenants' items, and other data sharing the table
+func (s *Storage) handleStreamRecord(record *dynamodbstreams.Record, fn func(key string, eventType string)) {
+	if record.Dynamodb == nil {
+		return
+	}
+	keys := record.Dynamodb.Keys
+
+	if s.SortKeyAttribute != "" {
+		if sortKey, ok := keys[s.SortKeyAttribute]; !ok || aws.StringValue(sortKey.S) != s.SortKeyValue {
+			return
+		}
+	}
+
+	// other tenants' items
+	if !strings.HasPrefix(stringAttribute(keys, s.PrimaryKeyAttribute), s.keyPrefix) {
+		return
+	}
+	// hashed keys can't be decoded, so read the key from the item's image
+	attributes := keys
+	if s.KeyEncoding == "hex-sha256" {
+		attributes = record.Dynamodb.NewImage
+		if attributes == nil {
+			attributes = record.Dynamodb.OldImage
+		}
+	}
+	key := s.storageKey(attributes)
+	if key == "" || strings.HasPrefix(key, "LOCK-") {
+		return
+	}
+	image := record.Dynamodb.NewImage
+	if image == nil {
+		image = record.Dynamodb.OldImage
+	}
+	if image != nil {
+		if _, ok := image[partOfAttribute]; ok {
+			return
+		}
+	} else if s.EnableChunking && isPartKey(key) {
+		// a KEYS_ONLY stream doesn't say whether the item is a part, so go by its key
+		return
+	}
+
+	s.evict(key)
+	fn(key, aws.StringValue(record.EventName))
+}
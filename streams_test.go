@@ -0,0 +1,194 @@
+package dynamodbstorage
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/caddyserver/caddy/v2"
+)
+
+func streamRecord(eventName, key string) *dynamodbstreams.Record {
+	return &dynamodbstreams.Record{
+		EventName: aws.String(eventName),
+		Dynamodb: &dynamodbstreams.StreamRecord{
+			Keys: map[string]*dynamodb.AttributeValue{
+				primaryKeyAttribute: {S: aws.String(key)},
+			},
+		},
+	}
+}
+
+func TestDynamoDBStorage_WatchChanges(t *testing.T) {
+	var mu sync.Mutex
+	getRecordsCalls := 0
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch out := r.Data.(type) {
+			case *dynamodb.DescribeTableOutput:
+				out.Table = &dynamodb.TableDescription{LatestStreamArn: aws.String("arn:aws:dynamodb:us-east-1:123456789012:table/CertMagicTest/stream/2024-01-01T00:00:00.000")}
+			case *dynamodb.GetItemOutput:
+				out.Item = mockItem("cert", "value")
+			case *dynamodbstreams.DescribeStreamOutput:
+				out.StreamDescription = &dynamodbstreams.StreamDescription{
+					Shards: []*dynamodbstreams.Shard{{ShardId: aws.String("shardId-00000001700000000000-00000000")}},
+				}
+			case *dynamodbstreams.GetShardIteratorOutput:
+				if aws.StringValue(r.Params.(*dynamodbstreams.GetShardIteratorInput).ShardIteratorType) !=
+					dynamodbstreams.ShardIteratorTypeLatest {
+					t.Errorf("existing shards should be read from the latest record")
+				}
+				out.ShardIterator = aws.String("shard-iterator")
+			case *dynamodbstreams.GetRecordsOutput:
+				getRecordsCalls++
+				if getRecordsCalls == 1 {
+					out.Records = []*dynamodbstreams.Record{
+						streamRecord(dynamodbstreams.OperationTypeInsert, "cert"),
+						streamRecord(dynamodbstreams.OperationTypeModify, "LOCK-cert"),
+						streamRecord(dynamodbstreams.OperationTypeRemove, "meta"),
+					}
+				}
+				out.NextShardIterator = aws.String("shard-iterator")
+			}
+		}),
+		CacheTTL: caddy.Duration(time.Minute),
+	}
+
+	// cache the value so that the change can be seen to evict it
+	if _, err := storage.Load(context.Background(), "cert"); err != nil {
+		t.Errorf("failed to load: %s", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var changes []string
+	err := storage.WatchChanges(ctx, func(key string, eventType string) {
+		changes = append(changes, eventType+" "+key)
+		if len(changes) == 2 {
+			cancel()
+		}
+	})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("WatchChanges should return once ctx is canceled, got: %v", err)
+	}
+	if !reflect.DeepEqual(changes, []string{"INSERT cert", "REMOVE meta"}) {
+		t.Errorf("unexpected changes, lock changes should be left out, got: %v", changes)
+	}
+	if _, ok := storage.cache.get("cert"); ok {
+		t.Errorf("changed key should be evicted from the cache")
+	}
+}
+
+func TestDynamoDBStorage_WatchChangesNoStream(t *testing.T) {
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			if out, ok := r.Data.(*dynamodb.DescribeTableOutput); ok {
+				out.Table = &dynamodb.TableDescription{}
+			}
+		}),
+	}
+
+	err := storage.WatchChanges(context.Background(), func(key string, eventType string) {})
+	if err == nil {
+		t.Errorf("expected an error for a table without a stream")
+	}
+}
+
+// mockStreamsAPI serves a stream whose shards are listed by describe, of which
+// the shard named closed has no more records
+type mockStreamsAPI struct {
+	describe        func(calls int) []string
+	describeCalls   int
+	iteratorFetches map[string]int
+}
+
+func (m *mockStreamsAPI) DescribeStreamWithContext(ctx aws.Context, input *dynamodbstreams.DescribeStreamInput, opts ...request.Option) (*dynamodbstreams.DescribeStreamOutput, error) {
+	m.describeCalls++
+	var shards []*dynamodbstreams.Shard
+	for _, shardID := range m.describe(m.describeCalls) {
+		shards = append(shards, &dynamodbstreams.Shard{ShardId: aws.String(shardID)})
+	}
+	return &dynamodbstreams.DescribeStreamOutput{
+		StreamDescription: &dynamodbstreams.StreamDescription{Shards: shards},
+	}, nil
+}
+
+func (m *mockStreamsAPI) GetShardIteratorWithContext(ctx aws.Context, input *dynamodbstreams.GetShardIteratorInput, opts ...request.Option) (*dynamodbstreams.GetShardIteratorOutput, error) {
+	m.iteratorFetches[aws.StringValue(input.ShardId)]++
+	return &dynamodbstreams.GetShardIteratorOutput{ShardIterator: input.ShardId}, nil
+}
+
+func (m *mockStreamsAPI) GetRecordsWithContext(ctx aws.Context, input *dynamodbstreams.GetRecordsInput, opts ...request.Option) (*dynamodbstreams.GetRecordsOutput, error) {
+	// the closed shard has no more records, while the open one carries on
+	if aws.StringValue(input.ShardIterator) == "closed" {
+		return &dynamodbstreams.GetRecordsOutput{}, nil
+	}
+	return &dynamodbstreams.GetRecordsOutput{NextShardIterator: input.ShardIterator}, nil
+}
+
+func TestDynamoDBStorage_WatchChangesStreamsAPI(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	streams := &mockStreamsAPI{
+		describe: func(calls int) []string {
+			if calls < 3 {
+				return []string{"closed", "open"}
+			}
+			// the closed shard has been trimmed from the stream
+			cancel()
+			return []string{"open"}
+		},
+		iteratorFetches: make(map[string]int),
+	}
+	storage := Storage{
+		Table: TestTableName,
+		AwsSession: newMockSession(func(r *request.Request) {
+			switch out := r.Data.(type) {
+			case *dynamodb.DescribeTableOutput:
+				out.Table = &dynamodb.TableDescription{LatestStreamArn: aws.String("arn:aws:dynamodb:us-east-1:123456789012:table/CertMagicTest/stream/2024-01-01T00:00:00.000")}
+			default:
+				t.Errorf("unexpected %s request to the session, the streams API should be used", r.Operation.Name)
+			}
+		}),
+		StreamsAPI: streams,
+	}
+
+	err := storage.WatchChanges(ctx, func(key string, eventType string) {})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("WatchChanges should return once ctx is canceled, got: %v", err)
+	}
+	if streams.describeCalls != 3 {
+		t.Errorf("expected the stream to be described 3 times, got: %d", streams.describeCalls)
+	}
+	if !reflect.DeepEqual(streams.iteratorFetches, map[string]int{"closed": 1, "open": 1}) {
+		t.Errorf("each shard should be read from once, got: %v", streams.iteratorFetches)
+	}
+}
+
+func TestDynamoDBStorage_pruneShards(t *testing.T) {
+	seen := map[string]bool{"consumed": true, "trimmed": true, "reading": true}
+	iterators := map[string]*string{"reading": aws.String("iterator")}
+
+	// the consumed shard is still in the stream, while the trimmed one is gone
+	pruneShards(seen, iterators, []string{"consumed", "reading"})
+
+	if !reflect.DeepEqual(seen, map[string]bool{"consumed": true, "reading": true}) {
+		t.Errorf("only consumed shards no longer in the stream should be pruned, got: %v", seen)
+	}
+}
@@ -0,0 +1,192 @@
+package dynamodbstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// EnsureTable creates the configured table if it doesn't exist yet and
+// waits for it to become available. The table is created using BillingMode,
+// ReadCapacity, WriteCapacity, TableSSEType, and TableKmsKeyID, along with
+// the ContentHashIndex if one is configured. An existing table is left as it is.
+func (s *Storage) EnsureTable(ctx context.Context) error {
+	if err := s.initConfig(); err != nil {
+		return err
+	}
+
+	if s.ReadOnly {
+		return ErrReadOnly
+	}
+
+	svc := s.client()
+	_, err := svc.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.Table),
+	})
+	if err == nil {
+		return nil
+	}
+	if !isResourceNotFound(err) {
+		return err
+	}
+
+	input, err := s.createTableInput()
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.CreateTableWithContext(ctx, input)
+	if err != nil {
+		return err
+	}
+
+	return svc.WaitUntilTableExistsWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.Table),
+	})
+}
+
+// DeleteTable deletes the configured table, along with every value and lock
+// stored in it, and waits for the deletion to complete. This is destructive and
+// can't be undone, so it is meant for tearing down tests or decommissioning a
+// table that is no longer needed. A table that doesn't exist is left as it is.
+func (s *Storage) DeleteTable(ctx context.Context) error {
+	if err := s.initConfig(); err != nil {
+		return err
+	}
+
+	if s.ReadOnly {
+		return ErrReadOnly
+	}
+
+	svc := s.client()
+	_, err := svc.DeleteTableWithContext(ctx, &dynamodb.DeleteTableInput{
+		TableName: aws.String(s.Table),
+	})
+	if isResourceNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if s.cache != nil {
+		s.cache.flush()
+	}
+
+	return svc.WaitUntilTableNotExistsWithContext(ctx, &dynamodb.DescribeTableInput{
+		TableName: aws.String(s.Table),
+	})
+}
+
+// createTableInput builds the request used by EnsureTable to create the table
+func (s *Storage) createTableInput() (*dynamodb.CreateTableInput, error) {
+	input := &dynamodb.CreateTableInput{
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String(s.PrimaryKeyAttribute),
+				AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+			},
+		},
+		KeySchema: []*dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String(s.PrimaryKeyAttribute),
+				KeyType:       aws.String(dynamodb.KeyTypeHash),
+			},
+		},
+		TableName: aws.String(s.Table),
+	}
+	if s.SortKeyAttribute != "" {
+		input.AttributeDefinitions = append(input.AttributeDefinitions, &dynamodb.AttributeDefinition{
+			AttributeName: aws.String(s.SortKeyAttribute),
+			AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+		})
+		input.KeySchema = append(input.KeySchema, &dynamodb.KeySchemaElement{
+			AttributeName: aws.String(s.SortKeyAttribute),
+			KeyType:       aws.String(dynamodb.KeyTypeRange),
+		})
+	}
+
+	switch s.BillingMode {
+	case "", dynamodb.BillingModePayPerRequest:
+		if s.ReadCapacity != 0 || s.WriteCapacity != 0 {
+			return nil, errors.New("config error: read and write capacity can only be set when billing mode is " +
+				dynamodb.BillingModeProvisioned)
+		}
+		input.BillingMode = aws.String(dynamodb.BillingModePayPerRequest)
+	case dynamodb.BillingModeProvisioned:
+		if s.ReadCapacity <= 0 || s.WriteCapacity <= 0 {
+			return nil, errors.New("config error: read and write capacity must both be greater than zero when billing mode is " +
+				dynamodb.BillingModeProvisioned)
+		}
+		input.BillingMode = aws.String(dynamodb.BillingModeProvisioned)
+		input.ProvisionedThroughput = &dynamodb.ProvisionedThroughput{
+			ReadCapacityUnits:  aws.Int64(s.ReadCapacity),
+			WriteCapacityUnits: aws.Int64(s.WriteCapacity),
+		}
+	default:
+		return nil, fmt.Errorf("config error: unsupported billing mode %q, must be %s or %s",
+			s.BillingMode, dynamodb.BillingModePayPerRequest, dynamodb.BillingModeProvisioned)
+	}
+
+	if s.ContentHashIndex != "" {
+		input.AttributeDefinitions = append(input.AttributeDefinitions, &dynamodb.AttributeDefinition{
+			AttributeName: aws.String(contentHashAttribute),
+			AttributeType: aws.String(dynamodb.ScalarAttributeTypeS),
+		})
+		input.GlobalSecondaryIndexes = []*dynamodb.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String(s.ContentHashIndex),
+				KeySchema: []*dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String(contentHashAttribute),
+						KeyType:       aws.String(dynamodb.KeyTypeHash),
+					},
+				},
+				// the keys are all FindByContentHash needs
+				Projection: &dynamodb.Projection{
+					ProjectionType: aws.String(dynamodb.ProjectionTypeKeysOnly),
+				},
+				ProvisionedThroughput: input.ProvisionedThroughput,
+			},
+		}
+		if s.KeyEncoding == "hex-sha256" {
+			// along with the key that was hashed
+			input.GlobalSecondaryIndexes[0].Projection = &dynamodb.Projection{
+				ProjectionType:   aws.String(dynamodb.ProjectionTypeInclude),
+				NonKeyAttributes: []*string{aws.String(keyAttribute)},
+			}
+		}
+	}
+
+	switch s.TableSSEType {
+	case "":
+		if s.TableKmsKeyID != "" {
+			return nil, errors.New("config error: a KMS key ID can only be set when the SSE type is " + dynamodb.SSETypeKms)
+		}
+	case dynamodb.SSETypeAes256:
+		if s.TableKmsKeyID != "" {
+			return nil, errors.New("config error: a KMS key ID can only be set when the SSE type is " + dynamodb.SSETypeKms)
+		}
+		// DynamoDB's default encryption with a key owned by AWS
+		input.SSESpecification = &dynamodb.SSESpecification{
+			Enabled: aws.Bool(false),
+		}
+	case dynamodb.SSETypeKms:
+		if s.TableKmsKeyID == "" {
+			return nil, errors.New("config error: a KMS key ID is required when the SSE type is " + dynamodb.SSETypeKms)
+		}
+		input.SSESpecification = &dynamodb.SSESpecification{
+			Enabled:        aws.Bool(true),
+			KMSMasterKeyId: aws.String(s.TableKmsKeyID),
+			SSEType:        aws.String(dynamodb.SSETypeKms),
+		}
+	default:
+		return nil, fmt.Errorf("config error: unsupported SSE type %q, must be %s or %s",
+			s.TableSSEType, dynamodb.SSETypeAes256, dynamodb.SSETypeKms)
+	}
+
+	return input, nil
+}
@@ -0,0 +1,516 @@
+package dynamodbstorage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+func TestDynamoDBStorage_createTableInput(t *testing.T) {
+	tests := []struct {
+		name           string
+		storage        *Storage
+		wantErr        bool
+		wantMode       string
+		wantThroughput *dynamodb.ProvisionedThroughput
+		wantSSE        *dynamodb.SSESpecification
+	}{
+		{
+			name:     "default is on-demand",
+			storage:  &Storage{Table: TestTableName},
+			wantMode: dynamodb.BillingModePayPerRequest,
+		},
+		{
+			name:     "on-demand",
+			storage:  &Storage{Table: TestTableName, BillingMode: dynamodb.BillingModePayPerRequest},
+			wantMode: dynamodb.BillingModePayPerRequest,
+		},
+		{
+			name:    "on-demand with capacity should error",
+			storage: &Storage{Table: TestTableName, BillingMode: dynamodb.BillingModePayPerRequest, ReadCapacity: 5},
+			wantErr: true,
+		},
+		{
+			name:    "default mode with capacity should error",
+			storage: &Storage{Table: TestTableName, WriteCapacity: 5},
+			wantErr: true,
+		},
+		{
+			name: "provisioned",
+			storage: &Storage{
+				Table:         TestTableName,
+				BillingMode:   dynamodb.BillingModeProvisioned,
+				ReadCapacity:  3,
+				WriteCapacity: 4,
+			},
+			wantMode: dynamodb.BillingModeProvisioned,
+			wantThroughput: &dynamodb.ProvisionedThroughput{
+				ReadCapacityUnits:  aws.Int64(3),
+				WriteCapacityUnits: aws.Int64(4),
+			},
+		},
+		{
+			name:    "provisioned without capacity should error",
+			storage: &Storage{Table: TestTableName, BillingMode: dynamodb.BillingModeProvisioned, ReadCapacity: 3},
+			wantErr: true,
+		},
+		{
+			name:    "unknown billing mode should error",
+			storage: &Storage{Table: TestTableName, BillingMode: "FREE"},
+			wantErr: true,
+		},
+		{
+			name:     "AWS owned key",
+			storage:  &Storage{Table: TestTableName, TableSSEType: dynamodb.SSETypeAes256},
+			wantMode: dynamodb.BillingModePayPerRequest,
+			wantSSE:  &dynamodb.SSESpecification{Enabled: aws.Bool(false)},
+		},
+		{
+			name:     "KMS key",
+			storage:  &Storage{Table: TestTableName, TableSSEType: dynamodb.SSETypeKms, TableKmsKeyID: "alias/certs"},
+			wantMode: dynamodb.BillingModePayPerRequest,
+			wantSSE: &dynamodb.SSESpecification{
+				Enabled:        aws.Bool(true),
+				KMSMasterKeyId: aws.String("alias/certs"),
+				SSEType:        aws.String(dynamodb.SSETypeKms),
+			},
+		},
+		{
+			name:    "KMS without key ID should error",
+			storage: &Storage{Table: TestTableName, TableSSEType: dynamodb.SSETypeKms},
+			wantErr: true,
+		},
+		{
+			name:    "key ID without KMS should error",
+			storage: &Storage{Table: TestTableName, TableKmsKeyID: "alias/certs"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown SSE type should error",
+			storage: &Storage{Table: TestTableName, TableSSEType: "ROT13"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.storage.initConfig(); err != nil {
+				t.Errorf("initConfig() error = %v", err)
+				return
+			}
+			input, err := tt.storage.createTableInput()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("createTableInput() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if aws.StringValue(input.TableName) != TestTableName {
+				t.Errorf("table name does not match expected. got: %s", aws.StringValue(input.TableName))
+			}
+			if aws.StringValue(input.BillingMode) != tt.wantMode {
+				t.Errorf("billing mode does not match expected. expected: %s, got: %s",
+					tt.wantMode, aws.StringValue(input.BillingMode))
+			}
+			if !reflect.DeepEqual(input.ProvisionedThroughput, tt.wantThroughput) {
+				t.Errorf("provisioned throughput does not match expected. expected: %s, got: %s",
+					tt.wantThroughput, input.ProvisionedThroughput)
+			}
+			if !reflect.DeepEqual(input.SSESpecification, tt.wantSSE) {
+				t.Errorf("SSE specification does not match expected. expected: %s, got: %s",
+					tt.wantSSE, input.SSESpecification)
+			}
+			if err := input.Validate(); err != nil {
+				t.Errorf("generated input is not valid: %s", err.Error())
+			}
+		})
+	}
+}
+
+func TestDynamoDBStorage_CustomAttributeNames(t *testing.T) {
+	storage := Storage{
+		Table:                "CertMagicAttributeNamesTest",
+		AwsEndpoint:          os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:            os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:        DisableSSL,
+		PrimaryKeyAttribute:  "pk",
+		ContentsAttribute:    "data",
+		LastUpdatedAttribute: "updated",
+		LockIDAttribute:      "lock_id",
+		ExpiresAtAttribute:   "ttl",
+	}
+	if err := storage.initConfig(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := deleteTable(storage.AwsSession, storage.Table); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := storage.EnsureTable(context.Background()); err != nil {
+		t.Errorf("failed to create table: %s", err.Error())
+		return
+	}
+	defer deleteTable(storage.AwsSession, storage.Table)
+
+	ctx := context.Background()
+	if err := storage.Store(ctx, "dir/key", []byte("value")); err != nil {
+		t.Errorf("error storing: %s", err.Error())
+		return
+	}
+
+	value, err := storage.Load(ctx, "dir/key")
+	if err != nil {
+		t.Errorf("error loading: %s", err.Error())
+		return
+	}
+	if string(value) != "value" {
+		t.Errorf("loaded value does not match, expected: value, got: %s", value)
+	}
+
+	keys, err := storage.List(ctx, "dir/", false)
+	if err != nil {
+		t.Errorf("error listing: %s", err.Error())
+		return
+	}
+	if !reflect.DeepEqual(keys, []string{"dir/key"}) {
+		t.Errorf("listed keys do not match, got: %v", keys)
+	}
+
+	info, err := storage.Stat(ctx, "dir/key")
+	if err != nil {
+		t.Errorf("error getting stat: %s", err.Error())
+		return
+	}
+	if info.Modified.IsZero() {
+		t.Errorf("modified time should be read from the custom attribute")
+	}
+
+	if err := storage.Lock(ctx, "dir/key"); err != nil {
+		t.Errorf("error locking: %s", err.Error())
+		return
+	}
+	if err := storage.StoreWithTTL(ctx, "dir/expiring", []byte("value"), time.Hour); err != nil {
+		t.Errorf("error storing with TTL: %s", err.Error())
+		return
+	}
+
+	// locks and expiring values both record their expiry in the configured attribute
+	svc := dynamodb.New(storage.AwsSession)
+	for _, key := range []string{"LOCK-dir/key", "dir/expiring"} {
+		result, err := svc.GetItem(&dynamodb.GetItemInput{
+			Key:       map[string]*dynamodb.AttributeValue{"pk": {S: aws.String(key)}},
+			TableName: aws.String(storage.Table),
+		})
+		if err != nil {
+			t.Errorf("error getting raw item: %s", err.Error())
+			return
+		}
+		expiresAt, err := strconv.ParseInt(aws.StringValue(result.Item["ttl"].N), 10, 64)
+		if err != nil || expiresAt <= time.Now().Unix() {
+			t.Errorf("%s should expire in the future according to ttl, got: %v", key, result.Item)
+		}
+		if _, ok := result.Item[expiresAtAttribute]; ok {
+			t.Errorf("%s should not use the default expiry attribute: %v", key, result.Item)
+		}
+	}
+
+	if err := storage.Unlock(ctx, "dir/key"); err != nil {
+		t.Errorf("error unlocking: %s", err.Error())
+		return
+	}
+
+	// check the raw item uses the configured names
+	result, err := dynamodb.New(storage.AwsSession).GetItem(&dynamodb.GetItemInput{
+		Key: map[string]*dynamodb.AttributeValue{
+			"pk": {S: aws.String("dir/key")},
+		},
+		TableName: aws.String(storage.Table),
+	})
+	if err != nil {
+		t.Errorf("error getting raw item: %s", err.Error())
+		return
+	}
+	for _, name := range []string{"pk", "data", "updated"} {
+		if _, ok := result.Item[name]; !ok {
+			t.Errorf("stored item is missing attribute %s: %v", name, result.Item)
+		}
+	}
+	if _, ok := result.Item[contentsAttribute]; ok {
+		t.Errorf("stored item should not use the default contents attribute: %v", result.Item)
+	}
+
+	if err := storage.Delete(ctx, "dir/key"); err != nil {
+		t.Errorf("error deleting: %s", err.Error())
+	}
+}
+
+func TestDynamoDBStorage_SortKey(t *testing.T) {
+	storage := Storage{
+		Table:            "CertMagicSortKeyTest",
+		AwsEndpoint:      os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:        os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:    DisableSSL,
+		SortKeyAttribute: "SortKey",
+		SortKeyValue:     "certmagic",
+	}
+	if err := storage.initConfig(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := deleteTable(storage.AwsSession, storage.Table); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := storage.EnsureTable(context.Background()); err != nil {
+		t.Errorf("failed to create table: %s", err.Error())
+		return
+	}
+	defer deleteTable(storage.AwsSession, storage.Table)
+
+	// other application data sharing the table
+	svc := dynamodb.New(storage.AwsSession)
+	for _, key := range []string{"dir/key", "dir/other"} {
+		_, err := svc.PutItem(&dynamodb.PutItemInput{
+			Item: map[string]*dynamodb.AttributeValue{
+				primaryKeyAttribute: {S: aws.String(key)},
+				"SortKey":           {S: aws.String("other-app")},
+			},
+			TableName: aws.String(storage.Table),
+		})
+		if err != nil {
+			t.Errorf("error storing other data: %s", err.Error())
+			return
+		}
+	}
+
+	ctx := context.Background()
+	if err := storage.Store(ctx, "dir/key", []byte("value")); err != nil {
+		t.Errorf("error storing: %s", err.Error())
+		return
+	}
+
+	value, err := storage.Load(ctx, "dir/key")
+	if err != nil {
+		t.Errorf("error loading: %s", err.Error())
+		return
+	}
+	if string(value) != "value" {
+		t.Errorf("loaded value does not match, expected: value, got: %s", value)
+	}
+
+	keys, err := storage.List(ctx, "dir/", false)
+	if err != nil {
+		t.Errorf("error listing: %s", err.Error())
+		return
+	}
+	if !reflect.DeepEqual(keys, []string{"dir/key"}) {
+		t.Errorf("listed keys should not include other data, got: %v", keys)
+	}
+
+	if err := storage.Lock(ctx, "dir/key"); err != nil {
+		t.Errorf("error locking: %s", err.Error())
+		return
+	}
+	if err := storage.Unlock(ctx, "dir/key"); err != nil {
+		t.Errorf("error unlocking: %s", err.Error())
+		return
+	}
+
+	if err := storage.Delete(ctx, "dir/key"); err != nil {
+		t.Errorf("error deleting: %s", err.Error())
+		return
+	}
+	if storage.Exists(ctx, "dir/key") {
+		t.Errorf("deleted key should not exist")
+	}
+
+	result, err := svc.Scan(&dynamodb.ScanInput{TableName: aws.String(storage.Table)})
+	if err != nil {
+		t.Errorf("error scanning table: %s", err.Error())
+		return
+	}
+	if aws.Int64Value(result.Count) != 2 {
+		t.Errorf("other data should be left alone, table holds: %v", result.Items)
+	}
+}
+
+func TestDynamoDBStorage_createTableInputContentHashIndex(t *testing.T) {
+	storage := Storage{
+		Table:            TestTableName,
+		ContentHashIndex: "ContentHashIndex",
+		BillingMode:      dynamodb.BillingModeProvisioned,
+		ReadCapacity:     3,
+		WriteCapacity:    4,
+	}
+	if err := storage.initConfig(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	input, err := storage.createTableInput()
+	if err != nil {
+		t.Errorf("createTableInput() error = %v", err)
+		return
+	}
+	if len(input.GlobalSecondaryIndexes) != 1 {
+		t.Errorf("expected one index, got: %v", input.GlobalSecondaryIndexes)
+		return
+	}
+	index := input.GlobalSecondaryIndexes[0]
+	if aws.StringValue(index.IndexName) != "ContentHashIndex" ||
+		aws.StringValue(index.KeySchema[0].AttributeName) != contentHashAttribute {
+		t.Errorf("index does not match expected, got: %s", index)
+	}
+	if !reflect.DeepEqual(index.ProvisionedThroughput, input.ProvisionedThroughput) {
+		t.Errorf("index should have the table's capacity, got: %s", index.ProvisionedThroughput)
+	}
+	if err := input.Validate(); err != nil {
+		t.Errorf("generated input is not valid: %s", err.Error())
+	}
+}
+
+func TestDynamoDBStorage_FindByContentHash(t *testing.T) {
+	storage := Storage{
+		Table:            "CertMagicContentHashTest",
+		AwsEndpoint:      os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:        os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL:    DisableSSL,
+		ContentHashIndex: "ContentHashIndex",
+	}
+	if err := storage.initConfig(); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := deleteTable(storage.AwsSession, storage.Table); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := storage.EnsureTable(context.Background()); err != nil {
+		t.Errorf("failed to create table: %s", err.Error())
+		return
+	}
+
+	ctx := context.Background()
+	for key, value := range map[string]string{
+		"certificates/a.example.com/a.crt": "shared",
+		"certificates/b.example.com/b.crt": "shared",
+		"certificates/c.example.com/c.crt": "other",
+	} {
+		if err := storage.Store(ctx, key, []byte(value)); err != nil {
+			t.Errorf("failed to store %s: %s", key, err.Error())
+			return
+		}
+	}
+
+	sum := sha256.Sum256([]byte("shared"))
+	keys, err := storage.FindByContentHash(ctx, hex.EncodeToString(sum[:]))
+	if err != nil {
+		t.Errorf("failed to find by content hash: %s", err.Error())
+		return
+	}
+	sort.Strings(keys)
+	want := []string{"certificates/a.example.com/a.crt", "certificates/b.example.com/b.crt"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("keys do not match, expected: %v, got: %v", want, keys)
+	}
+
+	sum = sha256.Sum256([]byte("missing"))
+	if keys, err := storage.FindByContentHash(ctx, hex.EncodeToString(sum[:])); err != nil || len(keys) != 0 {
+		t.Errorf("expected no keys, got: %v, %v", keys, err)
+	}
+
+	storage.ContentHashIndex = ""
+	if _, err := storage.FindByContentHash(ctx, hex.EncodeToString(sum[:])); err == nil {
+		t.Errorf("expected an error without a content hash index")
+	}
+}
+
+func TestDynamoDBStorage_EnsureTable(t *testing.T) {
+	storage := Storage{
+		Table:         "CertMagicEnsureTableTest",
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	if err := storage.initConfig(); err != nil {
+		t.Error(err)
+		return
+	}
+
+	// make sure the table doesn't exist yet
+	err := deleteTable(storage.AwsSession, storage.Table)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// once to create it, and again when it already exists
+	for i := 0; i < 2; i++ {
+		err = storage.EnsureTable(context.Background())
+		if err != nil {
+			t.Errorf("failed to ensure table exists: %s", err.Error())
+			return
+		}
+	}
+
+	err = storage.Store(context.Background(), "key", []byte("value"))
+	if err != nil {
+		t.Errorf("failed to store to created table: %s", err.Error())
+	}
+}
+
+func TestDynamoDBStorage_DeleteTable(t *testing.T) {
+	storage := Storage{
+		Table:         "CertMagicDeleteTableTest",
+		AwsEndpoint:   os.Getenv("AWS_ENDPOINT"),
+		AwsRegion:     os.Getenv("AWS_DEFAULT_REGION"),
+		AwsDisableSSL: DisableSSL,
+	}
+	if err := storage.EnsureTable(context.Background()); err != nil {
+		t.Errorf("failed to create table: %s", err.Error())
+		return
+	}
+
+	// once to delete it, and again when it no longer exists
+	for i := 0; i < 2; i++ {
+		if err := storage.DeleteTable(context.Background()); err != nil {
+			t.Errorf("failed to delete table: %s", err.Error())
+			return
+		}
+	}
+
+	_, err := storage.client().DescribeTableWithContext(context.Background(), &dynamodb.DescribeTableInput{
+		TableName: aws.String(storage.Table),
+	})
+	if !isResourceNotFound(err) {
+		t.Errorf("expected the table to be gone, got: %v", err)
+	}
+
+	storage.ReadOnly = true
+	if err := storage.DeleteTable(context.Background()); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("expected ErrReadOnly, got: %v", err)
+	}
+}
+
+func deleteTable(sess *session.Session, table string) error {
+	svc := dynamodb.New(sess)
+	_, err := svc.DeleteTable(&dynamodb.DeleteTableInput{
+		TableName: aws.String(table),
+	})
+	if isResourceNotFound(err) {
+		return nil
+	}
+	return err
+}